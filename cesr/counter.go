@@ -0,0 +1,350 @@
+package cesr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Counter codes, following the keripy CtrDex table for CESR version 1.
+const (
+	ControllerIdxSigs           = "-A"    // indexed controller signatures
+	WitnessIdxSigs              = "-B"    // indexed witness signatures
+	NonTransReceiptCouples      = "-C"    // non-transferable receipt couples: verfer, cigar
+	TransReceiptQuadruples      = "-D"    // transferable receipt quadruples: prefixer, seqner, saider, siger
+	FirstSeenReplayCouples      = "-E"    // first seen replay couples: seqner, dater
+	TransIdxSigGroups           = "-F"    // transferable indexed signature groups: prefixer, seqner, saider, -A group
+	SealSourceCouples           = "-G"    // seal source couples: seqner, saider
+	TransLastIdxSigGroups       = "-H"    // transferable last indexed signature groups: prefixer, -A group
+	SealSourceTriples           = "-I"    // seal source triples: prefixer, seqner, saider
+	AttachedMaterialQuadlets    = "-V"    // attachment group counted in quadlets
+	BigAttachedMaterialQuadlets = "-0V"   // big attachment group counted in quadlets
+	KERIProtocolStack           = "--AAA" // protocol stack version
+	KERIACDCGenusVersion        = "-_AAA" // KERI/ACDC genus and version
+)
+
+// CounterSizes is the Counter code table. Counters have no raw part, so Fs is
+// always Hs+Ss.
+var CounterSizes = map[string]Sizage{
+	ControllerIdxSigs:           {Hs: 2, Ss: 2, Fs: 4},
+	WitnessIdxSigs:              {Hs: 2, Ss: 2, Fs: 4},
+	NonTransReceiptCouples:      {Hs: 2, Ss: 2, Fs: 4},
+	TransReceiptQuadruples:      {Hs: 2, Ss: 2, Fs: 4},
+	FirstSeenReplayCouples:      {Hs: 2, Ss: 2, Fs: 4},
+	TransIdxSigGroups:           {Hs: 2, Ss: 2, Fs: 4},
+	SealSourceCouples:           {Hs: 2, Ss: 2, Fs: 4},
+	TransLastIdxSigGroups:       {Hs: 2, Ss: 2, Fs: 4},
+	SealSourceTriples:           {Hs: 2, Ss: 2, Fs: 4},
+	AttachedMaterialQuadlets:    {Hs: 2, Ss: 2, Fs: 4},
+	BigAttachedMaterialQuadlets: {Hs: 3, Ss: 5, Fs: 8},
+	KERIProtocolStack:           {Hs: 5, Ss: 3, Fs: 8},
+	KERIACDCGenusVersion:        {Hs: 5, Ss: 3, Fs: 8},
+}
+
+// counterHardSize returns the hard size of the Counter code at the start of
+// stream, which always starts with "-".
+func counterHardSize(stream string) (int, bool) {
+	if len(stream) < 2 || stream[0] != '-' {
+		return 0, false
+	}
+	switch c := stream[1]; {
+	case c == '-' || c == '_':
+		return 5, true
+	case c == '0':
+		return 3, true
+	case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		return 2, true
+	}
+	return 0, false
+}
+
+// Counter frames a group of primitives. Count is the number of items in the
+// group, or the number of quadlets for the attachment group codes.
+type Counter struct {
+	Code  string
+	Count int
+}
+
+// NewCounter validates count against code. AttachedMaterialQuadlets is
+// replaced by BigAttachedMaterialQuadlets when the count does not fit.
+func NewCounter(code string, count int) (*Counter, error) {
+	sizage, ok := CounterSizes[code]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CESR counter code %s", code)
+	}
+	if code == AttachedMaterialQuadlets && count >= 1<<(6*sizage.Ss) {
+		code, sizage = BigAttachedMaterialQuadlets, CounterSizes[BigAttachedMaterialQuadlets]
+	}
+	if count < 0 || count >= 1<<(6*sizage.Ss) {
+		return nil, fmt.Errorf("count %d out of range for code %s", count, code)
+	}
+	return &Counter{Code: code, Count: count}, nil
+}
+
+// Qb64 returns the text domain encoding of c.
+func (c *Counter) Qb64() string {
+	return c.Code + intToB64(c.Count, CounterSizes[c.Code].Ss)
+}
+
+// ParseCounter decodes the qb64 counter at the start of stream and returns it
+// along with the number of characters it occupies.
+func ParseCounter(stream string) (*Counter, int, error) {
+	hs, ok := counterHardSize(stream)
+	if !ok {
+		return nil, 0, fmt.Errorf("no CESR counter at %.4q", stream)
+	}
+	if len(stream) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(stream))
+	}
+	code := stream[:hs]
+	sizage, ok := CounterSizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR counter code %s", code)
+	}
+	if len(stream) < sizage.Fs {
+		return nil, 0, fmt.Errorf("expected length %d for code %s, got %d", sizage.Fs, code, len(stream))
+	}
+	soft := stream[hs:sizage.Fs]
+	if !isB64(soft) {
+		return nil, 0, fmt.Errorf("invalid soft part %s for code %s", soft, code)
+	}
+	return &Counter{Code: code, Count: b64ToInt(soft)}, sizage.Fs, nil
+}
+
+// Receipt is a transferable receipt quadruple of a -D group.
+type Receipt struct {
+	Prefixer *Matter
+	Seqner   *Matter
+	Saider   *Matter
+	Siger    *Indexer
+}
+
+// SigGroup is a group of indexed signatures by a transferable identifier, as
+// found in -F groups and, without Seqner and Saider, in -H groups.
+type SigGroup struct {
+	Prefixer *Matter
+	Seqner   *Matter
+	Saider   *Matter
+	Sigers   []*Indexer
+}
+
+// Group is a counted group of attachments. Which of the fields is set depends
+// on the counter code.
+type Group struct {
+	Counter *Counter
+	// Sigers holds the signatures of -A and -B groups.
+	Sigers []*Indexer
+	// Couples holds -C, -E and -G couples.
+	Couples [][2]*Matter
+	// Triples holds -I triples.
+	Triples [][3]*Matter
+	// Receipts holds -D quadruples.
+	Receipts []Receipt
+	// SigGroups holds -F and -H groups.
+	SigGroups []SigGroup
+	// Groups holds the groups nested in -V and -0V attachment groups.
+	Groups []Group
+}
+
+// Qb64 returns the text domain encoding of g, recomputing the count from its
+// contents.
+func (g *Group) Qb64() (string, error) {
+	var sb strings.Builder
+	count := 0
+	switch g.Counter.Code {
+	case ControllerIdxSigs, WitnessIdxSigs:
+		count = len(g.Sigers)
+		writeSigers(&sb, g.Sigers)
+	case NonTransReceiptCouples, FirstSeenReplayCouples, SealSourceCouples:
+		count = len(g.Couples)
+		for _, c := range g.Couples {
+			sb.WriteString(c[0].Qb64() + c[1].Qb64())
+		}
+	case SealSourceTriples:
+		count = len(g.Triples)
+		for _, t := range g.Triples {
+			sb.WriteString(t[0].Qb64() + t[1].Qb64() + t[2].Qb64())
+		}
+	case TransReceiptQuadruples:
+		count = len(g.Receipts)
+		for _, r := range g.Receipts {
+			sb.WriteString(r.Prefixer.Qb64() + r.Seqner.Qb64() + r.Saider.Qb64() + r.Siger.Qb64())
+		}
+	case TransIdxSigGroups, TransLastIdxSigGroups:
+		count = len(g.SigGroups)
+		for _, sg := range g.SigGroups {
+			sb.WriteString(sg.Prefixer.Qb64())
+			if g.Counter.Code == TransIdxSigGroups {
+				sb.WriteString(sg.Seqner.Qb64() + sg.Saider.Qb64())
+			}
+			sb.WriteString((&Counter{Code: ControllerIdxSigs, Count: len(sg.Sigers)}).Qb64())
+			writeSigers(&sb, sg.Sigers)
+		}
+	case AttachedMaterialQuadlets, BigAttachedMaterialQuadlets:
+		for i := range g.Groups {
+			s, err := g.Groups[i].Qb64()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		}
+		count = sb.Len() / 4
+	case KERIProtocolStack, KERIACDCGenusVersion:
+		count = g.Counter.Count
+	default:
+		return "", fmt.Errorf("unsupported CESR counter code %s", g.Counter.Code)
+	}
+	counter, err := NewCounter(g.Counter.Code, count)
+	if err != nil {
+		return "", err
+	}
+	return counter.Qb64() + sb.String(), nil
+}
+
+func writeSigers(sb *strings.Builder, sigers []*Indexer) {
+	for _, siger := range sigers {
+		sb.WriteString(siger.Qb64())
+	}
+}
+
+// ParseAttachments walks a stream of counted attachment groups.
+func ParseAttachments(stream string) ([]Group, error) {
+	var groups []Group
+	for stream != "" {
+		g, n, err := ParseGroup(stream)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *g)
+		stream = stream[n:]
+	}
+	return groups, nil
+}
+
+// ParseGroup decodes the counted group at the start of stream and returns it
+// along with the number of characters it occupies.
+func ParseGroup(stream string) (*Group, int, error) {
+	counter, n, err := ParseCounter(stream)
+	if err != nil {
+		return nil, 0, err
+	}
+	g := &Group{Counter: counter}
+	p := &groupParser{stream: stream, pos: n}
+
+	switch counter.Code {
+	case ControllerIdxSigs, WitnessIdxSigs:
+		g.Sigers, err = p.sigers(counter.Count)
+	case NonTransReceiptCouples, FirstSeenReplayCouples, SealSourceCouples:
+		for i := 0; i < counter.Count && err == nil; i++ {
+			var c [2]*Matter
+			if c[0], err = p.matter(); err == nil {
+				c[1], err = p.matter()
+			}
+			g.Couples = append(g.Couples, c)
+		}
+	case SealSourceTriples:
+		for i := 0; i < counter.Count && err == nil; i++ {
+			var t [3]*Matter
+			for j := 0; j < 3 && err == nil; j++ {
+				t[j], err = p.matter()
+			}
+			g.Triples = append(g.Triples, t)
+		}
+	case TransReceiptQuadruples:
+		for i := 0; i < counter.Count && err == nil; i++ {
+			var r Receipt
+			if r.Prefixer, err = p.matter(); err != nil {
+				break
+			}
+			if r.Seqner, err = p.matter(); err != nil {
+				break
+			}
+			if r.Saider, err = p.matter(); err != nil {
+				break
+			}
+			r.Siger, err = p.indexer()
+			g.Receipts = append(g.Receipts, r)
+		}
+	case TransIdxSigGroups, TransLastIdxSigGroups:
+		for i := 0; i < counter.Count && err == nil; i++ {
+			var sg SigGroup
+			sg, err = p.sigGroup(counter.Code == TransIdxSigGroups)
+			g.SigGroups = append(g.SigGroups, sg)
+		}
+	case AttachedMaterialQuadlets, BigAttachedMaterialQuadlets:
+		end := n + counter.Count*4
+		if end > len(stream) {
+			return nil, 0, fmt.Errorf("attachment group of %d characters exceeds stream", counter.Count*4)
+		}
+		if g.Groups, err = ParseAttachments(stream[n:end]); err == nil {
+			p.pos = end
+		}
+	case KERIProtocolStack, KERIACDCGenusVersion:
+	default:
+		return nil, 0, fmt.Errorf("unsupported CESR counter code %s", counter.Code)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("group %s: %w", counter.Code, err)
+	}
+	return g, p.pos, nil
+}
+
+type groupParser struct {
+	stream string
+	pos    int
+}
+
+func (p *groupParser) matter() (*Matter, error) {
+	m, n, err := ParseMatter(p.stream[p.pos:])
+	if err != nil {
+		return nil, err
+	}
+	p.pos += n
+	return m, nil
+}
+
+func (p *groupParser) indexer() (*Indexer, error) {
+	i, n, err := ParseIndexer(p.stream[p.pos:])
+	if err != nil {
+		return nil, err
+	}
+	p.pos += n
+	return i, nil
+}
+
+func (p *groupParser) sigers(count int) ([]*Indexer, error) {
+	sigers := make([]*Indexer, 0, count)
+	for i := 0; i < count; i++ {
+		siger, err := p.indexer()
+		if err != nil {
+			return nil, err
+		}
+		sigers = append(sigers, siger)
+	}
+	return sigers, nil
+}
+
+func (p *groupParser) sigGroup(withSeal bool) (SigGroup, error) {
+	var sg SigGroup
+	var err error
+	if sg.Prefixer, err = p.matter(); err != nil {
+		return sg, err
+	}
+	if withSeal {
+		if sg.Seqner, err = p.matter(); err != nil {
+			return sg, err
+		}
+		if sg.Saider, err = p.matter(); err != nil {
+			return sg, err
+		}
+	}
+	counter, n, err := ParseCounter(p.stream[p.pos:])
+	if err != nil {
+		return sg, err
+	}
+	if counter.Code != ControllerIdxSigs {
+		return sg, fmt.Errorf("expected %s signatures, got %s", ControllerIdxSigs, counter.Code)
+	}
+	p.pos += n
+	sg.Sigers, err = p.sigers(counter.Count)
+	return sg, err
+}
@@ -0,0 +1,134 @@
+package cesr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	testCases := []struct {
+		code     string
+		count    int
+		expected string
+	}{
+		{ControllerIdxSigs, 1, "-AAB"},
+		{WitnessIdxSigs, 64, "-BBA"},
+		{AttachedMaterialQuadlets, 4095, "-V__"},
+		{AttachedMaterialQuadlets, 4096, "-0VAABAA"},
+		{KERIACDCGenusVersion, 4096, "-_AAABAA"},
+	}
+	for _, tc := range testCases {
+		c, err := NewCounter(tc.code, tc.count)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, c.Qb64())
+
+		parsed, n, err := ParseCounter(tc.expected + "AAAA")
+		require.NoError(t, err)
+		assert.Equal(t, len(tc.expected), n)
+		assert.Equal(t, c, parsed)
+	}
+}
+
+func TestCounterErrors(t *testing.T) {
+	_, err := NewCounter("-Z", 1)
+	assert.ErrorContains(t, err, "unsupported")
+
+	_, err = NewCounter(ControllerIdxSigs, 4096)
+	assert.ErrorContains(t, err, "out of range")
+
+	_, _, err = ParseCounter("AAAA")
+	assert.ErrorContains(t, err, "no CESR counter")
+
+	_, _, err = ParseCounter("-0V")
+	assert.ErrorContains(t, err, "expected length 8")
+}
+
+func testSiger(t *testing.T, index int) *Indexer {
+	siger, err := NewIndexer(Ed25519IdxSig, index, index, TESTBytes64)
+	require.NoError(t, err)
+	return siger
+}
+
+func testMatter(t *testing.T, code string, raw []byte) *Matter {
+	m, err := NewMatter(code, raw)
+	require.NoError(t, err)
+	return m
+}
+
+func TestParseAttachments(t *testing.T) {
+	prefixer := testMatter(t, Blake3_256, TESTBytes32)
+	seqner := testMatter(t, Salt128, make([]byte, 16))
+	sigs := Group{Counter: &Counter{Code: ControllerIdxSigs}, Sigers: []*Indexer{testSiger(t, 0), testSiger(t, 1)}}
+	couples := Group{Counter: &Counter{Code: NonTransReceiptCouples}, Couples: [][2]*Matter{
+		{testMatter(t, Ed25519N, TESTBytes32), testMatter(t, Ed25519Sig, TESTBytes64)},
+	}}
+	receipts := Group{Counter: &Counter{Code: TransReceiptQuadruples}, Receipts: []Receipt{
+		{Prefixer: prefixer, Seqner: seqner, Saider: prefixer, Siger: testSiger(t, 2)},
+	}}
+	sigGroups := Group{Counter: &Counter{Code: TransIdxSigGroups}, SigGroups: []SigGroup{
+		{Prefixer: prefixer, Seqner: seqner, Saider: prefixer, Sigers: []*Indexer{testSiger(t, 0)}},
+	}}
+	lastSigGroups := Group{Counter: &Counter{Code: TransLastIdxSigGroups}, SigGroups: []SigGroup{
+		{Prefixer: prefixer, Sigers: []*Indexer{testSiger(t, 0), testSiger(t, 1)}},
+	}}
+	attachments := Group{Counter: &Counter{Code: AttachedMaterialQuadlets}, Groups: []Group{sigs, couples, receipts, sigGroups, lastSigGroups}}
+
+	stream, err := attachments.Qb64()
+	require.NoError(t, err)
+	assert.Equal(t, "-V", stream[:2])
+	assert.Equal(t, len(stream)/4-1, b64ToInt(stream[2:4]))
+
+	groups, err := ParseAttachments("-_AAABAA" + stream)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, KERIACDCGenusVersion, groups[0].Counter.Code)
+
+	nested := groups[1].Groups
+	require.Len(t, nested, 5)
+	assert.Equal(t, 2, nested[0].Counter.Count)
+	assert.Equal(t, []*Indexer{testSiger(t, 0), testSiger(t, 1)}, nested[0].Sigers)
+	assert.Equal(t, Ed25519N, nested[1].Couples[0][0].Code)
+	assert.Equal(t, Ed25519Sig, nested[1].Couples[0][1].Code)
+	assert.Equal(t, 2, nested[2].Receipts[0].Siger.Index)
+	assert.Equal(t, prefixer, nested[3].SigGroups[0].Prefixer)
+	assert.Len(t, nested[3].SigGroups[0].Sigers, 1)
+	assert.Nil(t, nested[4].SigGroups[0].Seqner)
+	assert.Len(t, nested[4].SigGroups[0].Sigers, 2)
+
+	again, err := groups[1].Qb64()
+	require.NoError(t, err)
+	assert.Equal(t, stream, again)
+}
+
+func TestParseAttachmentsErrors(t *testing.T) {
+	sigs := Group{Counter: &Counter{Code: ControllerIdxSigs}, Sigers: []*Indexer{testSiger(t, 0)}}
+	stream, err := sigs.Qb64()
+	require.NoError(t, err)
+
+	_, err = ParseAttachments("-AAC" + stream[4:])
+	assert.ErrorContains(t, err, "group -A")
+
+	_, err = ParseAttachments("-VAC" + stream)
+	assert.Error(t, err)
+
+	_, err = ParseAttachments("-VZZ")
+	assert.ErrorContains(t, err, "exceeds stream")
+
+	_, err = ParseAttachments("-HAB" + testMatter(t, Blake3_256, TESTBytes32).Qb64() + "-BAA")
+	assert.ErrorContains(t, err, "expected -A signatures")
+}
+
+func TestParseGroupEmptyWithLeadBytes(t *testing.T) {
+	for _, qb64 := range []string{"5AAA", "6AAA", "8AAAAAAA"} {
+		t.Run(qb64, func(t *testing.T) {
+			_, _, err := ParseGroup("-CAB" + qb64 + qb64)
+			assert.ErrorContains(t, err, "lead bytes")
+			_, _, err = ParseGroup("-CAB" + TESTCesr44 + qb64)
+			assert.ErrorContains(t, err, "lead bytes")
+			_, err = ParseAttachments("-VAD-CAB" + qb64 + qb64)
+			assert.ErrorContains(t, err, "lead bytes")
+		})
+	}
+}
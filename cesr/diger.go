@@ -0,0 +1,53 @@
+package cesr
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/hashes"
+)
+
+// Diger is a Matter holding a digest, such as a SAID.
+type Diger struct {
+	Matter
+}
+
+// NewDiger hashes data with the algorithm of code, e.g. Blake3_256.
+func NewDiger(code string, data []byte) (*Diger, error) {
+	a, ok := hashes.ByCode(code)
+	if !ok {
+		return nil, fmt.Errorf("code %s is not a supported digest", code)
+	}
+	m, err := NewMatter(code, a.Sum(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Diger{Matter: *m}, nil
+}
+
+// DecodeDiger decodes a qb64 digest.
+func DecodeDiger(qb64 string) (*Diger, error) {
+	m, err := DecodeMatter(qb64)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Digestive() {
+		return nil, fmt.Errorf("code %s is not a digest", m.Code)
+	}
+	return &Diger{Matter: *m}, nil
+}
+
+// Algorithm returns the name of the digest algorithm, e.g. "blake3-256".
+func (d *Diger) Algorithm() string {
+	a, _ := hashes.ByCode(d.Code)
+	return a.Name
+}
+
+// Verify reports whether d is the digest of data.
+func (d *Diger) Verify(data []byte) bool {
+	a, ok := hashes.ByCode(d.Code)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare(a.Sum(data), d.Raw) == 1
+}
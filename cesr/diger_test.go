@@ -0,0 +1,36 @@
+package cesr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiger(t *testing.T) {
+	data := []byte(`{"v":"KERI10JSON000000_","t":"icp"}`)
+	for code := range DigestCodes {
+		t.Run(code, func(t *testing.T) {
+			d, err := NewDiger(code, data)
+			require.NoError(t, err)
+			assert.True(t, d.Verify(data))
+			assert.False(t, d.Verify(append(data, ' ')))
+			assert.NotEmpty(t, d.Algorithm())
+
+			decoded, err := DecodeDiger(d.Qb64())
+			require.NoError(t, err)
+			assert.Equal(t, d.Raw, decoded.Raw)
+			assert.True(t, decoded.Verify(data))
+		})
+	}
+
+	d, err := NewDiger(Blake3_256, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "EK8TSbn1-aGmoEBN6jbcyUmbyyXJrcESt8yak8rkHzJi", d.Qb64())
+	assert.Equal(t, "blake3-256", d.Algorithm())
+
+	_, err = NewDiger(Ed25519, data)
+	assert.Error(t, err)
+	_, err = DecodeDiger("BKxy2sgzfplyr-tgwIxS19f2OchFHtLwPWD3v4oYimBx")
+	assert.Error(t, err, "a key is not a digest")
+}
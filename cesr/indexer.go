@@ -0,0 +1,224 @@
+package cesr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Indexer codes, following the keripy IdrDex table. Codes without "Crt" are
+// dual indexed: the index refers to the current key list and the ondex to the
+// prior next key list. "Crt" codes only carry the current index.
+const (
+	Ed25519IdxSig       = "A"  // Ed25519 dual indexed signature
+	Ed25519CrtSig       = "B"  // Ed25519 current only indexed signature
+	ECDSA256k1IdxSig    = "C"  // ECDSA secp256k1 dual indexed signature
+	ECDSA256k1CrtSig    = "D"  // ECDSA secp256k1 current only indexed signature
+	ECDSA256r1IdxSig    = "E"  // ECDSA secp256r1 dual indexed signature
+	ECDSA256r1CrtSig    = "F"  // ECDSA secp256r1 current only indexed signature
+	Ed448IdxSig         = "0A" // Ed448 dual indexed signature
+	Ed448CrtSig         = "0B" // Ed448 current only indexed signature
+	Ed25519BigIdxSig    = "2A" // Ed25519 dual indexed signature, big index
+	Ed25519BigCrtSig    = "2B" // Ed25519 current only indexed signature, big index
+	ECDSA256k1BigIdxSig = "2C" // ECDSA secp256k1 dual indexed signature, big index
+	ECDSA256k1BigCrtSig = "2D" // ECDSA secp256k1 current only indexed signature, big index
+	ECDSA256r1BigIdxSig = "2E" // ECDSA secp256r1 dual indexed signature, big index
+	ECDSA256r1BigCrtSig = "2F" // ECDSA secp256r1 current only indexed signature, big index
+	Ed448BigIdxSig      = "3A" // Ed448 dual indexed signature, big index
+	Ed448BigCrtSig      = "3B" // Ed448 current only indexed signature, big index
+)
+
+// Xizage describes the layout of an Indexer code: hard size, soft size, other
+// (ondex) size within the soft part, full size and lead size.
+type Xizage struct {
+	Hs int
+	Ss int
+	Os int
+	Fs int
+	Ls int
+}
+
+// IndexerHards maps the first character of an Indexer code to its hard size.
+var IndexerHards = func() map[byte]int {
+	h := map[byte]int{}
+	for c := byte('A'); c <= 'Z'; c++ {
+		h[c] = 1
+	}
+	for c := byte('a'); c <= 'z'; c++ {
+		h[c] = 1
+	}
+	for _, c := range []byte("01234") {
+		h[c] = 2
+	}
+	return h
+}()
+
+// IndexerSizes is the Indexer code table.
+var IndexerSizes = map[string]Xizage{
+	Ed25519IdxSig:       {Hs: 1, Ss: 1, Fs: 88},
+	Ed25519CrtSig:       {Hs: 1, Ss: 1, Fs: 88},
+	ECDSA256k1IdxSig:    {Hs: 1, Ss: 1, Fs: 88},
+	ECDSA256k1CrtSig:    {Hs: 1, Ss: 1, Fs: 88},
+	ECDSA256r1IdxSig:    {Hs: 1, Ss: 1, Fs: 88},
+	ECDSA256r1CrtSig:    {Hs: 1, Ss: 1, Fs: 88},
+	Ed448IdxSig:         {Hs: 2, Ss: 2, Os: 1, Fs: 156},
+	Ed448CrtSig:         {Hs: 2, Ss: 2, Os: 1, Fs: 156},
+	Ed25519BigIdxSig:    {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	Ed25519BigCrtSig:    {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	ECDSA256k1BigIdxSig: {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	ECDSA256k1BigCrtSig: {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	ECDSA256r1BigIdxSig: {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	ECDSA256r1BigCrtSig: {Hs: 2, Ss: 4, Os: 2, Fs: 92},
+	Ed448BigIdxSig:      {Hs: 2, Ss: 6, Os: 3, Fs: 160},
+	Ed448BigCrtSig:      {Hs: 2, Ss: 6, Os: 3, Fs: 160},
+}
+
+// bigIndexCodes maps each small index code to its big index variant.
+var bigIndexCodes = map[string]string{
+	Ed25519IdxSig:    Ed25519BigIdxSig,
+	Ed25519CrtSig:    Ed25519BigCrtSig,
+	ECDSA256k1IdxSig: ECDSA256k1BigIdxSig,
+	ECDSA256k1CrtSig: ECDSA256k1BigCrtSig,
+	ECDSA256r1IdxSig: ECDSA256r1BigIdxSig,
+	ECDSA256r1CrtSig: ECDSA256r1BigCrtSig,
+	Ed448IdxSig:      Ed448BigIdxSig,
+	Ed448CrtSig:      Ed448BigCrtSig,
+}
+
+// CurrentOnlyCodes are the Indexer codes without an ondex.
+var CurrentOnlyCodes = map[string]bool{
+	Ed25519CrtSig: true, ECDSA256k1CrtSig: true, ECDSA256r1CrtSig: true, Ed448CrtSig: true,
+	Ed25519BigCrtSig: true, ECDSA256k1BigCrtSig: true, ECDSA256r1BigCrtSig: true, Ed448BigCrtSig: true,
+}
+
+// Indexer is an indexed signature: the signature of the key at Index in the
+// signer's current key list and, for dual indexed codes, at Ondex in the prior
+// next key list. Ondex is zero for current only codes.
+type Indexer struct {
+	Code  string
+	Index int
+	Ondex int
+	Raw   []byte
+}
+
+// NewIndexer validates raw, index and ondex against code. A small index code
+// is replaced by its big variant when the indexes do not fit, or, for codes
+// whose ondex is implied, when ondex differs from index.
+func NewIndexer(code string, index int, ondex int, raw []byte) (*Indexer, error) {
+	xizage, ok := IndexerSizes[code]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CESR indexer code %s", code)
+	}
+	if index < 0 || ondex < 0 {
+		return nil, fmt.Errorf("negative index for code %s", code)
+	}
+	if CurrentOnlyCodes[code] {
+		ondex = 0
+	}
+	if big, ok := bigIndexCodes[code]; ok && !fitsIndexer(code, xizage, index, ondex) {
+		code, xizage = big, IndexerSizes[big]
+	}
+	if !fitsIndexer(code, xizage, index, ondex) {
+		return nil, fmt.Errorf("index %d or ondex %d out of range for code %s", index, ondex, code)
+	}
+	if rs := indexerRawSize(xizage); len(raw) != rs {
+		return nil, fmt.Errorf("code %s requires %d raw bytes, got %d", code, rs, len(raw))
+	}
+	return &Indexer{Code: code, Index: index, Ondex: ondex, Raw: raw}, nil
+}
+
+func fitsIndexer(code string, x Xizage, index int, ondex int) bool {
+	if index >= 1<<(6*(x.Ss-x.Os)) {
+		return false
+	}
+	if x.Os == 0 {
+		return CurrentOnlyCodes[code] || ondex == index
+	}
+	return CurrentOnlyCodes[code] || ondex < 1<<(6*x.Os)
+}
+
+func indexerRawSize(x Xizage) int {
+	return (x.Fs-x.Hs-x.Ss)*3/4 - x.Ls
+}
+
+// Qb64 returns the text domain encoding of i.
+func (i *Indexer) Qb64() string {
+	xizage := IndexerSizes[i.Code]
+	soft := intToB64(i.Index, xizage.Ss-xizage.Os)
+	if xizage.Os > 0 {
+		soft += intToB64(i.Ondex, xizage.Os)
+	}
+	cs := xizage.Hs + xizage.Ss
+	ps := cs % 4
+	padded := append(make([]byte, ps+xizage.Ls, ps+xizage.Ls+len(i.Raw)), i.Raw...)
+	return i.Code + soft + base64.RawURLEncoding.EncodeToString(padded)[ps:]
+}
+
+// CurrentOnly reports whether i only carries an index into the current keys.
+func (i *Indexer) CurrentOnly() bool {
+	return CurrentOnlyCodes[i.Code]
+}
+
+// DecodeIndexer decodes a single qb64 indexed signature that spans the whole
+// string.
+func DecodeIndexer(qb64 string) (*Indexer, error) {
+	i, n, err := ParseIndexer(qb64)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(qb64) {
+		return nil, fmt.Errorf("expected length %d for code %s, got %d", n, i.Code, len(qb64))
+	}
+	return i, nil
+}
+
+// ParseIndexer decodes the qb64 indexed signature at the start of stream and
+// returns it along with the number of characters it occupies.
+func ParseIndexer(stream string) (*Indexer, int, error) {
+	if stream == "" {
+		return nil, 0, fmt.Errorf("empty CESR stream")
+	}
+	hs, ok := IndexerHards[stream[0]]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR indexer prefix %q", stream[0])
+	}
+	if len(stream) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(stream))
+	}
+	code := stream[:hs]
+	xizage, ok := IndexerSizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR indexer code %s", code)
+	}
+	cs := xizage.Hs + xizage.Ss
+	if len(stream) < xizage.Fs {
+		return nil, 0, fmt.Errorf("expected length %d for code %s, got %d", xizage.Fs, code, len(stream))
+	}
+	soft := stream[hs:cs]
+	if !isB64(soft) {
+		return nil, 0, fmt.Errorf("invalid soft part %s for code %s", soft, code)
+	}
+	index := b64ToInt(soft[:xizage.Ss-xizage.Os])
+	ondex := index
+	if xizage.Os > 0 {
+		ondex = b64ToInt(soft[xizage.Ss-xizage.Os:])
+	}
+	if CurrentOnlyCodes[code] {
+		if ondex != 0 && xizage.Os > 0 {
+			return nil, 0, fmt.Errorf("non-zero ondex for current only code %s", code)
+		}
+		ondex = 0
+	}
+
+	ps := cs % 4
+	paw, err := base64.RawURLEncoding.DecodeString(strings.Repeat("A", ps) + stream[cs:xizage.Fs])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid Base64 for code %s: %w", code, err)
+	}
+	for _, b := range paw[:ps+xizage.Ls] {
+		if b != 0 {
+			return nil, 0, fmt.Errorf("non-zero pad or lead bytes for code %s", code)
+		}
+	}
+	return &Indexer{Code: code, Index: index, Ondex: ondex, Raw: paw[ps+xizage.Ls:]}, xizage.Fs, nil
+}
@@ -0,0 +1,94 @@
+package cesr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexerSizesConsistent(t *testing.T) {
+	for code, x := range IndexerSizes {
+		hs, ok := IndexerHards[code[0]]
+		require.True(t, ok, code)
+		assert.Equal(t, hs, x.Hs, code)
+		assert.Equal(t, x.Hs, len(code), code)
+		assert.Zero(t, x.Fs%4, code)
+		rs := indexerRawSize(x)
+		assert.Equal(t, (x.Hs+x.Ss)%4, (3-(rs+x.Ls)%3)%3, code)
+	}
+}
+
+func TestIndexer(t *testing.T) {
+	testCases := []struct {
+		code     string
+		index    int
+		ondex    int
+		raw      []byte
+		expected string
+		prefix   string
+	}{
+		{code: Ed25519IdxSig, index: 0, ondex: 0, raw: TESTBytes64, expected: Ed25519IdxSig, prefix: "AA"},
+		{code: Ed25519IdxSig, index: 5, ondex: 5, raw: TESTBytes64, expected: Ed25519IdxSig, prefix: "AF"},
+		{code: Ed25519CrtSig, index: 63, ondex: 7, raw: TESTBytes64, expected: Ed25519CrtSig, prefix: "B_"},
+		{code: Ed25519IdxSig, index: 64, ondex: 64, raw: TESTBytes64, expected: Ed25519BigIdxSig, prefix: "2ABABA"},
+		{code: Ed25519IdxSig, index: 1, ondex: 2, raw: TESTBytes64, expected: Ed25519BigIdxSig, prefix: "2AABAC"},
+		{code: Ed25519CrtSig, index: 70, raw: TESTBytes64, expected: Ed25519BigCrtSig, prefix: "2BBGAA"},
+		{code: Ed448IdxSig, index: 3, ondex: 4, raw: bytes.Repeat([]byte{9}, 114), expected: Ed448IdxSig, prefix: "0ADE"},
+	}
+	for _, tc := range testCases {
+		i, err := NewIndexer(tc.code, tc.index, tc.ondex, tc.raw)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, i.Code)
+		qb64 := i.Qb64()
+		assert.Len(t, qb64, IndexerSizes[tc.expected].Fs)
+		assert.Equal(t, tc.prefix, qb64[:len(tc.prefix)])
+
+		decoded, err := DecodeIndexer(qb64)
+		require.NoError(t, err)
+		assert.Equal(t, i, decoded)
+	}
+}
+
+func TestIndexerMatchesMatterEncoding(t *testing.T) {
+	i, err := NewIndexer(Ed25519IdxSig, 0, 0, TESTBytes64)
+	require.NoError(t, err)
+	assert.Equal(t, Encode(TESTBytes64, Ed25519Sig)[2:], i.Qb64()[2:])
+}
+
+func TestParseIndexerStream(t *testing.T) {
+	first, _ := NewIndexer(Ed25519IdxSig, 0, 0, TESTBytes64)
+	second, _ := NewIndexer(Ed25519IdxSig, 1, 1, TESTBytes64)
+	stream := first.Qb64() + second.Qb64()
+
+	i, n, err := ParseIndexer(stream)
+	require.NoError(t, err)
+	assert.Equal(t, 0, i.Index)
+	i, _, err = ParseIndexer(stream[n:])
+	require.NoError(t, err)
+	assert.Equal(t, 1, i.Index)
+	assert.False(t, i.CurrentOnly())
+}
+
+func TestIndexerErrors(t *testing.T) {
+	_, err := NewIndexer("Z", 0, 0, TESTBytes64)
+	assert.ErrorContains(t, err, "unsupported")
+
+	_, err = NewIndexer(Ed25519IdxSig, 4096, 0, TESTBytes64)
+	assert.ErrorContains(t, err, "out of range")
+
+	_, err = NewIndexer(Ed25519IdxSig, -1, 0, TESTBytes64)
+	assert.Error(t, err)
+
+	_, err = NewIndexer(Ed25519IdxSig, 0, 0, TESTBytes32)
+	assert.ErrorContains(t, err, "requires 64 raw bytes")
+
+	_, err = DecodeIndexer("AA")
+	assert.ErrorContains(t, err, "expected length 88")
+
+	i, _ := NewIndexer(Ed25519BigCrtSig, 1, 0, TESTBytes64)
+	qb64 := i.Qb64()
+	_, err = DecodeIndexer(qb64[:4] + "AB" + qb64[6:])
+	assert.ErrorContains(t, err, "non-zero ondex")
+}
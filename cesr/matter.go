@@ -0,0 +1,414 @@
+package cesr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Matter codes, following the keripy MtrDex table.
+const (
+	Ed25519Seed      = "A"    // Ed25519 256 bit random seed for private key
+	Ed25519N         = "B"    // Ed25519 non-transferable verification key
+	X25519           = "C"    // X25519 public encryption key
+	Ed25519          = "D"    // Ed25519 transferable verification key
+	Blake3_256       = "E"    // Blake3 256 bit digest
+	Blake2b_256      = "F"    // Blake2b 256 bit digest
+	Blake2s_256      = "G"    // Blake2s 256 bit digest
+	SHA3_256         = "H"    // SHA3 256 bit digest
+	SHA2_256         = "I"    // SHA2 256 bit digest
+	ECDSA256k1Seed   = "J"    // ECDSA secp256k1 256 bit random seed
+	Ed448Seed        = "K"    // Ed448 448 bit random seed
+	X448             = "L"    // X448 public encryption key
+	Short            = "M"    // 2 byte number
+	Big              = "N"    // 8 byte number
+	X25519Private    = "O"    // X25519 private decryption key
+	X25519CipherSeed = "P"    // X25519 cipher of a 44 char qb64 seed
+	ECDSA256r1Seed   = "Q"    // ECDSA secp256r1 256 bit random seed
+	Tall             = "R"    // 5 byte number
+	Large            = "S"    // 11 byte number
+	Great            = "T"    // 14 byte number
+	Vast             = "U"    // 17 byte number
+	Label1           = "V"    // 1 byte label
+	Label2           = "W"    // 2 byte label
+	Tag3             = "X"    // 3 B64 char special value
+	Tag7             = "Y"    // 7 B64 char special value
+	Blind            = "Z"    // 256 bit blinding factor
+	Salt128          = "0A"   // 128 bit random salt, seed or nonce
+	Ed25519Sig       = "0B"   // Ed25519 signature
+	ECDSA256k1Sig    = "0C"   // ECDSA secp256k1 signature
+	Blake3_512       = "0D"   // Blake3 512 bit digest
+	Blake2b_512      = "0E"   // Blake2b 512 bit digest
+	SHA3_512         = "0F"   // SHA3 512 bit digest
+	SHA2_512         = "0G"   // SHA2 512 bit digest
+	Long             = "0H"   // 4 byte number
+	ECDSA256r1Sig    = "0I"   // ECDSA secp256r1 signature
+	Tag1             = "0J"   // 1 B64 char special value with pre pad
+	Tag2             = "0K"   // 2 B64 char special value
+	Tag5             = "0L"   // 5 B64 char special value with pre pad
+	Tag6             = "0M"   // 6 B64 char special value
+	Tag9             = "0N"   // 9 B64 char special value with pre pad
+	Tag10            = "0O"   // 10 B64 char special value
+	ECDSA256k1N      = "1AAA" // ECDSA secp256k1 non-transferable verification key
+	ECDSA256k1       = "1AAB" // ECDSA secp256k1 transferable verification key
+	Ed448N           = "1AAC" // Ed448 non-transferable verification key
+	Ed448            = "1AAD" // Ed448 transferable verification key
+	Ed448Sig         = "1AAE" // Ed448 signature
+	Tern             = "1AAF" // 3 byte number
+	DateTime         = "1AAG" // Base64 encoded ISO-8601 datetime
+	X25519CipherSalt = "1AAH" // X25519 cipher of a 24 char qb64 salt
+	ECDSA256r1N      = "1AAI" // ECDSA secp256r1 non-transferable verification key
+	ECDSA256r1       = "1AAJ" // ECDSA secp256r1 transferable verification key
+	Null             = "1AAK" // null or empty value
+	No               = "1AAL" // false
+	Yes              = "1AAM" // true
+	Tag4             = "1AAN" // 4 B64 char special value
+	Tag8             = "1AAO" // 8 B64 char special value
+	Escape           = "1AAP" // escape code for special map fields
+	Empty            = "1AAQ" // empty value for nonce or UUID fields
+
+	StrB64L0          = "4A"   // Base64 string, lead size 0
+	StrB64L1          = "5A"   // Base64 string, lead size 1
+	StrB64L2          = "6A"   // Base64 string, lead size 2
+	StrB64BigL0       = "7AAA" // big Base64 string, lead size 0
+	StrB64BigL1       = "8AAA" // big Base64 string, lead size 1
+	StrB64BigL2       = "9AAA" // big Base64 string, lead size 2
+	BytesL0           = "4B"   // byte string, lead size 0
+	BytesL1           = "5B"   // byte string, lead size 1
+	BytesL2           = "6B"   // byte string, lead size 2
+	BytesBigL0        = "7AAB" // big byte string, lead size 0
+	BytesBigL1        = "8AAB" // big byte string, lead size 1
+	BytesBigL2        = "9AAB" // big byte string, lead size 2
+	X25519CipherL0    = "4C"   // X25519 sealed box cipher, lead size 0
+	X25519CipherL1    = "5C"   // X25519 sealed box cipher, lead size 1
+	X25519CipherL2    = "6C"   // X25519 sealed box cipher, lead size 2
+	X25519CipherBigL0 = "7AAC" // big X25519 sealed box cipher, lead size 0
+	X25519CipherBigL1 = "8AAC" // big X25519 sealed box cipher, lead size 1
+	X25519CipherBigL2 = "9AAC" // big X25519 sealed box cipher, lead size 2
+)
+
+// Sizage describes the layout of a code: hard size, soft size, extra (pre
+// pad) size within the soft part, full size and lead size. Fs is zero for
+// variable sized codes, whose soft part holds the size in quadlets.
+type Sizage struct {
+	Hs int
+	Ss int
+	Xs int
+	Fs int
+	Ls int
+}
+
+// Hards maps the first character of a Matter code to its hard size.
+var Hards = func() map[byte]int {
+	h := map[byte]int{}
+	for c := byte('A'); c <= 'Z'; c++ {
+		h[c] = 1
+	}
+	for c := byte('a'); c <= 'z'; c++ {
+		h[c] = 1
+	}
+	for _, c := range []byte("0456") {
+		h[c] = 2
+	}
+	for _, c := range []byte("123789") {
+		h[c] = 4
+	}
+	return h
+}()
+
+// Sizes is the Matter code table.
+var Sizes = map[string]Sizage{
+	Ed25519Seed:      {Hs: 1, Fs: 44},
+	Ed25519N:         {Hs: 1, Fs: 44},
+	X25519:           {Hs: 1, Fs: 44},
+	Ed25519:          {Hs: 1, Fs: 44},
+	Blake3_256:       {Hs: 1, Fs: 44},
+	Blake2b_256:      {Hs: 1, Fs: 44},
+	Blake2s_256:      {Hs: 1, Fs: 44},
+	SHA3_256:         {Hs: 1, Fs: 44},
+	SHA2_256:         {Hs: 1, Fs: 44},
+	ECDSA256k1Seed:   {Hs: 1, Fs: 44},
+	Ed448Seed:        {Hs: 1, Fs: 76},
+	X448:             {Hs: 1, Fs: 76},
+	Short:            {Hs: 1, Fs: 4},
+	Big:              {Hs: 1, Fs: 12},
+	X25519Private:    {Hs: 1, Fs: 44},
+	X25519CipherSeed: {Hs: 1, Fs: 124},
+	ECDSA256r1Seed:   {Hs: 1, Fs: 44},
+	Tall:             {Hs: 1, Fs: 8},
+	Large:            {Hs: 1, Fs: 16},
+	Great:            {Hs: 1, Fs: 20},
+	Vast:             {Hs: 1, Fs: 24},
+	Label1:           {Hs: 1, Fs: 4, Ls: 1},
+	Label2:           {Hs: 1, Fs: 4},
+	Tag3:             {Hs: 1, Ss: 3, Fs: 4},
+	Tag7:             {Hs: 1, Ss: 7, Fs: 8},
+	Blind:            {Hs: 1, Fs: 44},
+	Salt128:          {Hs: 2, Fs: 24},
+	Ed25519Sig:       {Hs: 2, Fs: 88},
+	ECDSA256k1Sig:    {Hs: 2, Fs: 88},
+	Blake3_512:       {Hs: 2, Fs: 88},
+	Blake2b_512:      {Hs: 2, Fs: 88},
+	SHA3_512:         {Hs: 2, Fs: 88},
+	SHA2_512:         {Hs: 2, Fs: 88},
+	Long:             {Hs: 2, Fs: 8},
+	ECDSA256r1Sig:    {Hs: 2, Fs: 88},
+	Tag1:             {Hs: 2, Ss: 2, Xs: 1, Fs: 4},
+	Tag2:             {Hs: 2, Ss: 2, Fs: 4},
+	Tag5:             {Hs: 2, Ss: 6, Xs: 1, Fs: 8},
+	Tag6:             {Hs: 2, Ss: 6, Fs: 8},
+	Tag9:             {Hs: 2, Ss: 10, Xs: 1, Fs: 12},
+	Tag10:            {Hs: 2, Ss: 10, Fs: 12},
+	ECDSA256k1N:      {Hs: 4, Fs: 48},
+	ECDSA256k1:       {Hs: 4, Fs: 48},
+	Ed448N:           {Hs: 4, Fs: 80},
+	Ed448:            {Hs: 4, Fs: 80},
+	Ed448Sig:         {Hs: 4, Fs: 156},
+	Tern:             {Hs: 4, Fs: 8},
+	DateTime:         {Hs: 4, Fs: 36},
+	X25519CipherSalt: {Hs: 4, Fs: 100},
+	ECDSA256r1N:      {Hs: 4, Fs: 48},
+	ECDSA256r1:       {Hs: 4, Fs: 48},
+	Null:             {Hs: 4, Fs: 4},
+	No:               {Hs: 4, Fs: 4},
+	Yes:              {Hs: 4, Fs: 4},
+	Tag4:             {Hs: 4, Ss: 4, Fs: 8},
+	Tag8:             {Hs: 4, Ss: 8, Fs: 12},
+	Escape:           {Hs: 4, Fs: 4},
+	Empty:            {Hs: 4, Fs: 4},
+
+	StrB64L0:          {Hs: 2, Ss: 2},
+	StrB64L1:          {Hs: 2, Ss: 2, Ls: 1},
+	StrB64L2:          {Hs: 2, Ss: 2, Ls: 2},
+	StrB64BigL0:       {Hs: 4, Ss: 4},
+	StrB64BigL1:       {Hs: 4, Ss: 4, Ls: 1},
+	StrB64BigL2:       {Hs: 4, Ss: 4, Ls: 2},
+	BytesL0:           {Hs: 2, Ss: 2},
+	BytesL1:           {Hs: 2, Ss: 2, Ls: 1},
+	BytesL2:           {Hs: 2, Ss: 2, Ls: 2},
+	BytesBigL0:        {Hs: 4, Ss: 4},
+	BytesBigL1:        {Hs: 4, Ss: 4, Ls: 1},
+	BytesBigL2:        {Hs: 4, Ss: 4, Ls: 2},
+	X25519CipherL0:    {Hs: 2, Ss: 2},
+	X25519CipherL1:    {Hs: 2, Ss: 2, Ls: 1},
+	X25519CipherL2:    {Hs: 2, Ss: 2, Ls: 2},
+	X25519CipherBigL0: {Hs: 4, Ss: 4},
+	X25519CipherBigL1: {Hs: 4, Ss: 4, Ls: 1},
+	X25519CipherBigL2: {Hs: 4, Ss: 4, Ls: 2},
+}
+
+// variableFamilies lists the variable sized codes of each family, indexed by
+// lead size, small codes first.
+var variableFamilies = [][6]string{
+	{StrB64L0, StrB64L1, StrB64L2, StrB64BigL0, StrB64BigL1, StrB64BigL2},
+	{BytesL0, BytesL1, BytesL2, BytesBigL0, BytesBigL1, BytesBigL2},
+	{X25519CipherL0, X25519CipherL1, X25519CipherL2, X25519CipherBigL0, X25519CipherBigL1, X25519CipherBigL2},
+}
+
+// NonTransferableCodes are verification key codes whose key can never rotate.
+var NonTransferableCodes = map[string]bool{Ed25519N: true, ECDSA256k1N: true, Ed448N: true, ECDSA256r1N: true}
+
+// VerKeyCodes are verification key codes.
+var VerKeyCodes = map[string]bool{
+	Ed25519N: true, Ed25519: true, ECDSA256k1N: true, ECDSA256k1: true,
+	Ed448N: true, Ed448: true, ECDSA256r1N: true, ECDSA256r1: true,
+}
+
+// DigestCodes are digest codes usable as self-addressing identifiers.
+var DigestCodes = map[string]bool{
+	Blake3_256: true, Blake2b_256: true, Blake2s_256: true, SHA3_256: true, SHA2_256: true,
+	Blake3_512: true, Blake2b_512: true, SHA3_512: true, SHA2_512: true,
+}
+
+// Pad is the character used for the extra pre pad of special codes.
+const Pad = "_"
+
+// Matter is a single CESR primitive: a code and its raw bytes. Soft holds the
+// value of special codes, such as the Tag codes, whose soft part is not a size.
+type Matter struct {
+	Code string
+	Soft string
+	Raw  []byte
+}
+
+// NewMatter validates raw against code. For variable sized codes any code of
+// the family may be given; the code with the matching lead size is chosen and
+// the big variant is used when the size does not fit the small code.
+func NewMatter(code string, raw []byte) (*Matter, error) {
+	sizage, ok := Sizes[code]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CESR code %s", code)
+	}
+	if sizage.Fs == 0 {
+		code = variableCode(code, len(raw))
+		return &Matter{Code: code, Raw: raw}, nil
+	}
+	if sizage.Ss != 0 {
+		return nil, fmt.Errorf("code %s holds a special value, use NewSpecialMatter", code)
+	}
+	if rs := rawSize(sizage); len(raw) != rs {
+		return nil, fmt.Errorf("code %s requires %d raw bytes, got %d", code, rs, len(raw))
+	}
+	return &Matter{Code: code, Raw: raw}, nil
+}
+
+// NewSpecialMatter creates a primitive for a fixed size code whose soft part
+// holds a value, such as Tag3 or Tag1.
+func NewSpecialMatter(code string, soft string, raw []byte) (*Matter, error) {
+	sizage, ok := Sizes[code]
+	if !ok || sizage.Fs == 0 || sizage.Ss == 0 {
+		return nil, fmt.Errorf("code %s does not hold a special value", code)
+	}
+	if len(soft) != sizage.Ss-sizage.Xs || !isB64(soft) {
+		return nil, fmt.Errorf("code %s requires a %d character Base64 value", code, sizage.Ss-sizage.Xs)
+	}
+	if rs := rawSize(sizage); len(raw) != rs {
+		return nil, fmt.Errorf("code %s requires %d raw bytes, got %d", code, rs, len(raw))
+	}
+	return &Matter{Code: code, Soft: soft, Raw: raw}, nil
+}
+
+func variableCode(code string, rawLen int) string {
+	ls := (3 - rawLen%3) % 3
+	quadlets := (rawLen + ls) / 3
+	for _, family := range variableFamilies {
+		for _, c := range family {
+			if c == code {
+				if quadlets >= 1<<12 {
+					return family[3+ls]
+				}
+				return family[ls]
+			}
+		}
+	}
+	return code
+}
+
+func rawSize(s Sizage) int {
+	return (s.Fs-s.Hs-s.Ss)*3/4 - s.Ls
+}
+
+// Qb64 returns the text domain encoding of m.
+func (m *Matter) Qb64() string {
+	sizage := Sizes[m.Code]
+	raw := m.Raw
+	cs := sizage.Hs + sizage.Ss
+	if sizage.Fs == 0 {
+		soft := intToB64((len(raw)+sizage.Ls)/3, sizage.Ss)
+		padded := append(make([]byte, sizage.Ls, sizage.Ls+len(raw)), raw...)
+		return m.Code + soft + base64.RawURLEncoding.EncodeToString(padded)
+	}
+	soft := strings.Repeat(Pad, sizage.Xs) + m.Soft
+	ps := cs % 4
+	padded := append(make([]byte, ps+sizage.Ls, ps+sizage.Ls+len(raw)), raw...)
+	return m.Code + soft + base64.RawURLEncoding.EncodeToString(padded)[ps:]
+}
+
+// Transferable reports whether a verification key may be rotated.
+func (m *Matter) Transferable() bool {
+	return !NonTransferableCodes[m.Code]
+}
+
+// Digestive reports whether m is a digest.
+func (m *Matter) Digestive() bool {
+	return DigestCodes[m.Code]
+}
+
+// DecodeMatter decodes a single qb64 primitive that spans the whole string.
+func DecodeMatter(qb64 string) (*Matter, error) {
+	m, n, err := ParseMatter(qb64)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(qb64) {
+		return nil, fmt.Errorf("expected length %d for code %s, got %d", n, m.Code, len(qb64))
+	}
+	return m, nil
+}
+
+// ParseMatter decodes the qb64 primitive at the start of stream and returns it
+// along with the number of characters it occupies.
+func ParseMatter(stream string) (*Matter, int, error) {
+	if stream == "" {
+		return nil, 0, fmt.Errorf("empty CESR stream")
+	}
+	hs, ok := Hards[stream[0]]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR prefix %q", stream[0])
+	}
+	if len(stream) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(stream))
+	}
+	code := stream[:hs]
+	sizage, ok := Sizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR code %s", code)
+	}
+	cs := sizage.Hs + sizage.Ss
+	if len(stream) < cs {
+		return nil, 0, fmt.Errorf("need %d characters for code %s, got %d", cs, code, len(stream))
+	}
+	soft := stream[hs:cs]
+	if !isB64(soft) {
+		return nil, 0, fmt.Errorf("invalid soft part %s for code %s", soft, code)
+	}
+
+	fs := sizage.Fs
+	if fs == 0 {
+		fs = b64ToInt(soft)*4 + cs
+		soft = ""
+	} else {
+		if xtra := soft[:sizage.Xs]; xtra != strings.Repeat(Pad, sizage.Xs) {
+			return nil, 0, fmt.Errorf("invalid pre pad %s for code %s", xtra, code)
+		}
+		soft = soft[sizage.Xs:]
+	}
+	if len(stream) < fs {
+		return nil, 0, fmt.Errorf("expected length %d for code %s, got %d", fs, code, len(stream))
+	}
+
+	ps := cs % 4
+	paw, err := base64.RawURLEncoding.DecodeString(strings.Repeat("A", ps) + stream[cs:fs])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid Base64 for code %s: %w", code, err)
+	}
+	if len(paw) < ps+sizage.Ls {
+		return nil, 0, fmt.Errorf("no room for %d lead bytes in %d characters for code %s", sizage.Ls, fs, code)
+	}
+	for _, b := range paw[:ps+sizage.Ls] {
+		if b != 0 {
+			return nil, 0, fmt.Errorf("non-zero pad or lead bytes for code %s", code)
+		}
+	}
+	return &Matter{Code: code, Soft: soft, Raw: paw[ps+sizage.Ls:]}, fs, nil
+}
+
+const b64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func isB64(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(b64Alphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// intToB64 encodes n as a Base64 number of exactly length characters.
+func intToB64(n int, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = b64Alphabet[n%64]
+		n /= 64
+	}
+	return string(b)
+}
+
+// b64ToInt decodes a Base64 number. s must only contain Base64 characters.
+func b64ToInt(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n = n*64 + strings.IndexByte(b64Alphabet, s[i])
+	}
+	return n
+}
@@ -0,0 +1,190 @@
+package cesr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizesConsistent(t *testing.T) {
+	for code, s := range Sizes {
+		hs, ok := Hards[code[0]]
+		require.True(t, ok, code)
+		assert.Equal(t, hs, s.Hs, code)
+		assert.Equal(t, s.Hs, len(code), code)
+		cs := s.Hs + s.Ss
+		if s.Fs == 0 {
+			assert.Zero(t, cs%4, code)
+			continue
+		}
+		assert.Zero(t, s.Fs%4, code)
+		rs := rawSize(s)
+		assert.Equal(t, cs%4, (3-(rs+s.Ls)%3)%3, code)
+	}
+}
+
+func TestMatterRoundTrip(t *testing.T) {
+	testCases := []struct {
+		code string
+		raw  []byte
+	}{
+		{Ed25519N, TESTBytes32},
+		{Ed25519, TESTBytes32},
+		{Blake3_256, TESTBytes32},
+		{Ed25519Sig, TESTBytes64},
+		{Salt128, TESTBytes32[:16]},
+		{Short, []byte{0, 1}},
+		{Label1, []byte("a")},
+		{ECDSA256k1N, append([]byte{2}, TESTBytes32...)},
+		{Ed448Sig, bytes.Repeat([]byte{7}, 114)},
+		{Null, []byte{}},
+	}
+	for _, tc := range testCases {
+		m, err := NewMatter(tc.code, tc.raw)
+		require.NoError(t, err, tc.code)
+		qb64 := m.Qb64()
+		assert.Len(t, qb64, Sizes[tc.code].Fs, tc.code)
+		assert.True(t, strings.HasPrefix(qb64, tc.code), tc.code)
+
+		decoded, err := DecodeMatter(qb64)
+		require.NoError(t, err, tc.code)
+		assert.Equal(t, tc.code, decoded.Code)
+		assert.Equal(t, tc.raw, decoded.Raw)
+	}
+}
+
+func TestMatterKnownValues(t *testing.T) {
+	m, err := NewMatter(Short, []byte{0, 1})
+	require.NoError(t, err)
+	assert.Equal(t, "MAAB", m.Qb64())
+
+	m, err = NewMatter(Ed25519N, TESTBytes32)
+	require.NoError(t, err)
+	assert.Equal(t, "B"+TESTCesr44[1:], m.Qb64())
+	assert.Equal(t, Encode(TESTBytes32, "B"), m.Qb64())
+
+	m, err = NewSpecialMatter(Tag3, "icp", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Xicp", m.Qb64())
+
+	m, err = NewSpecialMatter(Tag1, "z", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0J_z", m.Qb64())
+	decoded, err := DecodeMatter("0J_z")
+	require.NoError(t, err)
+	assert.Equal(t, "z", decoded.Soft)
+}
+
+func TestMatterVariable(t *testing.T) {
+	testCases := []struct {
+		raw      []byte
+		expected string
+	}{
+		{[]byte("abc"), "4BABYWJj"},
+		{[]byte("ab"), "5BABAGFi"},
+		{[]byte("abcd"), "6BACAABhYmNk"},
+		{[]byte{}, "4BAA"},
+	}
+	for _, tc := range testCases {
+		// any code of the family selects the lead size from the raw length
+		m, err := NewMatter(BytesL2, tc.raw)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, m.Qb64())
+
+		decoded, err := DecodeMatter(tc.expected)
+		require.NoError(t, err)
+		assert.Equal(t, tc.raw, decoded.Raw)
+	}
+}
+
+func TestMatterVariableBig(t *testing.T) {
+	raw := bytes.Repeat([]byte{1}, 3*4096)
+	m, err := NewMatter(BytesL0, raw)
+	require.NoError(t, err)
+	assert.Equal(t, BytesBigL0, m.Code)
+	qb64 := m.Qb64()
+	assert.Equal(t, "7AABABAA", qb64[:8])
+
+	decoded, err := DecodeMatter(qb64)
+	require.NoError(t, err)
+	assert.Equal(t, BytesBigL0, decoded.Code)
+	assert.Equal(t, raw, decoded.Raw)
+}
+
+func TestParseMatterStream(t *testing.T) {
+	key := Encode(TESTBytes32, Ed25519)
+	stream := key + "4BABYWJj" + TESTCesr88
+
+	m, n, err := ParseMatter(stream)
+	require.NoError(t, err)
+	assert.Equal(t, Ed25519, m.Code)
+	assert.True(t, m.Transferable())
+	assert.Equal(t, 44, n)
+
+	m, n2, err := ParseMatter(stream[n:])
+	require.NoError(t, err)
+	assert.Equal(t, BytesL0, m.Code)
+	assert.Equal(t, []byte("abc"), m.Raw)
+
+	m, _, err = ParseMatter(stream[n+n2:])
+	require.NoError(t, err)
+	assert.Equal(t, Blake3_512, m.Code)
+	assert.True(t, m.Digestive())
+}
+
+func TestDecodeMatterCodes(t *testing.T) {
+	for _, code := range []string{Ed25519N, Ed25519, Blake3_256} {
+		m, err := DecodeMatter(code + TESTCesr44[1:])
+		require.NoError(t, err)
+		assert.Equal(t, code, m.Code)
+	}
+	m, _ := DecodeMatter(Ed25519N + TESTCesr44[1:])
+	assert.False(t, m.Transferable())
+}
+
+func TestMatterErrors(t *testing.T) {
+	_, err := NewMatter("?", TESTBytes32)
+	assert.ErrorContains(t, err, "unsupported CESR code")
+
+	_, err = NewMatter(Ed25519, TESTBytes32[:31])
+	assert.ErrorContains(t, err, "requires 32 raw bytes")
+
+	_, err = NewMatter(Tag3, nil)
+	assert.Error(t, err)
+
+	_, err = NewSpecialMatter(Tag3, "ab", nil)
+	assert.Error(t, err)
+
+	_, err = DecodeMatter("M_AA")
+	assert.ErrorContains(t, err, "non-zero")
+
+	_, err = DecodeMatter("0J-z")
+	assert.ErrorContains(t, err, "pre pad")
+
+	_, err = DecodeMatter("4BAC" + "YWJj")
+	assert.ErrorContains(t, err, "expected length")
+
+	_, _, err = ParseMatter("1AA")
+	assert.Error(t, err)
+
+	_, err = DecodeMatter(TESTCesr44 + "AAAA")
+	assert.ErrorContains(t, err, "expected length 44")
+}
+
+func TestMatterEmptyWithLeadBytes(t *testing.T) {
+	// variable size codes with lead bytes and no quadlets cannot hold the
+	// lead bytes
+	for _, qb64 := range []string{"5AAA", "6AAA", "8AAAAAAA"} {
+		t.Run(qb64, func(t *testing.T) {
+			_, _, err := ParseMatter(qb64)
+			assert.ErrorContains(t, err, "lead bytes")
+			_, err = DecodeMatter(qb64)
+			assert.Error(t, err)
+			_, err = Decode(qb64)
+			assert.Error(t, err)
+		})
+	}
+}
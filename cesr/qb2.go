@@ -0,0 +1,232 @@
+package cesr
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Every CESR primitive and counter is a whole number of quadlets in the text
+// domain (qb64) and of triplets in the binary domain (qb2), so qb2 is the
+// Base64 decoding of qb64 and a stream converts between the domains without
+// being parsed.
+
+// ColdCode is the kind of data at the start of a stream, taken from the top
+// three bits (tritet) of its first byte.
+type ColdCode int
+
+const (
+	ColdAnB64  ColdCode = iota // not taken
+	ColdCtB64                  // count code in the text domain
+	ColdOpB64                  // op code in the text domain
+	ColdJSON                   // JSON message
+	ColdMGPK1                  // MessagePack fixed map message
+	ColdCBOR                   // CBOR map message
+	ColdMGPK2                  // MessagePack big map message
+	ColdCtOpB2                 // count or op code in the binary domain
+)
+
+// Sniff reports what a stream starts with, so a cold-start parser knows
+// whether it is reading a message, qb64 or qb2.
+func Sniff(stream []byte) (ColdCode, error) {
+	if len(stream) == 0 {
+		return 0, errors.New("empty CESR stream")
+	}
+	cold := ColdCode(stream[0] >> 5)
+	if cold == ColdAnB64 {
+		return cold, fmt.Errorf("unexpected start of stream 0x%02x", stream[0])
+	}
+	return cold, nil
+}
+
+// Qb64ToQb2 converts a qb64 stream to qb2.
+func Qb64ToQb2(qb64 string) ([]byte, error) {
+	if len(qb64)%4 != 0 {
+		return nil, fmt.Errorf("qb64 length %d is not a multiple of 4", len(qb64))
+	}
+	return base64.RawURLEncoding.DecodeString(qb64)
+}
+
+// Qb2ToQb64 converts a qb2 stream to qb64.
+func Qb2ToQb64(qb2 []byte) (string, error) {
+	if len(qb2)%3 != 0 {
+		return "", fmt.Errorf("qb2 length %d is not a multiple of 3", len(qb2))
+	}
+	return base64.RawURLEncoding.EncodeToString(qb2), nil
+}
+
+// sniffText returns the first complete Base64 characters of a qb2 stream, at
+// most maxChars.
+func sniffText(stream []byte, maxChars int) string {
+	n := min(len(stream), maxChars*3/4)
+	text := base64.RawURLEncoding.EncodeToString(stream[:n])
+	return text[:n*8/6]
+}
+
+// qb2ToText converts the first fs qb64 characters worth of a qb2 stream.
+func qb2ToText(stream []byte, fs int, code string) (string, int, error) {
+	bs := fs * 3 / 4
+	if len(stream) < bs {
+		return "", 0, fmt.Errorf("expected %d bytes for code %s, got %d", bs, code, len(stream))
+	}
+	return base64.RawURLEncoding.EncodeToString(stream[:bs]), bs, nil
+}
+
+// Qb2 returns the binary domain encoding of m.
+func (m *Matter) Qb2() []byte {
+	qb2, _ := Qb64ToQb2(m.Qb64())
+	return qb2
+}
+
+// ParseMatterQb2 decodes the qb2 primitive at the start of stream and returns
+// it along with the number of bytes it occupies.
+func ParseMatterQb2(stream []byte) (*Matter, int, error) {
+	text := sniffText(stream, 8)
+	if text == "" {
+		return nil, 0, fmt.Errorf("empty CESR stream")
+	}
+	hs, ok := Hards[text[0]]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR prefix %q", text[0])
+	}
+	if len(text) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(text))
+	}
+	code := text[:hs]
+	sizage, ok := Sizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR code %s", code)
+	}
+	fs := sizage.Fs
+	if fs == 0 {
+		cs := sizage.Hs + sizage.Ss
+		if len(text) < cs {
+			return nil, 0, fmt.Errorf("need %d characters for code %s, got %d", cs, code, len(text))
+		}
+		fs = b64ToInt(text[hs:cs])*4 + cs
+	}
+	qb64, bs, err := qb2ToText(stream, fs, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, _, err := ParseMatter(qb64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return m, bs, nil
+}
+
+// DecodeMatterQb2 decodes a single qb2 primitive that spans all of qb2.
+func DecodeMatterQb2(qb2 []byte) (*Matter, error) {
+	m, n, err := ParseMatterQb2(qb2)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(qb2) {
+		return nil, fmt.Errorf("expected %d bytes for code %s, got %d", n, m.Code, len(qb2))
+	}
+	return m, nil
+}
+
+// Qb2 returns the binary domain encoding of i.
+func (i *Indexer) Qb2() []byte {
+	qb2, _ := Qb64ToQb2(i.Qb64())
+	return qb2
+}
+
+// ParseIndexerQb2 decodes the qb2 indexed signature at the start of stream and
+// returns it along with the number of bytes it occupies.
+func ParseIndexerQb2(stream []byte) (*Indexer, int, error) {
+	text := sniffText(stream, 4)
+	if text == "" {
+		return nil, 0, fmt.Errorf("empty CESR stream")
+	}
+	hs, ok := IndexerHards[text[0]]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR indexer prefix %q", text[0])
+	}
+	if len(text) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(text))
+	}
+	code := text[:hs]
+	xizage, ok := IndexerSizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR indexer code %s", code)
+	}
+	qb64, bs, err := qb2ToText(stream, xizage.Fs, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	i, _, err := ParseIndexer(qb64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return i, bs, nil
+}
+
+// Qb2 returns the binary domain encoding of c.
+func (c *Counter) Qb2() []byte {
+	qb2, _ := Qb64ToQb2(c.Qb64())
+	return qb2
+}
+
+// ParseCounterQb2 decodes the qb2 counter at the start of stream and returns
+// it along with the number of bytes it occupies.
+func ParseCounterQb2(stream []byte) (*Counter, int, error) {
+	text := sniffText(stream, 8)
+	hs, ok := counterHardSize(text)
+	if !ok {
+		return nil, 0, fmt.Errorf("no CESR counter at %.4q", text)
+	}
+	if len(text) < hs {
+		return nil, 0, fmt.Errorf("need %d characters for hard code, got %d", hs, len(text))
+	}
+	code := text[:hs]
+	sizage, ok := CounterSizes[code]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported CESR counter code %s", code)
+	}
+	qb64, bs, err := qb2ToText(stream, sizage.Fs, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, _, err := ParseCounter(qb64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c, bs, nil
+}
+
+// Qb2 returns the binary domain encoding of g.
+func (g *Group) Qb2() ([]byte, error) {
+	qb64, err := g.Qb64()
+	if err != nil {
+		return nil, err
+	}
+	return Qb64ToQb2(qb64)
+}
+
+// ParseAttachmentsQb2 walks a qb2 stream of counted attachment groups.
+func ParseAttachmentsQb2(stream []byte) ([]Group, error) {
+	qb64, err := Qb2ToQb64(stream)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAttachments(qb64)
+}
+
+// ParseAttachmentStream walks a stream of attachment groups in either domain,
+// detecting the domain from the first byte.
+func ParseAttachmentStream(stream []byte) ([]Group, error) {
+	cold, err := Sniff(stream)
+	if err != nil {
+		return nil, err
+	}
+	switch cold {
+	case ColdCtB64:
+		return ParseAttachments(string(stream))
+	case ColdCtOpB2:
+		return ParseAttachmentsQb2(stream)
+	}
+	return nil, fmt.Errorf("stream does not start with a counter (cold start code %d)", cold)
+}
@@ -0,0 +1,104 @@
+package cesr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatterQb2(t *testing.T) {
+	testCases := []struct {
+		code string
+		raw  []byte
+	}{
+		{Ed25519N, TESTBytes32},
+		{Ed25519Sig, TESTBytes64},
+		{Short, []byte{0, 1}},
+		{ECDSA256k1N, append([]byte{3}, TESTBytes32...)},
+		{BytesL0, []byte("abcd")},
+		{BytesL0, bytes.Repeat([]byte{5}, 3*4096)},
+	}
+	for _, tc := range testCases {
+		m, err := NewMatter(tc.code, tc.raw)
+		require.NoError(t, err)
+		qb2 := m.Qb2()
+		assert.Len(t, qb2, len(m.Qb64())*3/4)
+
+		decoded, err := DecodeMatterQb2(qb2)
+		require.NoError(t, err, tc.code)
+		assert.Equal(t, m, decoded)
+
+		text, err := Qb2ToQb64(qb2)
+		require.NoError(t, err)
+		assert.Equal(t, m.Qb64(), text)
+	}
+
+	m, _ := NewSpecialMatter(Tag3, "icp", nil)
+	decoded, err := DecodeMatterQb2(m.Qb2())
+	require.NoError(t, err)
+	assert.Equal(t, "icp", decoded.Soft)
+}
+
+func TestIndexerAndCounterQb2(t *testing.T) {
+	for _, index := range []int{0, 5, 100} {
+		siger, err := NewIndexer(Ed25519IdxSig, index, index, TESTBytes64)
+		require.NoError(t, err)
+		decoded, n, err := ParseIndexerQb2(append(siger.Qb2(), 0xff))
+		require.NoError(t, err)
+		assert.Equal(t, siger, decoded)
+		assert.Equal(t, len(siger.Qb2()), n)
+	}
+
+	for _, code := range []string{ControllerIdxSigs, BigAttachedMaterialQuadlets, KERIACDCGenusVersion} {
+		c, err := NewCounter(code, 4095)
+		require.NoError(t, err)
+		decoded, n, err := ParseCounterQb2(c.Qb2())
+		require.NoError(t, err)
+		assert.Equal(t, c, decoded)
+		assert.Equal(t, len(c.Qb64())*3/4, n)
+	}
+}
+
+func TestParseAttachmentStream(t *testing.T) {
+	group := Group{Counter: &Counter{Code: ControllerIdxSigs}, Sigers: []*Indexer{testSiger(t, 0), testSiger(t, 1)}}
+	qb64, err := group.Qb64()
+	require.NoError(t, err)
+	qb2, err := group.Qb2()
+	require.NoError(t, err)
+
+	cold, err := Sniff([]byte(qb64))
+	require.NoError(t, err)
+	assert.Equal(t, ColdCtB64, cold)
+	cold, err = Sniff(qb2)
+	require.NoError(t, err)
+	assert.Equal(t, ColdCtOpB2, cold)
+	cold, _ = Sniff([]byte(`{"v":"KERI10JSON"}`))
+	assert.Equal(t, ColdJSON, cold)
+
+	fromText, err := ParseAttachmentStream([]byte(qb64))
+	require.NoError(t, err)
+	fromBinary, err := ParseAttachmentStream(qb2)
+	require.NoError(t, err)
+	assert.Equal(t, fromText, fromBinary)
+	assert.Len(t, fromBinary[0].Sigers, 2)
+}
+
+func TestQb2Errors(t *testing.T) {
+	_, err := Qb64ToQb2("AAA")
+	assert.Error(t, err)
+	_, err = Qb2ToQb64([]byte{1, 2})
+	assert.Error(t, err)
+
+	m, _ := NewMatter(Ed25519N, TESTBytes32)
+	_, _, err = ParseMatterQb2(m.Qb2()[:20])
+	assert.ErrorContains(t, err, "expected 33 bytes")
+	_, err = DecodeMatterQb2(append(m.Qb2(), 0, 0, 0))
+	assert.ErrorContains(t, err, "expected 33 bytes")
+
+	_, err = Sniff(nil)
+	assert.Error(t, err)
+	_, err = ParseAttachmentStream([]byte(`{"v":"KERI10JSON"}`))
+	assert.ErrorContains(t, err, "does not start with a counter")
+}
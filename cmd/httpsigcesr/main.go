@@ -0,0 +1,300 @@
+// Command httpsigcesr generates and converts the Ed25519 keys used to sign
+// requests and prints the CESR AIDs that identify them.
+//
+//	httpsigcesr keygen -out key.pem [-format pkcs8|seed|jwk|cesr|keystore] [-transferable] [-pub pubkey.txt] [-json]
+//	httpsigcesr pubkey [-in key.pem] [-format auto] [-transferable]
+//	httpsigcesr convert [-in key.pem] [-from auto] -to cesr [-out key.cesr]
+//
+// A path of "-" or an empty -in reads standard input. The AID is printed on
+// standard output, so the commands can be used in scripts.
+//
+// The keystore format is encrypted with a passphrase read from the file given
+// with -passphrase-file or from the HTTPSIGCESR_PASSPHRASE environment
+// variable.
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/keys"
+)
+
+const usage = `usage: httpsigcesr <command> [flags]
+
+commands:
+  keygen   generate a key pair
+  pubkey   print the AID of a private key
+  convert  convert a private key to another format
+
+formats: pkcs8, seed, jwk, cesr, keystore (encrypted, needs a passphrase)
+keys that are read default to auto, which detects all formats but keystore
+
+run "httpsigcesr <command> -h" for the flags of a command
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "keygen":
+		err = keygen(args[1:], stdout, stderr)
+	case "pubkey":
+		err = pubkey(args[1:], stdin, stdout, stderr)
+	case "convert":
+		err = convert(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "httpsigcesr: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "httpsigcesr %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+const (
+	// formatKeystore selects the encrypted keystore written by keys.Encrypt.
+	formatKeystore keys.Format = "keystore"
+	// formatAuto detects the format of a key that is read.
+	formatAuto keys.Format = "auto"
+)
+
+const passphraseEnv = "HTTPSIGCESR_PASSPHRASE"
+
+type formatFlag keys.Format
+
+func (f *formatFlag) String() string {
+	return string(*f)
+}
+
+func (f *formatFlag) Set(s string) error {
+	for _, format := range append(keys.Formats, formatKeystore, formatAuto) {
+		if strings.EqualFold(s, string(format)) {
+			*f = formatFlag(format)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q", s)
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// passphrase returns the keystore passphrase from a file or the environment.
+type passphrase struct {
+	file string
+}
+
+func (p *passphrase) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.file, "passphrase-file", "", "read the keystore passphrase from this file instead of $"+passphraseEnv)
+}
+
+func (p *passphrase) get() ([]byte, error) {
+	if p.file != "" {
+		b, err := os.ReadFile(p.file)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	}
+	if v := os.Getenv(passphraseEnv); v != "" {
+		return []byte(v), nil
+	}
+	return nil, errors.New("the keystore format needs -passphrase-file or $" + passphraseEnv)
+}
+
+func encodeKey(key ed25519.PrivateKey, format keys.Format, transferable bool, pass *passphrase) ([]byte, error) {
+	if format == formatAuto {
+		return nil, errors.New("auto only detects the format of a key that is read")
+	}
+	if format != formatKeystore {
+		return keys.Marshal(key, format)
+	}
+	pp, err := pass.get()
+	if err != nil {
+		return nil, err
+	}
+	var opts []keys.KeystoreOption
+	if transferable {
+		opts = append(opts, keys.WithTransferable())
+	}
+	return keys.Encrypt(key, pp, opts...)
+}
+
+func decodeKey(data []byte, format keys.Format, pass *passphrase) (ed25519.PrivateKey, error) {
+	switch format {
+	case formatAuto:
+		return keys.ParsePrivateKey(data)
+	case formatKeystore:
+	default:
+		return keys.Parse(data, format)
+	}
+	pp, err := pass.get()
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := keys.Decrypt(data, pp)
+	return key, err
+}
+
+type keygenOutput struct {
+	AID        string `json:"aid"`
+	Format     string `json:"format"`
+	Path       string `json:"path,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+func keygen(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keygen", stderr)
+	format := formatFlag(keys.FormatPKCS8)
+	fs.Var(&format, "format", "private key format")
+	out := fs.String("out", "", "write the private key to this file")
+	pub := fs.String("pub", "", "also write the AID to this file")
+	transferable := fs.Bool("transferable", false, `use the transferable "D" code instead of "B"`)
+	force := fs.Bool("force", false, "overwrite existing files")
+	asJSON := fs.Bool("json", false, "print the AID and, without -out, the private key as JSON")
+	var pass passphrase
+	pass.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" && !*asJSON {
+		return errors.New("-out is required unless -json is given")
+	}
+
+	key, err := keys.Generate()
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeKey(key, keys.Format(format), *transferable, &pass)
+	if err != nil {
+		return err
+	}
+	aid := keys.AID(key, *transferable)
+
+	if *out != "" {
+		if err := writeFile(*out, encoded, 0600, *force); err != nil {
+			return err
+		}
+	}
+	if *pub != "" {
+		if err := writeFile(*pub, []byte(aid), 0644, *force); err != nil {
+			return err
+		}
+	}
+	if !*asJSON {
+		_, err := fmt.Fprintln(stdout, aid)
+		return err
+	}
+	result := keygenOutput{AID: aid, Format: string(format), Path: *out}
+	if *out == "" {
+		result.PrivateKey = string(encoded)
+	}
+	return json.NewEncoder(stdout).Encode(result)
+}
+
+func pubkey(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("pubkey", stderr)
+	format := formatFlag(formatAuto)
+	fs.Var(&format, "format", "private key format")
+	in := fs.String("in", "-", "read the private key from this file")
+	transferable := fs.Bool("transferable", false, `use the transferable "D" code instead of "B"`)
+	var pass passphrase
+	pass.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	key, err := readKey(*in, keys.Format(format), &pass, stdin)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, keys.AID(key, *transferable))
+	return err
+}
+
+func convert(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("convert", stderr)
+	from := formatFlag(formatAuto)
+	to := formatFlag("")
+	fs.Var(&from, "from", "input format")
+	fs.Var(&to, "to", "output format")
+	in := fs.String("in", "-", "read the private key from this file")
+	out := fs.String("out", "-", "write the private key to this file")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	transferable := fs.Bool("transferable", false, `record the transferable "D" AID in a keystore`)
+	var pass passphrase
+	pass.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if to == "" {
+		return errors.New("-to is required")
+	}
+	key, err := readKey(*in, keys.Format(from), &pass, stdin)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeKey(key, keys.Format(to), *transferable, &pass)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err := stdout.Write(encoded)
+		return err
+	}
+	return writeFile(*out, encoded, 0600, *force)
+}
+
+func readKey(path string, format keys.Format, pass *passphrase, stdin io.Reader) (ed25519.PrivateKey, error) {
+	var data []byte
+	var err error
+	if path == "-" || path == "" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(data, format, pass)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
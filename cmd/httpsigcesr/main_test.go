@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runCmd(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestKeygen(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "privkey.pem")
+	pubPath := filepath.Join(dir, "pubkey.txt")
+
+	code, stdout, stderr := runCmd(t, "", "keygen", "-out", keyPath, "-pub", pubPath)
+	require.Equal(t, 0, code, stderr)
+	aid := strings.TrimSpace(stdout)
+	assert.Regexp(t, `^B[A-Za-z0-9_-]{43}$`, aid)
+
+	pub, err := os.ReadFile(pubPath)
+	require.NoError(t, err)
+	assert.Equal(t, aid, string(pub))
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	code, stdout, _ = runCmd(t, "", "pubkey", "-in", keyPath)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, aid+"\n", stdout)
+
+	code, _, stderr = runCmd(t, "", "keygen", "-out", keyPath)
+	assert.Equal(t, 1, code, "existing files are not overwritten")
+	assert.Contains(t, stderr, "exists")
+	code, _, _ = runCmd(t, "", "keygen", "-out", keyPath, "-force")
+	assert.Equal(t, 0, code)
+}
+
+func TestKeygenJSON(t *testing.T) {
+	code, stdout, stderr := runCmd(t, "", "keygen", "-json", "-format", "cesr", "-transferable")
+	require.Equal(t, 0, code, stderr)
+
+	var out keygenOutput
+	require.NoError(t, json.Unmarshal([]byte(stdout), &out))
+	assert.Equal(t, "cesr", out.Format)
+	assert.True(t, strings.HasPrefix(out.AID, "D"))
+	key, err := keys.Parse([]byte(out.PrivateKey), keys.FormatCESR)
+	require.NoError(t, err)
+	assert.Equal(t, out.AID, keys.AID(key, true))
+}
+
+func TestConvert(t *testing.T) {
+	key, err := keys.Generate()
+	require.NoError(t, err)
+	pkcs8, err := keys.Marshal(key, keys.FormatPKCS8)
+	require.NoError(t, err)
+
+	code, jwk, stderr := runCmd(t, string(pkcs8), "convert", "-to", "jwk")
+	require.Equal(t, 0, code, stderr)
+	code, seed, stderr := runCmd(t, jwk, "convert", "-from", "jwk", "-to", "seed")
+	require.Equal(t, 0, code, stderr)
+	out := filepath.Join(t.TempDir(), "key.cesr")
+	code, _, stderr = runCmd(t, seed, "convert", "-from", "seed", "-to", "cesr", "-out", out)
+	require.Equal(t, 0, code, stderr)
+
+	code, stdout, _ := runCmd(t, "", "pubkey", "-in", out, "-format", "cesr")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, keys.AID(key, false)+"\n", stdout)
+}
+
+func TestUsageErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, 2},
+		{"unknown command", []string{"sign"}, 2},
+		{"help", []string{"help"}, 0},
+		{"flag help", []string{"keygen", "-h"}, 0},
+		{"keygen without output", []string{"keygen"}, 1},
+		{"unknown format", []string{"keygen", "-json", "-format", "der"}, 1},
+		{"convert without target", []string{"convert"}, 1},
+		{"bad key", []string{"pubkey"}, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, _ := runCmd(t, "not a key", tc.args...)
+			assert.Equal(t, tc.code, code)
+		})
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "pass")
+	require.NoError(t, os.WriteFile(passFile, []byte("s3cret\n"), 0600))
+	keyPath := filepath.Join(dir, "key.keystore")
+
+	code, stdout, stderr := runCmd(t, "", "keygen", "-format", "keystore", "-passphrase-file", passFile, "-out", keyPath)
+	require.Equal(t, 0, code, stderr)
+	aid := strings.TrimSpace(stdout)
+
+	code, stdout, stderr = runCmd(t, "", "pubkey", "-format", "keystore", "-passphrase-file", passFile, "-in", keyPath)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, aid+"\n", stdout)
+
+	t.Setenv(passphraseEnv, "s3cret")
+	code, stdout, stderr = runCmd(t, "", "convert", "-from", "keystore", "-to", "cesr", "-in", keyPath)
+	require.Equal(t, 0, code, stderr)
+	key, err := keys.Parse([]byte(stdout), keys.FormatCESR)
+	require.NoError(t, err)
+	assert.Equal(t, aid, keys.AID(key, false))
+
+	t.Setenv(passphraseEnv, "wrong")
+	code, _, stderr = runCmd(t, "", "pubkey", "-format", "keystore", "-in", keyPath)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "wrong passphrase")
+
+	t.Setenv(passphraseEnv, "")
+	code, _, stderr = runCmd(t, "", "keygen", "-format", "keystore", "-json")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, passphraseEnv)
+}
+
+func TestPubkeyDetectsFormat(t *testing.T) {
+	key, err := keys.Generate()
+	require.NoError(t, err)
+	for _, f := range keys.Formats {
+		encoded, err := keys.Marshal(key, f)
+		require.NoError(t, err)
+		code, stdout, stderr := runCmd(t, string(encoded), "pubkey", "-transferable")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, keys.AID(key, true)+"\n", stdout, f)
+	}
+	code, _, _ := runCmd(t, "", "keygen", "-json", "-format", "auto")
+	assert.Equal(t, 1, code)
+}
@@ -1,20 +1,27 @@
 package digest
 
 import (
-	"bytes"
-	"crypto"
 	"encoding/base64"
 	"fmt"
 	"hash"
 	"net/http"
 	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/hashes"
 )
 
 type DigestAlgorithm string
 
 const (
-	DigestSha256 DigestAlgorithm = "SHA-256"
-	DigestSha512                 = "SHA-512"
+	DigestSha256      DigestAlgorithm = "SHA-256"
+	DigestSha512      DigestAlgorithm = "SHA-512"
+	DigestSha3_256    DigestAlgorithm = "SHA3-256"
+	DigestSha3_512    DigestAlgorithm = "SHA3-512"
+	DigestBlake3_256  DigestAlgorithm = "BLAKE3-256"
+	DigestBlake3_512  DigestAlgorithm = "BLAKE3-512"
+	DigestBlake2b_256 DigestAlgorithm = "BLAKE2B-256"
+	DigestBlake2b_512 DigestAlgorithm = "BLAKE2B-512"
+	DigestBlake2s_256 DigestAlgorithm = "BLAKE2S-256"
 )
 
 // String method for DigestAlgorithm
@@ -22,31 +29,20 @@ func (d DigestAlgorithm) String() string {
 	return string(d)
 }
 
-var digestToDef = map[DigestAlgorithm]crypto.Hash{
-	DigestSha256: crypto.SHA256,
-	DigestSha512: crypto.SHA512,
-}
-
-// IsSupportedDigestAlgorithm returns true if hte string is supported by this
-// library, is not a hash known to be weak, and is supported by the hardware.
+// IsSupportedDigestAlgorithm returns true if the string names an algorithm in
+// the hashes registry, which only holds hashes not known to be weak.
 func IsSupportedDigestAlgorithm(algo string) bool {
-	uc := DigestAlgorithm(strings.ToUpper(algo))
-	c, ok := digestToDef[uc]
-	return ok && c.Available()
+	_, ok := hashes.ByName(algo)
+	return ok
 }
 
 func getHash(alg DigestAlgorithm) (h hash.Hash, toUse DigestAlgorithm, err error) {
-	upper := DigestAlgorithm(strings.ToUpper(string(alg)))
-	c, ok := digestToDef[upper]
+	a, ok := hashes.ByName(string(alg))
 	if !ok {
 		err = fmt.Errorf("unknown or unsupported Digest algorithm: %s", alg)
-	} else if !c.Available() {
-		err = fmt.Errorf("unavailable Digest algorithm: %s", alg)
-	} else {
-		h = c.New()
-		toUse = upper
+		return
 	}
-	return
+	return a.New(), DigestAlgorithm(strings.ToUpper(a.Name)), nil
 }
 
 const (
@@ -91,9 +87,9 @@ func AddDigest(r *http.Request, algo DigestAlgorithm, b []byte, withPadding ...b
 	return
 }
 
+// AddDigestResponse is AddDigest for a response that has not been written yet.
 func AddDigestResponse(r http.ResponseWriter, algo DigestAlgorithm, b []byte, withPadding ...bool) (err error) {
-	_, ok := r.Header()[digestHeader]
-	if ok {
+	if r.Header().Get(digestHeader) != "" {
 		err = fmt.Errorf("cannot add Digest: Digest is already set")
 		return
 	}
@@ -117,47 +113,23 @@ func AddDigestResponse(r http.ResponseWriter, algo DigestAlgorithm, b []byte, wi
 	    edig = base64.RawURLEncoding.EncodeToString(sum[:]) // Unpadded Base64
 	}
 	r.Header().Add(digestHeader,
-		fmt.Sprintf("%s%s%s",
-			a,
+		fmt.Sprintf("%s%s:%s:",
+			strings.ToLower(string(a)),
 			digestDelim,
 			edig))
 	return
 }
 
-func verifyDigest(r *http.Request, body *bytes.Buffer, withPadding ...bool) (err error) {
-	d := r.Header.Get(digestHeader)
-	if len(d) == 0 {
-		err = fmt.Errorf("cannot verify Digest: request has no Digest header")
-		return
-	}
-	elem := strings.SplitN(d, digestDelim, 2)
-	if len(elem) != 2 {
-		err = fmt.Errorf("cannot verify Digest: malformed Digest: %s", d)
-		return
-	}
-	var h hash.Hash
-	h, _, err = getHash(DigestAlgorithm(elem[0]))
-	if err != nil {
-		return
-	}
-	h.Write(body.Bytes())
-	sum := h.Sum(nil)
-	// Determine whether to use padding
-	usePadding := true
-	if len(withPadding) > 0 {
-	    usePadding = withPadding[0]
-	}
-	var encSum string
-	if usePadding {
-	    encSum = fmt.Sprintf(":%s:", base64.URLEncoding.EncodeToString(sum[:])) // Padded Base64
-	} else {
-	    encSum = fmt.Sprintf(":%s:", base64.RawURLEncoding.EncodeToString(sum[:])) // Unpadded Base64
-	}
-	fmt.Printf("\n")
-	fmt.Printf("encSum: %s elem1: %s", encSum, elem[1])
-	if encSum != elem[1] {
-		err = fmt.Errorf("cannot verify Digest: header Digest does not match the digest of the request body")
-		return
-	}
-	return
+// VerifyDigest checks the content-digest header of r against body. Both the
+// padded and unpadded encodings written by AddDigest are accepted.
+func VerifyDigest(r *http.Request, body []byte) error {
+	return VerifyHeaderDigest(r.Header, body)
+}
+
+// VerifyHeaderDigest checks the content-digest in a request or response header
+// against body. Both the RFC 9530 and the signify encodings are accepted, and
+// every supported algorithm in the header must match.
+func VerifyHeaderDigest(header http.Header, body []byte) error {
+	_, err := verifyField(header, body, &verifyConfig{field: ContentDigest, policy: PolicyAll})
+	return err
 }
@@ -1,8 +1,8 @@
 package digest
 
 import (
-	"bytes"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -109,7 +109,6 @@ func TestVerifyDigest(t *testing.T) {
 		name        string
 		r           func() *http.Request
 		body        []byte
-		withPadding bool
 		expectError bool
 	}{
 		{
@@ -120,7 +119,6 @@ func TestVerifyDigest(t *testing.T) {
 				return r
 			},
 			body: []byte("johnny grab your gun"),
-			withPadding: true,
 		},
 		{
 			name: "verify sha256 without padding",
@@ -130,7 +128,6 @@ func TestVerifyDigest(t *testing.T) {
 				return r
 			},
 			body: []byte("johnny grab your gun"),
-			withPadding: false,
 		},
 		{
 			name: "verify sha512",
@@ -140,7 +137,6 @@ func TestVerifyDigest(t *testing.T) {
 				return r
 			},
 			body: []byte("yours is the drill that will pierce the heavens"),
-			withPadding: true,
 		},
 		{
 			name: "verify sha512 without padding",
@@ -150,7 +146,6 @@ func TestVerifyDigest(t *testing.T) {
 				return r
 			},
 			body: []byte("yours is the drill that will pierce the heavens"),
-			withPadding: false,
 		},
 		{
 			name: "no digest header",
@@ -196,8 +191,7 @@ func TestVerifyDigest(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			test := test
 			req := test.r()
-			buf := bytes.NewBuffer(test.body)
-			err := verifyDigest(req, buf, test.withPadding)
+			err := VerifyDigest(req, test.body)
 			gotErr := err != nil
 			if gotErr != test.expectError {
 				if test.expectError {
@@ -209,3 +203,36 @@ func TestVerifyDigest(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyDigestExported(t *testing.T) {
+	for _, header := range []string{
+		"sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:",
+		"sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:",
+	} {
+		r, _ := http.NewRequest("POST", "example.com", nil)
+		r.Header.Set("content-digest", header)
+		if err := VerifyDigest(r, []byte("johnny grab your gun")); err != nil {
+			t.Fatalf("expected no error for %s, got: %s", header, err)
+		}
+		if err := VerifyDigest(r, []byte("johnny drop your gun")); err == nil {
+			t.Fatalf("expected error for tampered body with %s", header)
+		}
+	}
+}
+
+func TestAddDigestResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := AddDigestResponse(w, DigestSha256, []byte("johnny grab your gun"), false); err != nil {
+		t.Fatalf("expected no error, got: %s", err)
+	}
+	expected := "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:"
+	if d := w.Header().Get("content-digest"); d != expected {
+		t.Fatalf("unexpected digest: want %s, got %s", expected, d)
+	}
+	if err := AddDigestResponse(w, DigestSha256, []byte("again")); err == nil {
+		t.Fatalf("expected error when digest is already set")
+	}
+	if err := VerifyHeaderDigest(w.Header(), []byte("johnny grab your gun")); err != nil {
+		t.Fatalf("expected no error, got: %s", err)
+	}
+}
@@ -0,0 +1,184 @@
+package digest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	sf "github.com/Wavecrest/httpsigcesr/structuredfields"
+)
+
+// RFC 9530 integrity fields.
+const (
+	ContentDigest     = "Content-Digest"
+	ReprDigest        = "Repr-Digest"
+	WantContentDigest = "Want-Content-Digest"
+	WantReprDigest    = "Want-Repr-Digest"
+)
+
+// Encoding selects how digest values are written.
+type Encoding int
+
+const (
+	// EncodingRFC9530 writes a Dictionary of standard Base64 byte sequences,
+	// e.g. sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:.
+	EncodingRFC9530 Encoding = iota
+	// EncodingSignify writes URL-safe Base64 without padding, the form written
+	// by AddDigest and expected by signify-ts and KERIA,
+	// e.g. sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:.
+	EncodingSignify
+)
+
+type options struct {
+	algorithms []DigestAlgorithm
+	encoding   Encoding
+}
+
+// Option configures Value, SetContentDigest and SetReprDigest.
+type Option func(*options)
+
+// WithAlgorithms selects the algorithms to include, one Dictionary member
+// each. The default is SHA-256.
+func WithAlgorithms(algos ...DigestAlgorithm) Option {
+	return func(o *options) {
+		o.algorithms = algos
+	}
+}
+
+// WithEncoding selects the encoding. The default is EncodingRFC9530.
+func WithEncoding(e Encoding) Option {
+	return func(o *options) {
+		o.encoding = e
+	}
+}
+
+// Value returns a digest field value for data.
+func Value(data []byte, opts ...Option) (string, error) {
+	o := &options{algorithms: []DigestAlgorithm{DigestSha256}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.algorithms) == 0 {
+		return "", fmt.Errorf("no Digest algorithm selected")
+	}
+
+	members := make([]string, 0, len(o.algorithms))
+	for _, algo := range o.algorithms {
+		h, a, err := getHash(algo)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+		members = append(members, encodeDigest(a, h.Sum(nil), o.encoding))
+	}
+	return strings.Join(members, ", "), nil
+}
+
+// encodeDigest returns a single Dictionary member for sum.
+func encodeDigest(a DigestAlgorithm, sum []byte, e Encoding) string {
+	if e == EncodingSignify {
+		return formatDigest(a, sum, false)
+	}
+	return fmt.Sprintf("%s%s:%s:", strings.ToLower(string(a)), digestDelim, base64.StdEncoding.EncodeToString(sum))
+}
+
+// SetContentDigest sets the Content-Digest of the message content in header.
+func SetContentDigest(header http.Header, body []byte, opts ...Option) error {
+	return setField(header, ContentDigest, body, opts)
+}
+
+// SetReprDigest sets the Repr-Digest of the selected representation in
+// header, for example the complete representation of a range response.
+func SetReprDigest(header http.Header, representation []byte, opts ...Option) error {
+	return setField(header, ReprDigest, representation, opts)
+}
+
+func setField(header http.Header, field string, data []byte, opts []Option) error {
+	value, err := Value(data, opts...)
+	if err != nil {
+		return err
+	}
+	header.Set(field, value)
+	return nil
+}
+
+// VerifyReprDigest checks the Repr-Digest in header against representation.
+func VerifyReprDigest(header http.Header, representation []byte) error {
+	_, err := verifyField(header, representation, &verifyConfig{field: ReprDigest, policy: PolicyAll})
+	return err
+}
+
+// Preference is an algorithm and its weight in a Want-Content-Digest or
+// Want-Repr-Digest field, from 1 (least preferred) to 10. Weight 0 means the
+// algorithm is not acceptable.
+type Preference struct {
+	Algorithm DigestAlgorithm
+	Weight    int
+}
+
+// SetWantDigest asks the peer for digests with the given preferences. field is
+// WantContentDigest or WantReprDigest.
+func SetWantDigest(header http.Header, field string, prefs ...Preference) error {
+	var dict sf.Dictionary
+	for _, p := range prefs {
+		if p.Weight < 0 || p.Weight > 10 {
+			return fmt.Errorf("invalid weight %d for %s", p.Weight, p.Algorithm)
+		}
+		dict = append(dict, sf.DictMember{Key: strings.ToLower(string(p.Algorithm)), Value: sf.Item{Value: p.Weight}})
+	}
+	value, err := sf.SerializeDictionary(dict)
+	if err != nil {
+		return err
+	}
+	header.Set(field, value)
+	return nil
+}
+
+// ParseWantDigest parses a Want-Content-Digest or Want-Repr-Digest value and
+// returns the preferences ordered from most to least preferred.
+func ParseWantDigest(value string) ([]Preference, error) {
+	dict, err := sf.ParseDictionary(value)
+	if err != nil {
+		return nil, err
+	}
+	var prefs []Preference
+	for _, m := range dict {
+		item, ok := m.Value.(sf.Item)
+		if !ok {
+			return nil, fmt.Errorf("invalid preference for %s", m.Key)
+		}
+		weight, ok := item.Value.(int64)
+		if !ok || weight < 0 || weight > 10 {
+			return nil, fmt.Errorf("invalid preference for %s", m.Key)
+		}
+		prefs = append(prefs, Preference{Algorithm: DigestAlgorithm(strings.ToUpper(m.Key)), Weight: int(weight)})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].Weight > prefs[j].Weight })
+	return prefs, nil
+}
+
+// Negotiate returns the supported algorithms the peer asks for in the Want
+// field of header, most preferred first. fallback is returned if the field is
+// absent, malformed or names no supported algorithm.
+func Negotiate(header http.Header, field string, fallback ...DigestAlgorithm) []DigestAlgorithm {
+	value := strings.Join(header.Values(field), ", ")
+	if value == "" {
+		return fallback
+	}
+	prefs, err := ParseWantDigest(value)
+	if err != nil {
+		return fallback
+	}
+	var algos []DigestAlgorithm
+	for _, p := range prefs {
+		if p.Weight > 0 && IsSupportedDigestAlgorithm(string(p.Algorithm)) {
+			algos = append(algos, p.Algorithm)
+		}
+	}
+	if len(algos) == 0 {
+		return fallback
+	}
+	return algos
+}
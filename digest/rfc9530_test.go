@@ -0,0 +1,153 @@
+package digest
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	body := []byte("johnny grab your gun")
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{
+			name:     "default",
+			expected: "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:",
+		},
+		{
+			name:     "signify",
+			opts:     []Option{WithEncoding(EncodingSignify)},
+			expected: "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:",
+		},
+		{
+			name:     "multiple algorithms",
+			opts:     []Option{WithAlgorithms(DigestSha512, DigestSha256)},
+			expected: "sha-512=:SryuzzRzrReZE+SGQIhyuXqB44VIpglbwlhvXubKl8qMtxm95Jg90nlIZ+Zhu0RWShhIczk57yatCvjWY/4r4w==:, sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := Value(body, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, v)
+			}
+		})
+	}
+	if _, err := Value(body, WithAlgorithms("MD5")); err == nil {
+		t.Fatal("expected an error for MD5")
+	}
+	if _, err := Value(body, WithAlgorithms()); err == nil {
+		t.Fatal("expected an error without algorithms")
+	}
+}
+
+func TestVerifyRFC9530(t *testing.T) {
+	body := []byte("johnny grab your gun")
+	tests := []struct {
+		name        string
+		value       string
+		expectError bool
+		mismatch    bool
+	}{
+		{name: "rfc", value: "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"},
+		{name: "signify", value: "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:"},
+		{name: "unsupported member ignored", value: "md5=:AAAA:, sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"},
+		{name: "all members checked", value: "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:, sha-512=:AAAA:", expectError: true, mismatch: true},
+		{name: "only unsupported", value: "md5=:AAAA:", expectError: true},
+		{name: "not a byte sequence", value: "sha-256=RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=", expectError: true},
+		{name: "bad base64", value: "sha-256=:R!:", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set(ContentDigest, test.value)
+			err := VerifyHeaderDigest(h, body)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+			var derr *DigestError
+			if test.mismatch && (!errors.As(err, &derr) || derr.Kind != ErrDigestMismatch) {
+				t.Fatalf("expected ErrDigestMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReprDigest(t *testing.T) {
+	repr := []byte("the whole representation")
+	h := http.Header{}
+	if err := SetReprDigest(h, repr, WithAlgorithms(DigestSha256, DigestSha512)); err != nil {
+		t.Fatal(err)
+	}
+	if h.Get(ContentDigest) != "" {
+		t.Fatal("Repr-Digest must not set Content-Digest")
+	}
+	if err := VerifyReprDigest(h, repr); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReprDigest(h, repr[:8]); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+	if err := VerifyReprDigest(http.Header{}, repr); err == nil {
+		t.Fatal("expected an error without Repr-Digest")
+	}
+}
+
+func TestWantDigest(t *testing.T) {
+	h := http.Header{}
+	err := SetWantDigest(h, WantContentDigest,
+		Preference{Algorithm: DigestSha256, Weight: 3},
+		Preference{Algorithm: DigestSha512, Weight: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := h.Get(WantContentDigest); v != "sha-256=3, sha-512=10" {
+		t.Fatalf("unexpected value %s", v)
+	}
+	if err := SetWantDigest(h, WantContentDigest, Preference{Algorithm: DigestSha256, Weight: 11}); err == nil {
+		t.Fatal("expected an error for weight 11")
+	}
+
+	prefs, err := ParseWantDigest("md5=9, sha-256=3, sha-512=10, unixsum=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Preference{{"SHA-512", 10}, {"MD5", 9}, {"SHA-256", 3}, {"UNIXSUM", 0}}
+	if !reflect.DeepEqual(prefs, expected) {
+		t.Fatalf("expected %v, got %v", expected, prefs)
+	}
+	if _, err := ParseWantDigest("sha-256=:AAAA:"); err == nil {
+		t.Fatal("expected an error for a non-integer weight")
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []DigestAlgorithm
+	}{
+		{name: "absent", expected: []DigestAlgorithm{DigestSha256}},
+		{name: "preferred first", value: "md5=9, sha-256=3, sha-512=10", expected: []DigestAlgorithm{DigestSha512, DigestSha256}},
+		{name: "weight zero excluded", value: "sha-256=0, sha-512=1", expected: []DigestAlgorithm{DigestSha512}},
+		{name: "nothing supported", value: "md5=10", expected: []DigestAlgorithm{DigestSha256}},
+		{name: "malformed", value: "sha-256=x y", expected: []DigestAlgorithm{DigestSha256}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			if test.value != "" {
+				h.Set(WantContentDigest, test.value)
+			}
+			got := Negotiate(h, WantContentDigest, DigestSha256)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package digest
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/hashes"
+)
+
+// HashingReader hashes everything read through it, so a digest can be
+// computed while a body is being sent or stored.
+type HashingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	algo DigestAlgorithm
+}
+
+func NewHashingReader(r io.Reader, algo DigestAlgorithm) (*HashingReader, error) {
+	h, a, err := getHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	return &HashingReader{r: r, h: h, algo: a}, nil
+}
+
+func (hr *HashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+// Digest returns the content-digest value for the bytes read so far, padded
+// unless withPadding is false.
+func (hr *HashingReader) Digest(withPadding ...bool) string {
+	return formatDigest(hr.algo, hr.h.Sum(nil), withPadding...)
+}
+
+// Encode returns the digest of the bytes read so far with the given encoding.
+func (hr *HashingReader) Encode(e Encoding) string {
+	return encodeDigest(hr.algo, hr.h.Sum(nil), e)
+}
+
+func formatDigest(a DigestAlgorithm, sum []byte, withPadding ...bool) string {
+	enc := base64.URLEncoding
+	if len(withPadding) > 0 && !withPadding[0] {
+		enc = base64.RawURLEncoding
+	}
+	return fmt.Sprintf("%s%s:%s:", strings.ToLower(string(a)), digestDelim, enc.EncodeToString(sum))
+}
+
+// AddDigestFromReader hashes body in a single pass without buffering it, then
+// rewinds it and makes it the request body. ContentLength and GetBody are set
+// so the request can be sent and retried, e.g. for an *os.File.
+func AddDigestFromReader(r *http.Request, algo DigestAlgorithm, body io.ReadSeeker, withPadding ...bool) error {
+	if r.Header.Get(digestHeader) != "" {
+		return fmt.Errorf("cannot add Digest: Digest is already set")
+	}
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hr, err := NewHashingReader(body, algo)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(io.Discard, hr)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	r.Header.Add(digestHeader, hr.Digest(withPadding...))
+	r.Body = io.NopCloser(body)
+	r.ContentLength = n
+	r.GetBody = func() (io.ReadCloser, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(body), nil
+	}
+	return nil
+}
+
+// AddDigestTrailer sends the content-digest of the request body as a trailer,
+// computed while the body is sent, for bodies that can only be read once.
+//
+// A signature made before sending cannot cover the trailer, so the signed
+// components must not include content-digest, and the trailer only protects
+// the body against corruption in transit: it does not authenticate the body,
+// which anyone who can alter the request can replace along with the trailer.
+// Use AddDigestFromReader for bodies that can be read twice.
+func AddDigestTrailer(r *http.Request, algo DigestAlgorithm, withPadding ...bool) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return fmt.Errorf("cannot add Digest trailer: request has no body")
+	}
+	hr, err := NewHashingReader(r.Body, algo)
+	if err != nil {
+		return err
+	}
+	if r.Trailer == nil {
+		r.Trailer = http.Header{}
+	}
+	r.Trailer[http.CanonicalHeaderKey(digestHeader)] = nil
+	r.Body = &trailerBody{HashingReader: hr, closer: r.Body, trailer: r.Trailer, withPadding: withPadding}
+	r.ContentLength = -1
+	r.GetBody = nil
+	return nil
+}
+
+type trailerBody struct {
+	*HashingReader
+	closer      io.Closer
+	trailer     http.Header
+	withPadding []bool
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.HashingReader.Read(p)
+	if err == io.EOF {
+		b.trailer.Set(digestHeader, b.Digest(b.withPadding...))
+	}
+	return n, err
+}
+
+func (b *trailerBody) Close() error {
+	return b.closer.Close()
+}
+
+// VerifyingReader hashes a body as it is read and, once the body is fully
+// read, returns a DigestError of kind ErrDigestMismatch instead of io.EOF if
+// it does not match the content-digest.
+type VerifyingReader struct {
+	body    io.ReadCloser
+	header  string
+	trailer func() http.Header
+	hashes  map[DigestAlgorithm]hash.Hash
+	err     error
+}
+
+// NewVerifyingReader wraps the body of a received request. The digest is taken
+// from the content-digest header or, if the request announces it, from the
+// content-digest trailer.
+func NewVerifyingReader(r *http.Request) (*VerifyingReader, error) {
+	return newVerifyingReader(r.Body, r.Header, func() http.Header { return r.Trailer })
+}
+
+// NewVerifyingResponseReader is NewVerifyingReader for a response body.
+func NewVerifyingResponseReader(resp *http.Response) (*VerifyingReader, error) {
+	return newVerifyingReader(resp.Body, resp.Header, func() http.Header { return resp.Trailer })
+}
+
+func newVerifyingReader(body io.ReadCloser, header http.Header, trailer func() http.Header) (*VerifyingReader, error) {
+	if body == nil {
+		body = http.NoBody
+	}
+	vr := &VerifyingReader{
+		body:    body,
+		header:  header.Get(digestHeader),
+		trailer: trailer,
+		hashes:  map[DigestAlgorithm]hash.Hash{},
+	}
+	if vr.header != "" {
+		members, err := parseDigestField(vr.header)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if h, a, err := getHash(m.algorithm); err == nil {
+				vr.hashes[a] = h
+			}
+		}
+		if len(vr.hashes) == 0 {
+			return nil, digestError(ErrUnsupportedDigest, "%s", vr.header)
+		}
+		return vr, nil
+	}
+	if _, ok := trailer()[http.CanonicalHeaderKey(digestHeader)]; !ok {
+		return nil, digestError(ErrMissingDigest, "no header or trailer")
+	}
+	// the algorithm is only known at the end, so hash with all of them
+	for _, name := range hashes.Names() {
+		h, a, _ := getHash(DigestAlgorithm(name))
+		vr.hashes[a] = h
+	}
+	return vr, nil
+}
+
+func (vr *VerifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	n, err := vr.body.Read(p)
+	for _, h := range vr.hashes {
+		h.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verr := vr.verify(); verr != nil {
+			err = verr
+		}
+	}
+	if err != nil {
+		vr.err = err
+	}
+	return n, err
+}
+
+func (vr *VerifyingReader) Close() error {
+	return vr.body.Close()
+}
+
+func (vr *VerifyingReader) verify() error {
+	value := vr.header
+	if value == "" {
+		value = vr.trailer().Get(digestHeader)
+	}
+	if value == "" {
+		return digestError(ErrMissingDigest, "no trailer")
+	}
+	members, err := parseDigestField(value)
+	if err != nil {
+		return err
+	}
+	verified := 0
+	for _, m := range members {
+		h, ok := vr.hashes[m.algorithm]
+		if !ok {
+			continue
+		}
+		if subtle.ConstantTimeCompare(h.Sum(nil), m.sum) != 1 {
+			return digestError(ErrDigestMismatch, "%s", m.algorithm)
+		}
+		verified++
+	}
+	if verified == 0 {
+		return digestError(ErrUnsupportedDigest, "%s", value)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package digest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHashingReader(t *testing.T) {
+	body := []byte("johnny grab your gun")
+	hr, err := NewHashingReader(bytes.NewReader(body), DigestSha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		t.Fatal(err)
+	}
+	if d := hr.Digest(); d != "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:" {
+		t.Fatalf("unexpected digest %s", d)
+	}
+	if d := hr.Digest(false); d != "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:" {
+		t.Fatalf("unexpected digest %s", d)
+	}
+	if _, err := NewHashingReader(bytes.NewReader(body), "MD5"); err == nil {
+		t.Fatal("expected an error for MD5")
+	}
+}
+
+func TestAddDigestFromReader(t *testing.T) {
+	body := "yours is the drill that will pierce the heavens"
+	r, _ := http.NewRequest("POST", "example.com", nil)
+	seeker := strings.NewReader("ignored" + body)
+	seeker.Seek(int64(len("ignored")), io.SeekStart)
+	if err := AddDigestFromReader(r, DigestSha512, seeker, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := http.NewRequest("POST", "example.com", nil)
+	AddDigest(expected, DigestSha512, []byte(body), false)
+	if r.Header.Get(digestHeader) != expected.Header.Get(digestHeader) {
+		t.Fatalf("expected %s, got %s", expected.Header.Get(digestHeader), r.Header.Get(digestHeader))
+	}
+	if r.ContentLength != int64(len(body)) {
+		t.Fatalf("unexpected content length %d", r.ContentLength)
+	}
+	for i := 0; i < 2; i++ {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != body {
+			t.Fatalf("unexpected body %q", b)
+		}
+		r.Body, _ = r.GetBody()
+	}
+
+	if err := AddDigestFromReader(r, DigestSha512, seeker); err == nil {
+		t.Fatal("expected an error when the digest is already set")
+	}
+}
+
+func TestDigestTrailer(t *testing.T) {
+	var got string
+	var readErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vr, err := NewVerifyingReader(r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, err := io.ReadAll(vr)
+		got, readErr = string(b), err
+	}))
+	defer server.Close()
+
+	// a body of unknown length that can only be read once
+	body := io.MultiReader(strings.NewReader("part one, "), strings.NewReader("part two"))
+	r, _ := http.NewRequest("PUT", server.URL, io.NopCloser(body))
+	if err := AddDigestTrailer(r, DigestSha256); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if readErr != nil {
+		t.Fatalf("unexpected error %s", readErr)
+	}
+	if got != "part one, part two" {
+		t.Fatalf("unexpected body %q", got)
+	}
+}
+
+func TestVerifyingReader(t *testing.T) {
+	body := []byte("johnny grab your gun")
+	tests := []struct {
+		name    string
+		header  string
+		trailer http.Header
+		body    string
+		wantErr error
+		newErr  bool
+	}{
+		{name: "header", header: "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:", body: string(body)},
+		{name: "unpadded header", header: "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:", body: string(body)},
+		{name: "rfc 9530 header", header: "md5=:AAAA:, sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:", body: string(body)},
+		{name: "every member checked", header: "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:, sha-512=:AAAA:", body: string(body), wantErr: ErrDigestMismatch},
+		{name: "rfc 9530 trailer", trailer: http.Header{"Content-Digest": {"sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"}}, body: string(body)},
+		{name: "tampered body", header: "sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:", body: "johnny drop your gun", wantErr: ErrDigestMismatch},
+		{name: "trailer", trailer: http.Header{"Content-Digest": {"sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"}}, body: string(body)},
+		{name: "tampered trailer", trailer: http.Header{"Content-Digest": {"sha-256=:AAAAVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"}}, body: string(body), wantErr: ErrDigestMismatch},
+		{name: "no digest", newErr: true},
+		{name: "unsupported", header: "md5=:AAAA:", newErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			if test.header != "" {
+				r.Header.Set(digestHeader, test.header)
+			}
+			r.Trailer = test.trailer
+			vr, err := NewVerifyingReader(r)
+			if test.newErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(vr)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr != nil {
+				var derr *DigestError
+				if !errors.As(err, &derr) || derr.Kind != test.wantErr {
+					t.Fatalf("expected a DigestError, got %v", err)
+				}
+				if _, again := vr.Read(make([]byte, 1)); again != err {
+					t.Fatalf("expected the error to persist, got %v", again)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package digest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/hashes"
+	sf "github.com/Wavecrest/httpsigcesr/structuredfields"
+)
+
+var (
+	ErrMissingDigest     = errors.New("message has no content-digest")
+	ErrMalformedDigest   = errors.New("malformed content-digest")
+	ErrUnsupportedDigest = errors.New("no supported content-digest algorithm")
+	ErrDigestMismatch    = errors.New("content-digest does not match the body")
+)
+
+// DigestError is returned by the Verify functions. Kind is one of the Err*
+// values above, so callers can match it with errors.Is.
+type DigestError struct {
+	Kind   error
+	Reason string
+}
+
+func (e *DigestError) Error() string {
+	if e.Reason == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Reason)
+}
+
+func (e *DigestError) Unwrap() error {
+	return e.Kind
+}
+
+func digestError(kind error, format string, args ...interface{}) error {
+	return &DigestError{Kind: kind, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Policy decides which members of a digest field are checked.
+type Policy int
+
+const (
+	// PolicyStrongest checks only the strongest supported algorithm.
+	PolicyStrongest Policy = iota
+	// PolicyAll checks every supported algorithm.
+	PolicyAll
+)
+
+type verifyConfig struct {
+	field   string
+	policy  Policy
+	allowed map[DigestAlgorithm]bool
+}
+
+// VerifyOption configures VerifyRequestDigest and VerifyResponseDigest.
+type VerifyOption func(*verifyConfig)
+
+// WithPolicy selects which algorithms are checked. The default is
+// PolicyStrongest.
+func WithPolicy(p Policy) VerifyOption {
+	return func(c *verifyConfig) {
+		c.policy = p
+	}
+}
+
+// WithAllowedAlgorithms restricts the algorithms that are accepted. Members
+// with other algorithms are ignored.
+func WithAllowedAlgorithms(algos ...DigestAlgorithm) VerifyOption {
+	return func(c *verifyConfig) {
+		c.allowed = map[DigestAlgorithm]bool{}
+		for _, a := range algos {
+			c.allowed[DigestAlgorithm(strings.ToUpper(string(a)))] = true
+		}
+	}
+}
+
+// WithField checks a different field, e.g. ReprDigest. The default is
+// ContentDigest.
+func WithField(field string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.field = field
+	}
+}
+
+// VerifyRequestDigest checks the content-digest of r against body and returns
+// the algorithm that was checked, or the strongest one under PolicyAll.
+func VerifyRequestDigest(r *http.Request, body []byte, opts ...VerifyOption) (DigestAlgorithm, error) {
+	return verifyHeader(r.Header, body, opts)
+}
+
+// VerifyResponseDigest is VerifyRequestDigest for a response.
+func VerifyResponseDigest(resp *http.Response, body []byte, opts ...VerifyOption) (DigestAlgorithm, error) {
+	return verifyHeader(resp.Header, body, opts)
+}
+
+func verifyHeader(header http.Header, body []byte, opts []VerifyOption) (DigestAlgorithm, error) {
+	cfg := &verifyConfig{field: ContentDigest, policy: PolicyStrongest}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return verifyField(header, body, cfg)
+}
+
+// verifyField checks a digest field in either encoding against data.
+func verifyField(header http.Header, data []byte, cfg *verifyConfig) (DigestAlgorithm, error) {
+	value := strings.Join(header.Values(cfg.field), ", ")
+	if value == "" {
+		return "", digestError(ErrMissingDigest, "no %s header", cfg.field)
+	}
+	members, err := parseDigestField(value)
+	if err != nil {
+		return "", err
+	}
+	var supported []digestMember
+	for _, m := range members {
+		if cfg.allowed != nil && !cfg.allowed[m.algorithm] {
+			continue
+		}
+		if IsSupportedDigestAlgorithm(string(m.algorithm)) {
+			supported = append(supported, m)
+		}
+	}
+	if len(supported) == 0 {
+		return "", digestError(ErrUnsupportedDigest, "%s", value)
+	}
+	strongest := supported[0]
+	for _, m := range supported[1:] {
+		if strength(m.algorithm) > strength(strongest.algorithm) {
+			strongest = m
+		}
+	}
+	if cfg.policy == PolicyStrongest {
+		supported = []digestMember{strongest}
+	}
+	for _, m := range supported {
+		h, _, err := getHash(m.algorithm)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+		if subtle.ConstantTimeCompare(h.Sum(nil), m.sum) != 1 {
+			return "", digestError(ErrDigestMismatch, "%s", m.algorithm)
+		}
+	}
+	return strongest.algorithm, nil
+}
+
+// strength ranks algorithms by digest size. Among algorithms of the same size
+// the first member wins.
+func strength(algo DigestAlgorithm) int {
+	a, _ := hashes.ByName(string(algo))
+	return a.Size
+}
+
+type digestMember struct {
+	algorithm DigestAlgorithm
+	sum       []byte
+}
+
+// parseDigestField parses a digest field, an RFC 8941 Dictionary of byte
+// sequences, written with either encoding. Member parameters are ignored.
+func parseDigestField(value string) ([]digestMember, error) {
+	dict, err := sf.ParseDictionary(standardAlphabet(value))
+	if err != nil {
+		return nil, digestError(ErrMalformedDigest, "%s", err)
+	}
+	var members []digestMember
+	for _, m := range dict {
+		item, ok := m.Value.(sf.Item)
+		if !ok {
+			return nil, digestError(ErrMalformedDigest, "%s is not a byte sequence", m.Key)
+		}
+		sum, ok := item.Value.([]byte)
+		if !ok {
+			return nil, digestError(ErrMalformedDigest, "%s is not a byte sequence", m.Key)
+		}
+		members = append(members, digestMember{algorithm: DigestAlgorithm(strings.ToUpper(m.Key)), sum: sum})
+	}
+	return members, nil
+}
+
+// standardAlphabet rewrites the URL-safe Base64 of the signify encoding to
+// the standard alphabet inside byte sequences, leaving strings alone, so the
+// field parses as a Dictionary.
+func standardAlphabet(value string) string {
+	b := []byte(value)
+	inString, inBytes := false, false
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"' && !inBytes:
+			inString = !inString
+		case c == ':' && !inString:
+			inBytes = !inBytes
+		case inBytes && c == '-':
+			b[i] = '+'
+		case inBytes && c == '_':
+			b[i] = '/'
+		}
+	}
+	return string(b)
+}
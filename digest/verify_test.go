@@ -0,0 +1,109 @@
+package digest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRequestDigest(t *testing.T) {
+	body := []byte("johnny grab your gun")
+	sha256 := "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"
+	sha512 := "sha-512=:SryuzzRzrReZE+SGQIhyuXqB44VIpglbwlhvXubKl8qMtxm95Jg90nlIZ+Zhu0RWShhIczk57yatCvjWY/4r4w==:"
+	bad256 := "sha-256=:AAAAVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:"
+	tests := []struct {
+		name     string
+		values   []string
+		opts     []VerifyOption
+		expected DigestAlgorithm
+		wantErr  error
+	}{
+		{name: "single", values: []string{sha256}, expected: DigestSha256},
+		{name: "signify encoding", values: []string{"sha-256=:RYiuVuVdRpU-BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk:"}, expected: DigestSha256},
+		{name: "strongest chosen", values: []string{sha256 + ", " + sha512}, expected: DigestSha512},
+		{name: "multiple field lines", values: []string{sha256, sha512}, expected: DigestSha512},
+		{name: "weaker member not checked", values: []string{bad256 + ", " + sha512}, expected: DigestSha512},
+		{name: "all checked", values: []string{bad256 + ", " + sha512}, opts: []VerifyOption{WithPolicy(PolicyAll)}, wantErr: ErrDigestMismatch},
+		{name: "allowed algorithms", values: []string{sha256 + ", " + sha512}, opts: []VerifyOption{WithAllowedAlgorithms("sha-256")}, expected: DigestSha256},
+		{name: "none allowed", values: []string{sha512}, opts: []VerifyOption{WithAllowedAlgorithms(DigestSha256)}, wantErr: ErrUnsupportedDigest},
+		{name: "mismatch", values: []string{bad256}, wantErr: ErrDigestMismatch},
+		{name: "missing", wantErr: ErrMissingDigest},
+		{name: "malformed", values: []string{"sha-256"}, wantErr: ErrMalformedDigest},
+		{name: "bad base64", values: []string{"sha-256=:R!:"}, wantErr: ErrMalformedDigest},
+		{name: "unsupported", values: []string{"md5=:AAAA:"}, wantErr: ErrUnsupportedDigest},
+		{name: "member parameters", values: []string{sha256 + `;x=1;note="a:b-c", ` + sha512 + ";y"}, expected: DigestSha512},
+		{name: "whitespace", values: []string{"  " + sha256 + " ,\t" + sha512}, expected: DigestSha512},
+		{name: "inner list", values: []string{"sha-256=(:AAAA:)"}, wantErr: ErrMalformedDigest},
+		{name: "string value", values: []string{`sha-256="RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk="`}, wantErr: ErrMalformedDigest},
+		{name: "trailing comma", values: []string{sha256 + ","}, wantErr: ErrMalformedDigest},
+		{name: "repr-digest field", values: []string{sha256}, opts: []VerifyOption{WithField(ReprDigest)}, wantErr: ErrMissingDigest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "example.com", nil)
+			for _, v := range test.values {
+				r.Header.Add(ContentDigest, v)
+			}
+			algo, err := VerifyRequestDigest(r, body, test.opts...)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+			var derr *DigestError
+			if test.wantErr != nil && (!errors.As(err, &derr) || derr.Kind != test.wantErr) {
+				t.Fatalf("expected a DigestError, got %v", err)
+			}
+			if algo != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, algo)
+			}
+		})
+	}
+}
+
+func TestVerifyResponseDigest(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set(ReprDigest, "sha-256=:RYiuVuVdRpU+BWcNUUg3sf0EbJjQ9LDj9tUqR546hhk=:")
+	if _, err := VerifyResponseDigest(resp, []byte("johnny grab your gun"), WithField(ReprDigest)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := VerifyResponseDigest(resp, nil)
+	var derr *DigestError
+	if !errors.As(err, &derr) || derr.Kind != ErrMissingDigest {
+		t.Fatalf("expected a DigestError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Content-Digest") {
+		t.Fatalf("expected the field in %q", err)
+	}
+}
+
+func TestKERIDigestAlgorithms(t *testing.T) {
+	body := []byte(`{"d":"","i":"EAbc"}`)
+	for _, algo := range []DigestAlgorithm{DigestBlake3_256, DigestBlake3_512, DigestBlake2b_256, DigestBlake2b_512, DigestBlake2s_256, DigestSha3_256, DigestSha3_512} {
+		t.Run(string(algo), func(t *testing.T) {
+			if !IsSupportedDigestAlgorithm(strings.ToLower(string(algo))) {
+				t.Fatalf("%s is not supported", algo)
+			}
+			r, _ := http.NewRequest("POST", "example.com", nil)
+			if err := AddDigest(r, algo, body, false); err != nil {
+				t.Fatal(err)
+			}
+			got, err := VerifyRequestDigest(r, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != algo {
+				t.Fatalf("expected %s, got %s", algo, got)
+			}
+		})
+	}
+
+	// the content-digest of a body matches the Blake3 SAID raw bytes
+	v, err := Value(nil, WithAlgorithms(DigestBlake3_256), WithEncoding(EncodingSignify))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "blake3-256=:rxNJufX5oaagQE3qNtzJSZvLJcmtwRK3zJqTyuQfMmI:" {
+		t.Fatalf("unexpected value %s", v)
+	}
+}
@@ -0,0 +1,107 @@
+// Package hashes is the registry of digest algorithms shared by the digest
+// package, which names them in content-digest fields, and the cesr package,
+// which identifies them by their CESR digest codes.
+package hashes
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+	"lukechampine.com/blake3"
+)
+
+// Algorithm names, as used in content-digest fields.
+const (
+	SHA256     = "sha-256"
+	SHA512     = "sha-512"
+	SHA3_256   = "sha3-256"
+	SHA3_512   = "sha3-512"
+	Blake3_256 = "blake3-256"
+	Blake3_512 = "blake3-512"
+	Blake2b256 = "blake2b-256"
+	Blake2b512 = "blake2b-512"
+	Blake2s256 = "blake2s-256"
+)
+
+// Algorithm describes a digest algorithm.
+type Algorithm struct {
+	// Name is the lower-case name used in content-digest fields.
+	Name string
+	// Code is the CESR Matter code of the digest, or "" if it has none.
+	Code string
+	// Size is the digest size in bytes.
+	Size int
+	New  func() hash.Hash
+}
+
+// Sum returns the digest of data.
+func (a Algorithm) Sum(data []byte) []byte {
+	h := a.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+var (
+	mu     sync.RWMutex
+	byName = map[string]Algorithm{}
+	byCode = map[string]Algorithm{}
+	names  []string
+)
+
+// The codes are those of the cesr package, which imports this one.
+func init() {
+	Register(Algorithm{Name: SHA256, Code: "I", Size: 32, New: sha256.New})
+	Register(Algorithm{Name: SHA512, Code: "0G", Size: 64, New: sha512.New})
+	Register(Algorithm{Name: SHA3_256, Code: "H", Size: 32, New: sha3.New256})
+	Register(Algorithm{Name: SHA3_512, Code: "0F", Size: 64, New: sha3.New512})
+	Register(Algorithm{Name: Blake3_256, Code: "E", Size: 32, New: func() hash.Hash { return blake3.New(32, nil) }})
+	Register(Algorithm{Name: Blake3_512, Code: "0D", Size: 64, New: func() hash.Hash { return blake3.New(64, nil) }})
+	Register(Algorithm{Name: Blake2b256, Code: "F", Size: 32, New: func() hash.Hash { h, _ := blake2b.New256(nil); return h }})
+	Register(Algorithm{Name: Blake2b512, Code: "0E", Size: 64, New: func() hash.Hash { h, _ := blake2b.New512(nil); return h }})
+	Register(Algorithm{Name: Blake2s256, Code: "G", Size: 32, New: func() hash.Hash { h, _ := blake2s.New256(nil); return h }})
+}
+
+// Register adds an algorithm, replacing any algorithm with the same name.
+func Register(a Algorithm) {
+	mu.Lock()
+	defer mu.Unlock()
+	a.Name = strings.ToLower(a.Name)
+	if old, ok := byName[a.Name]; ok {
+		delete(byCode, old.Code)
+	} else {
+		names = append(names, a.Name)
+	}
+	byName[a.Name] = a
+	if a.Code != "" {
+		byCode[a.Code] = a
+	}
+}
+
+// ByName returns the algorithm with the given name, ignoring case.
+func ByName(name string) (Algorithm, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	a, ok := byName[strings.ToLower(name)]
+	return a, ok
+}
+
+// ByCode returns the algorithm with the given CESR code.
+func ByCode(code string) (Algorithm, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	a, ok := byCode[code]
+	return a, ok
+}
+
+// Names returns the names of all registered algorithms in registration order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string(nil), names...)
+}
@@ -0,0 +1,71 @@
+package hashes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltins(t *testing.T) {
+	testCases := []struct {
+		name string
+		code string
+		sum  string
+	}{
+		{SHA256, "I", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{SHA3_256, "H", "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{Blake3_256, "E", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{Blake2b256, "F", "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{Blake2s256, "G", "69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok := ByName(tc.name)
+			require.True(t, ok)
+			assert.Equal(t, tc.code, a.Code)
+			assert.Equal(t, tc.sum, hex.EncodeToString(a.Sum(nil)))
+			assert.Len(t, a.Sum(nil), a.Size)
+
+			b, ok := ByCode(tc.code)
+			require.True(t, ok)
+			assert.Equal(t, a.Name, b.Name)
+		})
+	}
+
+	for _, name := range Names() {
+		a, _ := ByName(name)
+		assert.Len(t, a.Sum([]byte("abc")), a.Size, name)
+	}
+	_, ok := ByName("SHA3-512")
+	assert.True(t, ok, "names are case-insensitive")
+	_, ok = ByName("md5")
+	assert.False(t, ok)
+}
+
+func TestRegister(t *testing.T) {
+	Register(Algorithm{Name: "Test-256", Code: "test", Size: 32, New: sha256.New})
+	a, ok := ByName("test-256")
+	require.True(t, ok)
+	assert.Equal(t, "test-256", a.Name)
+	_, ok = ByCode("test")
+	assert.True(t, ok)
+
+	// replacing an algorithm drops its old code
+	Register(Algorithm{Name: "test-256", Size: 32, New: sha256.New})
+	_, ok = ByCode("test")
+	assert.False(t, ok)
+	assert.Equal(t, 1, countOf(Names(), "test-256"))
+}
+
+func countOf(names []string, name string) int {
+	n := 0
+	for _, s := range names {
+		if s == name {
+			n++
+		}
+	}
+	return n
+}
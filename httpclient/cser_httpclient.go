@@ -4,52 +4,83 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Wavecrest/httpsigcesr/digest"
 	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"io"
 	"net/http"
 )
 
 var (
 	signatureFields = []string{"@method", "@path", "origin-date", "signify-resource", "content-digest"}
+
+	// ResponseFields must be covered by a response signature checked with
+	// WithServerAID. The req components bind the response to the signed
+	// request it answers, so a response cannot be replayed for another one.
+	ResponseFields = []string{"@status", "content-digest", "@method;req", "@path;req", "signature;req"}
+
+	ErrResponseVerification = errors.New("response verification failed")
 )
 
 type CserSignedClient struct {
-	privateKey ed25519.PrivateKey
-	publicKey  string
+	client      *http.Client
+	contentType string
+	marshal     func(v interface{}) ([]byte, error)
+}
+
+// NewCserSignedClient returns a client whose requests are signed by a
+// Transport configured with opts. The http.Client is reused across calls.
+func NewCserSignedClient(publicKey string, privateKey ed25519.PrivateKey, opts ...Option) HttpClient {
+	return newCserSignedClient(NewTransport(publicKey, privateKey, opts...))
 }
 
-func NewCserSignedClient(publicKey string, privateKey ed25519.PrivateKey) HttpClient {
+// NewCserSignedClientWithSigner is NewCserSignedClient for a key held by a
+// Signer.
+func NewCserSignedClientWithSigner(s signer.Signer, opts ...Option) HttpClient {
+	return newCserSignedClient(NewTransportWithSigner(s, opts...))
+}
+
+func newCserSignedClient(t *Transport) *CserSignedClient {
 	return &CserSignedClient{
-		privateKey: privateKey,
-		publicKey:  publicKey,
+		client:      t.Client(),
+		contentType: t.cfg.contentType,
+		marshal:     t.cfg.marshal,
 	}
 }
 
 func (csc *CserSignedClient) SendSignedRequest(c context.Context, method string, url string, body interface{}) (*http.Response, error) {
-	bodyBytes, err := json.Marshal(body)
+	bodyBytes, err := csc.marshal(body)
 	if err != nil {
 		return nil, err
 	}
-	bodyReader := bytes.NewReader(bodyBytes)
-	req, err := http.NewRequestWithContext(c, method, url, bodyReader)
+	req, err := http.NewRequestWithContext(c, method, url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, err
 	}
-	// // digest is url-safe Base64-encoded without padding 
-	err = digest.AddDigest(req, digest.DigestSha256, bodyBytes, false)
+	req.Header.Set("Content-Type", csc.contentType)
+	return csc.client.Do(req)
+}
+
+// verifyResponse checks the signature and content-digest of resp, replacing
+// its body with a buffered copy.
+func verifyResponse(resp *http.Response, serverAID string) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		return nil, err
-	}
-	if len(bodyBytes) >= 0 {
-		req.Header.Add("Content-Type", "application/json")
+		return err
 	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
 
-	req.Header.Add("signify-resource", csc.publicKey)
-
-	signatureData := signature.NewSignatureData(signatureFields, csc.publicKey, csc.privateKey)
-	err = signatureData.SignRequest(req)
-
-	client := &http.Client{}
-	return client.Do(req)
+	_, err = signature.VerifyResponse(resp,
+		signature.WithKeyID(serverAID),
+		signature.WithRequiredFields(ResponseFields...))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseVerification, err)
+	}
+	if err := digest.VerifyHeaderDigest(resp.Header, body); err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseVerification, err)
+	}
+	return nil
 }
@@ -0,0 +1,109 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return cesr.Encode(pub, "B"), priv
+}
+
+func TestSendSignedRequestVerifiesResponse(t *testing.T) {
+	serverAID, serverKey := newKey(t)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	signed := httptest.NewServer(middleware.Authenticate(middleware.SignResponses(echo, serverAID, serverKey)))
+	defer signed.Close()
+	unsigned := httptest.NewServer(middleware.Authenticate(echo))
+	defer unsigned.Close()
+
+	clientAID, clientKey := newKey(t)
+	otherAID, _ := newKey(t)
+
+	client := NewCserSignedClient(clientAID, clientKey, WithServerAID(serverAID))
+	resp, err := client.SendSignedRequest(context.Background(), "POST", signed.URL+"/echo", map[string]string{"hello": "world"})
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, `{"hello":"world"}`, string(body))
+
+	_, err = client.SendSignedRequest(context.Background(), "POST", unsigned.URL+"/echo", nil)
+	assert.ErrorIs(t, err, ErrResponseVerification)
+
+	client = NewCserSignedClient(clientAID, clientKey, WithServerAID(otherAID))
+	_, err = client.SendSignedRequest(context.Background(), "POST", signed.URL+"/echo", nil)
+	assert.ErrorIs(t, err, ErrResponseVerification)
+}
+
+// replayTransport records the first response and returns it again for every
+// later request.
+type replayTransport struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (rt *replayTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if rt.header == nil {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		rt.header, rt.status = resp.Header.Clone(), resp.StatusCode
+		if rt.body, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		StatusCode: rt.status,
+		Header:     rt.header.Clone(),
+		Body:       io.NopCloser(bytes.NewReader(rt.body)),
+		Request:    r,
+	}, nil
+}
+
+func TestResponseReplayedForOtherRequest(t *testing.T) {
+	serverAID, serverKey := newKey(t)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	signed := httptest.NewServer(middleware.Authenticate(middleware.SignResponses(echo, serverAID, serverKey)))
+	defer signed.Close()
+
+	clientAID, clientKey := newKey(t)
+	client := NewCserSignedClient(clientAID, clientKey, WithServerAID(serverAID), WithBaseTransport(&replayTransport{}))
+	resp, err := client.SendSignedRequest(context.Background(), "POST", signed.URL+"/accounts/1", map[string]int{"amount": 1})
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// the captured response of request A must not verify as the answer to B
+	_, err = client.SendSignedRequest(context.Background(), "POST", signed.URL+"/accounts/2", map[string]int{"amount": 1})
+	assert.ErrorIs(t, err, ErrResponseVerification)
+	_, err = client.SendSignedRequest(context.Background(), "POST", signed.URL+"/accounts/1", map[string]int{"amount": 1})
+	assert.ErrorIs(t, err, ErrResponseVerification, "a new signature of the same request")
+
+	// a response signature that does not cover the request could be replayed
+	unbound := httptest.NewServer(middleware.Authenticate(middleware.SignResponses(echo, serverAID, serverKey, "@status", "content-digest")))
+	defer unbound.Close()
+	client = NewCserSignedClient(clientAID, clientKey, WithServerAID(serverAID), WithBaseTransport(&replayTransport{}))
+	_, err = client.SendSignedRequest(context.Background(), "POST", unbound.URL+"/accounts/1", map[string]int{"amount": 1})
+	assert.ErrorIs(t, err, ErrResponseVerification)
+	_, err = client.SendSignedRequest(context.Background(), "POST", unbound.URL+"/accounts/2", map[string]int{"amount": 1})
+	assert.ErrorIs(t, err, ErrResponseVerification)
+}
@@ -0,0 +1,329 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+)
+
+const resourceHeader = "signify-resource"
+
+type config struct {
+	base            http.RoundTripper
+	timeout         time.Duration
+	fields          []string
+	digestAlgorithm digest.DigestAlgorithm
+	digestEncoding  digest.Encoding
+	headers         http.Header
+	resource        *string
+	format          signature.Format
+	serverAID       string
+	contentType     string
+	marshal         func(v interface{}) ([]byte, error)
+	expiry          time.Duration
+	nonce           bool
+	tag             string
+	label           string
+	streaming       bool
+	aid             string
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		base:            http.DefaultTransport,
+		fields:          signatureFields,
+		digestAlgorithm: digest.DigestSha256,
+		digestEncoding:  digest.EncodingSignify,
+		headers:         http.Header{},
+		contentType:     "application/json",
+		marshal:         json.Marshal,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Option configures a Transport or CserSignedClient.
+type Option func(*config)
+
+// WithServerAID makes the client reject responses that are not signed by
+// serverAID or whose content-digest does not match the body.
+func WithServerAID(serverAID string) Option {
+	return func(c *config) {
+		c.serverAID = serverAID
+	}
+}
+
+// WithBaseTransport sets the RoundTripper that sends the signed requests.
+// The default is http.DefaultTransport.
+func WithBaseTransport(base http.RoundTripper) Option {
+	return func(c *config) {
+		c.base = base
+	}
+}
+
+// WithTimeout sets the http.Client timeout of a CserSignedClient or of a
+// client created with NewClient.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithComponents replaces the components covered by the signature.
+func WithComponents(fields ...string) Option {
+	return func(c *config) {
+		c.fields = fields
+	}
+}
+
+// WithDigestAlgorithm sets the algorithm used for the content-digest header.
+func WithDigestAlgorithm(algo digest.DigestAlgorithm) Option {
+	return func(c *config) {
+		c.digestAlgorithm = algo
+	}
+}
+
+// WithDigestEncoding sets the encoding of the content-digest header. The
+// default is digest.EncodingSignify, which KERIA expects; servers that follow
+// RFC 9530 strictly need digest.EncodingRFC9530.
+func WithDigestEncoding(e digest.Encoding) Option {
+	return func(c *config) {
+		c.digestEncoding = e
+	}
+}
+
+// WithHeader adds a header to every request before it is signed.
+func WithHeader(key string, value string) Option {
+	return func(c *config) {
+		c.headers.Add(key, value)
+	}
+}
+
+// WithAID signs as aid instead of the CESR-encoded public key: aid becomes the
+// keyid and the default signify-resource. Use it for a self-addressing "E" AID
+// whose current signing key is the client's key.
+func WithAID(aid string) Option {
+	return func(c *config) {
+		c.aid = aid
+	}
+}
+
+// WithResource sets the signify-resource header, which defaults to the public
+// key. An empty aid omits the header, in which case it must not be covered.
+func WithResource(aid string) Option {
+	return func(c *config) {
+		c.resource = &aid
+	}
+}
+
+// WithFormat sets the signature header format. The default is
+// signature.FormatSignify, which KERIA expects; signature.FormatRFC9421 writes
+// an RFC 9421 byte sequence.
+func WithFormat(format signature.Format) Option {
+	return func(c *config) {
+		c.format = format
+	}
+}
+
+// WithMarshaler replaces the JSON encoding used by SendSignedRequest.
+func WithMarshaler(contentType string, marshal func(v interface{}) ([]byte, error)) Option {
+	return func(c *config) {
+		c.contentType = contentType
+		c.marshal = marshal
+	}
+}
+
+// WithExpiry adds an expires parameter d after the signing time.
+func WithExpiry(d time.Duration) Option {
+	return func(c *config) {
+		c.expiry = d
+	}
+}
+
+// WithNonce adds a fresh random nonce to every signature, so that a server
+// with a replay cache accepts each request only once.
+func WithNonce() Option {
+	return func(c *config) {
+		c.nonce = true
+	}
+}
+
+// WithTag adds a tag parameter to every signature.
+func WithTag(tag string) Option {
+	return func(c *config) {
+		c.tag = tag
+	}
+}
+
+// WithLabel sets the signature label, which defaults to "signify". A gateway
+// forwarding signed requests uses a different label to keep the caller's
+// signature, together with WithResource("") so that signify-resource is left
+// alone.
+func WithLabel(label string) Option {
+	return func(c *config) {
+		c.label = label
+	}
+}
+
+// WithStreamingBody sends request bodies without buffering them. The
+// content-digest is computed in a first pass over a body obtained from
+// req.GetBody, which http.NewRequest sets for in-memory bodies and which must
+// be set by the caller for files or other large bodies, unless the request
+// already has a content-digest, for example from digest.AddDigestFromReader.
+func WithStreamingBody() Option {
+	return func(c *config) {
+		c.streaming = true
+	}
+}
+
+// Transport is an http.RoundTripper that adds a content-digest and a signify
+// signature to every request before passing it to a base RoundTripper.
+type Transport struct {
+	publicKey string
+	signer    signer.Signer
+	cfg       *config
+}
+
+func NewTransport(publicKey string, privateKey ed25519.PrivateKey, opts ...Option) *Transport {
+	return newTransport(publicKey, signer.NewInMemorySigner(privateKey, ""), opts)
+}
+
+// NewTransportWithSigner is NewTransport for a key held by a Signer, such as
+// an HSM or a remote signing service.
+func NewTransportWithSigner(s signer.Signer, opts ...Option) *Transport {
+	return newTransport(signer.AID(s), s, opts)
+}
+
+func newTransport(publicKey string, s signer.Signer, opts []Option) *Transport {
+	cfg := newConfig(opts)
+	if cfg.aid != "" {
+		publicKey = cfg.aid
+	}
+	return &Transport{publicKey: publicKey, signer: s, cfg: cfg}
+}
+
+// NewClient returns an http.Client that signs its requests with a Transport.
+func NewClient(publicKey string, privateKey ed25519.PrivateKey, opts ...Option) *http.Client {
+	return NewTransport(publicKey, privateKey, opts...).Client()
+}
+
+// NewClientWithSigner returns an http.Client that signs its requests with s.
+func NewClientWithSigner(s signer.Signer, opts ...Option) *http.Client {
+	return NewTransportWithSigner(s, opts...).Client()
+}
+
+// Client returns an http.Client using t and the configured timeout.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t, Timeout: t.cfg.timeout}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+
+	var body []byte
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	_, digestTrailer := req.Trailer[http.CanonicalHeaderKey("content-digest")]
+	if t.cfg.streaming {
+		// a body from digest.AddDigestTrailer fills in the original trailer
+		signed.Trailer = req.Trailer
+	}
+	if hasBody && !t.cfg.streaming {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		signed.ContentLength = int64(len(body))
+	}
+
+	for key, values := range t.cfg.headers {
+		signed.Header[key] = append([]string(nil), values...)
+	}
+	resource := t.publicKey
+	if t.cfg.resource != nil {
+		resource = *t.cfg.resource
+	}
+	if resource != "" {
+		signed.Header.Set(resourceHeader, resource)
+	}
+	if signed.Header.Get("content-digest") == "" && !digestTrailer {
+		if hasBody && t.cfg.streaming {
+			if err := t.addStreamingDigest(req, signed); err != nil {
+				return nil, err
+			}
+		} else if err := digest.SetContentDigest(signed.Header, body,
+			digest.WithAlgorithms(t.cfg.digestAlgorithm), digest.WithEncoding(t.cfg.digestEncoding)); err != nil {
+			return nil, err
+		}
+	}
+
+	signOptions := []signature.Option{
+		signature.WithAID(t.publicKey),
+		signature.WithFormat(t.cfg.format),
+		signature.WithExpiry(t.cfg.expiry),
+		signature.WithTag(t.cfg.tag),
+	}
+	if t.cfg.label != "" {
+		signOptions = append(signOptions, signature.WithLabel(t.cfg.label))
+	}
+	if t.cfg.nonce {
+		nonce, err := signature.NewNonce()
+		if err != nil {
+			return nil, err
+		}
+		signOptions = append(signOptions, signature.WithNonce(nonce))
+	}
+	sd := signature.NewSignatureDataWithSigner(t.cfg.fields, t.signer, signOptions...)
+	if err := sd.SignRequest(signed); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.cfg.base.RoundTrip(signed)
+	if err != nil || t.cfg.serverAID == "" {
+		return resp, err
+	}
+	// the req components are checked against the request that was sent,
+	// whatever the base transport reports
+	resp.Request = signed
+	if err := verifyResponse(resp, t.cfg.serverAID); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// addStreamingDigest hashes a copy of the body from req.GetBody without
+// buffering it.
+func (t *Transport) addStreamingDigest(req *http.Request, signed *http.Request) error {
+	if req.GetBody == nil {
+		return errors.New("httpclient: streaming a body without content-digest requires req.GetBody")
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	hr, err := digest.NewHashingReader(rc, t.cfg.digestAlgorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		return err
+	}
+	signed.Header.Set("content-digest", hr.Encode(t.cfg.digestEncoding))
+	return nil
+}
@@ -0,0 +1,226 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/keys"
+	"github.com/Wavecrest/httpsigcesr/middleware"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingTransport struct {
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.calls++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestTransport(t *testing.T) {
+	var received *http.Request
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}), middleware.WithRequiredFields("@method", "@path", "x-tenant")))
+	defer server.Close()
+
+	aid, key := newKey(t)
+	base := &countingTransport{}
+	client := NewClient(aid, key,
+		WithBaseTransport(base),
+		WithComponents("@method", "@path", "@query", "x-tenant", "signify-resource", "content-digest"),
+		WithDigestAlgorithm(digest.DigestSha512),
+		WithHeader("X-Tenant", "acme"),
+		WithFormat(signature.FormatSignify))
+
+	req, err := http.NewRequest("PUT", server.URL+"/things/1?dry-run=true", strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, base.calls)
+	assert.Equal(t, "acme", received.Header.Get("X-Tenant"))
+	assert.True(t, strings.HasPrefix(received.Header.Get("content-digest"), "sha-512="))
+	assert.True(t, strings.HasPrefix(received.Header.Get("signature"), `indexed="?0"`))
+	assert.Empty(t, req.Header.Get("signature"), "the caller's request must not be modified")
+
+	resp, err = client.Get(server.URL + "/things")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportSignError(t *testing.T) {
+	aid, key := newKey(t)
+	client := NewClient(aid, key, WithResource(""))
+
+	// signify-resource is covered by default but omitted by WithResource("").
+	_, err := client.Get("http://example.invalid/")
+	assert.ErrorContains(t, err, "missing header signify-resource")
+}
+
+type countingSigner struct {
+	signer.Signer
+	calls int
+}
+
+func (c *countingSigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	c.calls++
+	return c.Signer.Sign(ctx, msg)
+}
+
+func TestClientWithSigner(t *testing.T) {
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aid, _ := middleware.ResourceFromContext(r.Context())
+		w.Write([]byte(aid))
+	})))
+	defer server.Close()
+
+	_, key := newKey(t)
+	s := &countingSigner{Signer: signer.NewInMemorySigner(key, "")}
+	client := NewCserSignedClientWithSigner(s)
+	resp, err := client.SendSignedRequest(context.Background(), "POST", server.URL, nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, signer.AID(s), string(body))
+	assert.Equal(t, 1, s.calls)
+}
+
+func TestTransportReplayProtection(t *testing.T) {
+	var inputs []string
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inputs = append(inputs, r.Header.Get("signature-input"))
+	}), middleware.WithVerifyOptions(signature.WithReplayCache(signature.NewMemoryReplayCache()))))
+	defer server.Close()
+
+	aid, key := newKey(t)
+	client := NewClient(aid, key, WithNonce(), WithExpiry(time.Minute), WithTag("payments"))
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL+"/payments", "application/json", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	require.Len(t, inputs, 2)
+	assert.Regexp(t, `;expires=\d+;nonce="0A[^"]+";.*;tag="payments"$`, inputs[0])
+	assert.NotEqual(t, inputs[0], inputs[1])
+}
+
+func TestTransportGatewayLabel(t *testing.T) {
+	var labels []string
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, err := signature.VerifyRequestAll(r)
+		require.NoError(t, err)
+		for _, result := range results {
+			labels = append(labels, result.Label)
+		}
+	})))
+	defer server.Close()
+
+	gatewayAID, gatewayKey := newKey(t)
+	gateway := NewTransport(gatewayAID, gatewayKey, WithLabel("proxy"), WithResource(""),
+		WithComponents("@method", "@path", "content-digest"))
+
+	aid, key := newKey(t)
+	client := NewClient(aid, key, WithBaseTransport(gateway))
+	resp, err := client.Post(server.URL+"/things", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"signify", "proxy"}, labels)
+}
+
+func TestTransportDefaultFormat(t *testing.T) {
+	var received string
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("signature")
+	})))
+	defer server.Close()
+	aid, key := newKey(t)
+
+	// KERIA and signify-ts only verify the signify format
+	signify := `^indexed="\?0";signify="0B[A-Za-z0-9_-]{86}"$`
+	resp, err := NewClient(aid, key).Get(server.URL + "/things")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Regexp(t, signify, received)
+
+	resp, err = NewCserSignedClient(aid, key).SendSignedRequest(context.Background(), "POST", server.URL+"/things", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Regexp(t, signify, received)
+
+	resp, err = NewClient(aid, key, WithFormat(signature.FormatRFC9421)).Get(server.URL + "/things")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Regexp(t, `^signify=:[A-Za-z0-9+/]+=*:$`, received)
+}
+
+func TestTransportDigestEncoding(t *testing.T) {
+	var received string
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("content-digest")
+	})))
+	defer server.Close()
+
+	aid, key := newKey(t)
+	client := NewClient(aid, key, WithDigestEncoding(digest.EncodingRFC9530))
+	resp, err := client.Post(server.URL+"/things", "application/json", strings.NewReader(`{"a":"?>"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	expected, _ := digest.Value([]byte(`{"a":"?>"}`))
+	assert.Equal(t, expected, received)
+}
+
+func TestTransportSelfAddressingAID(t *testing.T) {
+	key, err := keys.Generate()
+	require.NoError(t, err)
+	aid := cesr.Encode(make([]byte, 32), cesr.Blake3_256)
+	resolver := signature.KeyStateResolverFunc(func(_ context.Context, id string) (*signature.KeyState, error) {
+		if id != aid {
+			return nil, signature.ErrUnknownAID
+		}
+		return &signature.KeyState{AID: aid, Keys: []string{keys.AID(key, true)}, Threshold: 1}, nil
+	})
+
+	var resource string
+	server := httptest.NewServer(middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resource, _ = middleware.ResourceFromContext(r.Context())
+	}), middleware.WithVerifyOptions(signature.WithKeyStateResolver(resolver))))
+	defer server.Close()
+
+	client := NewClientWithSigner(signer.NewInMemorySigner(key, cesr.Ed25519), WithAID(aid))
+	resp, err := client.Post(server.URL+"/things", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, aid, resource)
+
+	other, err := keys.Generate()
+	require.NoError(t, err)
+	client = NewClient(aid, other)
+	resp, err = client.Post(server.URL+"/things", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
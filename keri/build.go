@@ -0,0 +1,220 @@
+package keri
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+)
+
+// Option configures an event built by Incept, Rotate or Interact.
+type Option func(*eventConfig)
+
+type eventConfig struct {
+	keyThreshold     int
+	nextThreshold    int
+	witnessThreshold int
+	witnessSet       bool
+	witnesses        []string
+	cuts             []string
+	adds             []string
+	traits           []string
+	anchors          []json.RawMessage
+	basicPrefix      bool
+}
+
+// WithKeyThreshold sets how many of the current keys must sign. The default
+// is a majority.
+func WithKeyThreshold(n int) Option {
+	return func(c *eventConfig) {
+		c.keyThreshold = n
+	}
+}
+
+// WithNextThreshold sets how many of the next keys must sign the rotation
+// that exposes them. The default is a majority.
+func WithNextThreshold(n int) Option {
+	return func(c *eventConfig) {
+		c.nextThreshold = n
+	}
+}
+
+// WithWitnesses sets the witnesses of an inception event and how many of
+// them must receipt each event.
+func WithWitnesses(threshold int, witnesses ...string) Option {
+	return func(c *eventConfig) {
+		c.witnessThreshold = threshold
+		c.witnessSet = true
+		c.witnesses = witnesses
+	}
+}
+
+// WithWitnessChanges removes cuts from and adds adds to the witness list in a
+// rotation and sets the new witness threshold.
+func WithWitnessChanges(threshold int, cuts, adds []string) Option {
+	return func(c *eventConfig) {
+		c.witnessThreshold = threshold
+		c.witnessSet = true
+		c.cuts = cuts
+		c.adds = adds
+	}
+}
+
+// WithConfig sets the configuration traits of an inception event, such as
+// "EO" (establishment only).
+func WithConfig(traits ...string) Option {
+	return func(c *eventConfig) {
+		c.traits = traits
+	}
+}
+
+// WithAnchors adds seals, such as the digest of an ACDC, to the event.
+func WithAnchors(seals ...json.RawMessage) Option {
+	return func(c *eventConfig) {
+		c.anchors = seals
+	}
+}
+
+// WithBasicPrefix makes an inception event use its single key as the prefix,
+// e.g. a "D" AID, instead of the self-addressing SAID.
+func WithBasicPrefix() Option {
+	return func(c *eventConfig) {
+		c.basicPrefix = true
+	}
+}
+
+func newEventConfig(keys, next []string, opts []Option) *eventConfig {
+	c := &eventConfig{
+		keyThreshold:  majority(len(keys)),
+		nextThreshold: majority(len(next)),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func majority(n int) int {
+	return (n + 1) / 2
+}
+
+// NextDigests returns the Blake3 digests of the qb64 next keys, which commit
+// an inception or rotation to the keys of the following rotation.
+func NextDigests(keys ...string) ([]string, error) {
+	digests := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, err := decodeKey(key); err != nil {
+			return nil, err
+		}
+		d, err := cesr.NewDiger(saidCode, []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d.Qb64())
+	}
+	return digests, nil
+}
+
+// Incept builds the inception event of a new AID with the qb64 Ed25519 keys
+// and the digests of the next keys. The AID is self-addressing unless
+// WithBasicPrefix is given; an AID without next digests cannot rotate.
+func Incept(keys, nextDigests []string, opts ...Option) (*Event, error) {
+	c := newEventConfig(keys, nextDigests, opts)
+	e := &Event{
+		Type:             Icp,
+		KeyThreshold:     c.keyThreshold,
+		Keys:             keys,
+		NextThreshold:    c.nextThreshold,
+		NextDigests:      nextDigests,
+		WitnessThreshold: c.witnessThreshold,
+		Witnesses:        c.witnesses,
+		Config:           c.traits,
+		Anchors:          c.anchors,
+	}
+	if c.basicPrefix {
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("%w: a basic prefix needs exactly one key, got %d", ErrMalformedEvent, len(keys))
+		}
+		e.Prefix = keys[0]
+	}
+	if _, err := establish(nil, e); err != nil {
+		return nil, err
+	}
+	if err := e.saidify(); err != nil {
+		return nil, err
+	}
+	if err := checkPrefix(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Rotate builds the rotation event that follows state, replacing the keys with
+// keys, which must match the next digests of state, and committing to the
+// nextDigests of the following rotation. The witness threshold is kept unless
+// WithWitnessChanges is given.
+func Rotate(state *State, keys, nextDigests []string, opts ...Option) (*Event, error) {
+	c := newEventConfig(keys, nextDigests, opts)
+	if !c.witnessSet {
+		c.witnessThreshold = state.WitnessThreshold
+	}
+	e := &Event{
+		Type:             Rot,
+		Prefix:           state.Prefix,
+		Sequence:         state.Sequence + 1,
+		Prior:            state.SAID,
+		KeyThreshold:     c.keyThreshold,
+		Keys:             keys,
+		NextThreshold:    c.nextThreshold,
+		NextDigests:      nextDigests,
+		WitnessThreshold: c.witnessThreshold,
+		WitnessCuts:      c.cuts,
+		WitnessAdds:      c.adds,
+		Anchors:          c.anchors,
+	}
+	if _, err := establish(state, e); err != nil {
+		return nil, err
+	}
+	if n := exposedKeys(state, keys); n < state.NextThreshold {
+		return nil, fmt.Errorf("%w: %d of %d keys match the prior next digests", ErrThreshold, n, state.NextThreshold)
+	}
+	if err := e.saidify(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Interact builds the interaction event that follows state, which anchors the
+// seals given with WithAnchors without changing the keys.
+func Interact(state *State, opts ...Option) (*Event, error) {
+	c := newEventConfig(nil, nil, opts)
+	e := &Event{
+		Type:     Ixn,
+		Prefix:   state.Prefix,
+		Sequence: state.Sequence + 1,
+		Prior:    state.SAID,
+		Anchors:  c.anchors,
+	}
+	if _, err := establish(state, e); err != nil {
+		return nil, err
+	}
+	if err := e.saidify(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// exposedKeys counts the keys that match the next digest of state at their
+// position, which is how Sign indexes the signatures of a rotation.
+func exposedKeys(state *State, keys []string) int {
+	n := 0
+	for i, key := range keys {
+		if i >= len(state.NextDigests) {
+			break
+		}
+		if d, err := cesr.DecodeDiger(state.NextDigests[i]); err == nil && d.Verify([]byte(key)) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,364 @@
+// Package keri builds, signs and validates KERI key events, so that the
+// controller of a transferable AID can rotate its signing keys with
+// cryptographic continuity.
+package keri
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+)
+
+var (
+	ErrMalformedEvent = errors.New("malformed key event")
+	ErrInvalidSAID    = errors.New("SAID does not match the key event")
+)
+
+// Event types.
+const (
+	Icp = "icp" // inception
+	Rot = "rot" // rotation
+	Ixn = "ixn" // interaction
+	Rct = "rct" // witness receipt
+)
+
+// EstablishmentOnly is the configuration trait of an AID that has no
+// interaction events.
+const EstablishmentOnly = "EO"
+
+// Version strings of KERI 1.0 JSON events hold the size of the serialized
+// event in six hex digits.
+const (
+	versionFormat = "KERI10JSON%06x_"
+	versionPrefix = `{"v":"`
+)
+
+var versionPattern = regexp.MustCompile(`^KERI10JSON([0-9a-f]{6})_$`)
+
+// saidCode is the digest code of the SAIDs and self-addressing prefixes
+// created by this package. Events using other digest codes still validate.
+const saidCode = cesr.Blake3_256
+
+// Event is a KERI key event. Numbers are serialized as hex strings, so that
+// the JSON form matches keripy and KERIA byte for byte.
+type Event struct {
+	Type string
+	// SAID is the self-addressing identifier of the event, the digest of its
+	// serialization with SAID filled with a placeholder. A receipt holds the
+	// SAID of the event it receipts.
+	SAID string
+	// Prefix is the AID the event belongs to.
+	Prefix   string
+	Sequence uint64
+	// Prior is the SAID of the previous event, empty for inception.
+	Prior         string
+	KeyThreshold  int
+	Keys          []string
+	NextThreshold int
+	// NextDigests are the digests of the pre-rotated next keys.
+	NextDigests      []string
+	WitnessThreshold int
+	// Witnesses is the witness list of an inception event.
+	Witnesses []string
+	// WitnessCuts and WitnessAdds change the witness list in a rotation.
+	WitnessCuts []string
+	WitnessAdds []string
+	// Config holds the configuration traits of an inception event.
+	Config  []string
+	Anchors []json.RawMessage
+	// Raw is the serialized event, which is what the controller signs.
+	Raw []byte
+}
+
+type field struct {
+	label string
+	value interface{}
+}
+
+func (e *Event) fields() ([]field, error) {
+	fs := []field{
+		{"v", fmt.Sprintf(versionFormat, len(e.Raw))},
+		{"t", e.Type},
+		{"d", e.SAID},
+		{"i", e.Prefix},
+		{"s", hexNumber(e.Sequence)},
+	}
+	switch e.Type {
+	case Rct:
+		// a receipt only names the event it receipts
+		return fs, nil
+	case Icp:
+	case Rot, Ixn:
+		fs = append(fs, field{"p", e.Prior})
+	default:
+		return nil, fmt.Errorf("%w: unsupported event type %q", ErrMalformedEvent, e.Type)
+	}
+	if e.Type != Ixn {
+		fs = append(fs,
+			field{"kt", hexNumber(uint64(e.KeyThreshold))},
+			field{"k", list(e.Keys)},
+			field{"nt", hexNumber(uint64(e.NextThreshold))},
+			field{"n", list(e.NextDigests)},
+			field{"bt", hexNumber(uint64(e.WitnessThreshold))},
+		)
+	}
+	switch e.Type {
+	case Icp:
+		fs = append(fs, field{"b", list(e.Witnesses)}, field{"c", list(e.Config)})
+	case Rot:
+		fs = append(fs, field{"br", list(e.WitnessCuts)}, field{"ba", list(e.WitnessAdds)})
+	}
+	anchors := e.Anchors
+	if anchors == nil {
+		anchors = []json.RawMessage{}
+	}
+	return append(fs, field{"a", anchors}), nil
+}
+
+// serialize encodes e in KERI field order with its version string. The size
+// in the version string does not depend on the size itself, so a first pass
+// measures it.
+func (e *Event) serialize() ([]byte, error) {
+	e.Raw = nil
+	raw, err := e.encode()
+	if err != nil {
+		return nil, err
+	}
+	e.Raw = raw
+	if raw, err = e.encode(); err != nil {
+		return nil, err
+	}
+	e.Raw = raw
+	return raw, nil
+}
+
+func (e *Event) encode() ([]byte, error) {
+	fs, err := e.fields()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, f := range fs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(f.label); err != nil {
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(f.value); err != nil {
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// saidify computes the SAID of e and serializes it. An inception event whose
+// prefix is empty gets the SAID as its self-addressing prefix.
+func (e *Event) saidify() error {
+	selfAddressing := e.Type == Icp && e.Prefix == ""
+	placeholder := strings.Repeat("#", cesr.Sizes[saidCode].Fs)
+	e.SAID = placeholder
+	if selfAddressing {
+		e.Prefix = placeholder
+	}
+	raw, err := e.serialize()
+	if err != nil {
+		return err
+	}
+	d, err := cesr.NewDiger(saidCode, raw)
+	if err != nil {
+		return err
+	}
+	e.SAID = d.Qb64()
+	if selfAddressing {
+		e.Prefix = e.SAID
+	}
+	_, err = e.serialize()
+	return err
+}
+
+// VerifySAID checks that SAID is the digest of the event and, for a
+// self-addressing inception, that the prefix is the SAID.
+func (e *Event) VerifySAID() error {
+	d, err := cesr.DecodeDiger(e.SAID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSAID, err)
+	}
+	dummy := *e
+	placeholder := strings.Repeat("#", len(e.SAID))
+	dummy.SAID = placeholder
+	if e.Type == Icp && e.Prefix == e.SAID {
+		dummy.Prefix = placeholder
+	}
+	raw, err := dummy.serialize()
+	if err != nil {
+		return err
+	}
+	if !d.Verify(raw) {
+		return ErrInvalidSAID
+	}
+	return nil
+}
+
+// wireEvent is the JSON form of any supported event type.
+type wireEvent struct {
+	Version          string            `json:"v"`
+	Type             string            `json:"t"`
+	SAID             string            `json:"d"`
+	Prefix           string            `json:"i"`
+	Sequence         string            `json:"s"`
+	Prior            string            `json:"p"`
+	KeyThreshold     json.RawMessage   `json:"kt"`
+	Keys             []string          `json:"k"`
+	NextThreshold    json.RawMessage   `json:"nt"`
+	NextDigests      []string          `json:"n"`
+	WitnessThreshold string            `json:"bt"`
+	Witnesses        []string          `json:"b"`
+	WitnessCuts      []string          `json:"br"`
+	WitnessAdds      []string          `json:"ba"`
+	Config           []string          `json:"c"`
+	Anchors          []json.RawMessage `json:"a"`
+}
+
+// ParseEvent decodes a serialized event. The serialization must be canonical,
+// with the fields in KERI order and the size in the version string, because
+// the SAID and the signatures are computed over it. Weighted thresholds are
+// not supported.
+func ParseEvent(raw []byte) (*Event, error) {
+	size, err := eventSize(raw)
+	if err != nil {
+		return nil, err
+	}
+	if size != len(raw) {
+		return nil, fmt.Errorf("%w: version string gives size %d, got %d bytes", ErrMalformedEvent, size, len(raw))
+	}
+	var w wireEvent
+	if err := json.Unmarshal(raw, &w); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	e := &Event{
+		Type:        w.Type,
+		SAID:        w.SAID,
+		Prefix:      w.Prefix,
+		Prior:       w.Prior,
+		Keys:        orNil(w.Keys),
+		NextDigests: orNil(w.NextDigests),
+		Witnesses:   orNil(w.Witnesses),
+		WitnessCuts: orNil(w.WitnessCuts),
+		WitnessAdds: orNil(w.WitnessAdds),
+		Config:      orNil(w.Config),
+	}
+	if len(w.Anchors) > 0 {
+		e.Anchors = w.Anchors
+	}
+	if e.Sequence, err = parseHex("s", w.Sequence); err != nil {
+		return nil, err
+	}
+	if e.Type == Icp || e.Type == Rot {
+		if e.KeyThreshold, err = parseThreshold("kt", w.KeyThreshold); err != nil {
+			return nil, err
+		}
+		if e.NextThreshold, err = parseThreshold("nt", w.NextThreshold); err != nil {
+			return nil, err
+		}
+		bt, err := parseHex("bt", w.WitnessThreshold)
+		if err != nil {
+			return nil, err
+		}
+		e.WitnessThreshold = int(bt)
+	}
+
+	canonical, err := e.serialize()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(canonical, raw) {
+		return nil, fmt.Errorf("%w: %s event is not in canonical form", ErrMalformedEvent, e.Type)
+	}
+	return e, nil
+}
+
+// parseHeader decodes the fields common to all KERI messages, for message
+// types that ParseEvent does not support.
+func parseHeader(raw []byte) (*Event, error) {
+	var w wireEvent
+	if err := json.Unmarshal(raw, &w); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	e := &Event{Type: w.Type, SAID: w.SAID, Prefix: w.Prefix, Raw: raw}
+	if w.Sequence != "" {
+		n, err := parseHex("s", w.Sequence)
+		if err != nil {
+			return nil, err
+		}
+		e.Sequence = n
+	}
+	return e, nil
+}
+
+// supported reports whether ParseEvent decodes events of type t.
+func supported(t string) bool {
+	return t == Icp || t == Rot || t == Ixn || t == Rct
+}
+
+// eventSize reads the size from the version string at the start of stream.
+func eventSize(stream []byte) (int, error) {
+	end := len(versionPrefix) + len(fmt.Sprintf(versionFormat, 0))
+	if len(stream) < end || string(stream[:len(versionPrefix)]) != versionPrefix {
+		return 0, fmt.Errorf("%w: no KERI JSON version string", ErrMalformedEvent)
+	}
+	match := versionPattern.FindStringSubmatch(string(stream[len(versionPrefix):end]))
+	if match == nil {
+		return 0, fmt.Errorf("%w: unsupported version string %q", ErrMalformedEvent, stream[len(versionPrefix):end])
+	}
+	size, _ := strconv.ParseInt(match[1], 16, 64)
+	return int(size), nil
+}
+
+func parseHex(label, s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || s != hexNumber(n) {
+		return 0, fmt.Errorf("%w: field %s is not a hex number: %q", ErrMalformedEvent, label, s)
+	}
+	return n, nil
+}
+
+func parseThreshold(label string, raw json.RawMessage) (int, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("%w: field %s is not a hex threshold, weighted thresholds are not supported", ErrMalformedEvent, label)
+	}
+	n, err := parseHex(label, s)
+	return int(n), err
+}
+
+func hexNumber(n uint64) string {
+	return strconv.FormatUint(n, 16)
+}
+
+func orNil(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+func list(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package keri
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInceptionSerialization(t *testing.T) {
+	_, keys := newKeys(t, 1)
+	_, next := newKeys(t, 1)
+	digests, err := NextDigests(next...)
+	require.NoError(t, err)
+
+	e, err := Incept(keys, digests, WithConfig("EO"))
+	require.NoError(t, err)
+	assert.Equal(t, e.SAID, e.Prefix, "self-addressing prefix")
+	assert.Equal(t, cesr.Blake3_256, e.SAID[:1])
+
+	expected := fmt.Sprintf(`{"v":"KERI10JSON%06x_","t":"icp","d":"%s","i":"%s","s":"0","kt":"1","k":["%s"],"nt":"1","n":["%s"],"bt":"0","b":[],"c":["EO"],"a":[]}`,
+		len(e.Raw), e.SAID, e.SAID, keys[0], digests[0])
+	assert.Equal(t, expected, string(e.Raw))
+	require.NoError(t, e.VerifySAID())
+
+	parsed, err := ParseEvent(e.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, e, parsed)
+}
+
+func TestRotationSerialization(t *testing.T) {
+	_, keys := newKeys(t, 1)
+	_, next := newKeys(t, 1)
+	digests, err := NextDigests(next...)
+	require.NoError(t, err)
+	icp, err := Incept(keys, digests)
+	require.NoError(t, err)
+	state, err := establish(nil, icp)
+	require.NoError(t, err)
+
+	seal := json.RawMessage(`{"i":"EBfdlu8R27Fbx-ehrqwImnK-8Cm79sqbAQ4MmvEAYqao","s":"0","d":"EBfdlu8R27Fbx-ehrqwImnK-8Cm79sqbAQ4MmvEAYqao"}`)
+	rot, err := Rotate(state, next, nil, WithAnchors(seal))
+	require.NoError(t, err)
+	expected := fmt.Sprintf(`{"v":"KERI10JSON%06x_","t":"rot","d":"%s","i":"%s","s":"1","p":"%s","kt":"1","k":["%s"],"nt":"0","n":[],"bt":"0","br":[],"ba":[],"a":[%s]}`,
+		len(rot.Raw), rot.SAID, icp.Prefix, icp.SAID, next[0], seal)
+	assert.Equal(t, expected, string(rot.Raw))
+	require.NoError(t, rot.VerifySAID())
+
+	parsed, err := ParseEvent(rot.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, rot, parsed)
+}
+
+func TestParseEventErrors(t *testing.T) {
+	_, keys := newKeys(t, 1)
+	e, err := Incept(keys, nil, WithBasicPrefix())
+	require.NoError(t, err)
+	raw := string(e.Raw)
+	head := fmt.Sprintf(`"t":"icp","d":"%s"`, e.SAID)
+
+	tests := map[string]string{
+		"no version":      `{"t":"icp"}`,
+		"wrong size":      raw[:len(raw)-1] + ` }`,
+		"field order":     resize(strings.Replace(raw, head, fmt.Sprintf(`"d":"%s","t":"icp"`, e.SAID), 1)),
+		"weighted":        resize(strings.Replace(raw, `"kt":"1"`, `"kt":["1"]`, 1)),
+		"padded sequence": resize(strings.Replace(raw, `"s":"0"`, `"s":"00"`, 1)),
+		"unknown type":    resize(strings.Replace(raw, `"t":"icp"`, `"t":"xyz"`, 1)),
+		"extra field":     resize(strings.Replace(raw, `"a":[]`, `"a":[],"x":1`, 1)),
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseEvent([]byte(input))
+			assert.ErrorIs(t, err, ErrMalformedEvent)
+		})
+	}
+
+	parsed, err := ParseEvent([]byte(resize(strings.Replace(raw, `"c":[]`, `"c":["EO"]`, 1))))
+	require.NoError(t, err)
+	assert.ErrorIs(t, parsed.VerifySAID(), ErrInvalidSAID)
+}
+
+// resize fixes the size in the version string of an edited event.
+func resize(raw string) string {
+	return raw[:16] + fmt.Sprintf("%06x", len(raw)) + raw[22:]
+}
@@ -0,0 +1,381 @@
+package keri
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+var (
+	ErrOutOfOrder       = errors.New("key event out of order")
+	ErrNotTransferable  = errors.New("identifier cannot rotate")
+	ErrInvalidSignature = errors.New("invalid key event signature")
+	ErrThreshold        = errors.New("signing threshold not met")
+)
+
+// State is the key state of an AID after the latest event of its KEL.
+type State struct {
+	Prefix   string
+	Sequence uint64
+	// SAID is the SAID of the latest event.
+	SAID             string
+	KeyThreshold     int
+	Keys             []string
+	NextThreshold    int
+	NextDigests      []string
+	WitnessThreshold int
+	Witnesses        []string
+	// EstablishmentOnly is set by the "EO" trait, which rules out
+	// interaction events.
+	EstablishmentOnly bool
+}
+
+// Transferable reports whether the AID can rotate its keys.
+func (s *State) Transferable() bool {
+	return len(s.NextDigests) > 0
+}
+
+// KeyState returns s in the form the signature verifier resolves. An AID that
+// rotated to no next keys has been abandoned and is reported as revoked.
+func (s *State) KeyState() *signature.KeyState {
+	return &signature.KeyState{
+		AID:       s.Prefix,
+		Keys:      append([]string(nil), s.Keys...),
+		Threshold: s.KeyThreshold,
+		Sequence:  s.Sequence,
+		Revoked:   s.Sequence > 0 && !s.Transferable(),
+	}
+}
+
+// establish checks e against the prior state, nil for an inception, and
+// returns the state it establishes. Signatures and the SAID are not checked.
+func establish(prior *State, e *Event) (*State, error) {
+	switch e.Type {
+	case Icp:
+		if prior != nil {
+			return nil, fmt.Errorf("%w: inception of the established AID %s", ErrOutOfOrder, prior.Prefix)
+		}
+		if e.Sequence != 0 {
+			return nil, fmt.Errorf("%w: inception with sequence number %d", ErrOutOfOrder, e.Sequence)
+		}
+	case Rot, Ixn:
+		if prior == nil {
+			return nil, fmt.Errorf("%w: %s event before inception", ErrOutOfOrder, e.Type)
+		}
+		if e.Prefix != prior.Prefix {
+			return nil, fmt.Errorf("%w: event of %s in the KEL of %s", ErrMalformedEvent, e.Prefix, prior.Prefix)
+		}
+		if e.Sequence != prior.Sequence+1 {
+			return nil, fmt.Errorf("%w: expected sequence number %d, got %d", ErrOutOfOrder, prior.Sequence+1, e.Sequence)
+		}
+		if e.Prior != prior.SAID {
+			return nil, fmt.Errorf("%w: prior event %s is not %s", ErrOutOfOrder, e.Prior, prior.SAID)
+		}
+		if e.Type == Ixn {
+			// a non-transferable or abandoned AID has no further events
+			if !prior.Transferable() {
+				return nil, fmt.Errorf("%w: interaction event of %s, which has no next keys", ErrNotTransferable, prior.Prefix)
+			}
+			if prior.EstablishmentOnly {
+				return nil, fmt.Errorf("%w: interaction event of the establishment only AID %s", ErrMalformedEvent, prior.Prefix)
+			}
+			state := *prior
+			state.Sequence, state.SAID = e.Sequence, e.SAID
+			return &state, nil
+		}
+		if !prior.Transferable() {
+			return nil, fmt.Errorf("%w: %s has no next keys", ErrNotTransferable, prior.Prefix)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported event type %q", ErrMalformedEvent, e.Type)
+	}
+
+	for _, key := range e.Keys {
+		if _, err := decodeKey(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range e.NextDigests {
+		if _, err := cesr.DecodeDiger(d); err != nil {
+			return nil, fmt.Errorf("%w: next digest %s: %s", ErrMalformedEvent, d, err)
+		}
+	}
+	if len(e.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no signing keys", ErrMalformedEvent)
+	}
+	if e.KeyThreshold < 1 || e.KeyThreshold > len(e.Keys) {
+		return nil, fmt.Errorf("%w: key threshold %d for %d keys", ErrMalformedEvent, e.KeyThreshold, len(e.Keys))
+	}
+	if e.NextThreshold > len(e.NextDigests) || len(e.NextDigests) > 0 && e.NextThreshold < 1 {
+		return nil, fmt.Errorf("%w: next threshold %d for %d next keys", ErrMalformedEvent, e.NextThreshold, len(e.NextDigests))
+	}
+
+	witnesses, err := witnessList(prior, e)
+	if err != nil {
+		return nil, err
+	}
+	if e.WitnessThreshold > len(witnesses) || len(witnesses) > 0 && e.WitnessThreshold < 1 {
+		return nil, fmt.Errorf("%w: witness threshold %d for %d witnesses", ErrMalformedEvent, e.WitnessThreshold, len(witnesses))
+	}
+
+	state := &State{
+		Prefix:           e.Prefix,
+		Sequence:         e.Sequence,
+		SAID:             e.SAID,
+		KeyThreshold:     e.KeyThreshold,
+		Keys:             e.Keys,
+		NextThreshold:    e.NextThreshold,
+		NextDigests:      e.NextDigests,
+		WitnessThreshold: e.WitnessThreshold,
+		Witnesses:        witnesses,
+	}
+	if prior != nil {
+		state.EstablishmentOnly = prior.EstablishmentOnly
+	}
+	for _, trait := range e.Config {
+		if trait == EstablishmentOnly {
+			state.EstablishmentOnly = true
+		}
+	}
+	return state, nil
+}
+
+// witnessList returns the witnesses after e: the list of an inception, or
+// the prior list without the cuts and with the adds of a rotation.
+func witnessList(prior *State, e *Event) ([]string, error) {
+	if e.Type == Icp {
+		if dup := duplicate(e.Witnesses); dup != "" {
+			return nil, fmt.Errorf("%w: duplicate witness %s", ErrMalformedEvent, dup)
+		}
+		return e.Witnesses, nil
+	}
+	if dup := duplicate(append(append([]string{}, e.WitnessCuts...), e.WitnessAdds...)); dup != "" {
+		return nil, fmt.Errorf("%w: witness %s cut or added twice", ErrMalformedEvent, dup)
+	}
+	cut := map[string]bool{}
+	for _, w := range e.WitnessCuts {
+		cut[w] = true
+	}
+	var witnesses []string
+	for _, w := range prior.Witnesses {
+		if cut[w] {
+			delete(cut, w)
+			continue
+		}
+		witnesses = append(witnesses, w)
+	}
+	if len(cut) > 0 {
+		return nil, fmt.Errorf("%w: cut of %d witnesses not in the list", ErrMalformedEvent, len(cut))
+	}
+	for _, w := range e.WitnessAdds {
+		for _, existing := range witnesses {
+			if w == existing {
+				return nil, fmt.Errorf("%w: added witness %s is already in the list", ErrMalformedEvent, w)
+			}
+		}
+		witnesses = append(witnesses, w)
+	}
+	return witnesses, nil
+}
+
+func duplicate(s []string) string {
+	seen := map[string]bool{}
+	for _, v := range s {
+		if seen[v] {
+			return v
+		}
+		seen[v] = true
+	}
+	return ""
+}
+
+// checkPrefix checks the prefix of an inception event: a self-addressing
+// prefix is the SAID, a basic prefix is the single key and a non-transferable
+// basic prefix has no next keys.
+func checkPrefix(e *Event) error {
+	m, err := cesr.DecodeMatter(e.Prefix)
+	if err != nil {
+		return fmt.Errorf("%w: prefix: %s", ErrMalformedEvent, err)
+	}
+	switch {
+	case cesr.DigestCodes[m.Code]:
+		if e.Prefix != e.SAID {
+			return fmt.Errorf("%w: self-addressing prefix %s is not the SAID", ErrMalformedEvent, e.Prefix)
+		}
+	case cesr.VerKeyCodes[m.Code]:
+		if len(e.Keys) != 1 || e.Keys[0] != e.Prefix {
+			return fmt.Errorf("%w: basic prefix %s is not the single key", ErrMalformedEvent, e.Prefix)
+		}
+		if cesr.NonTransferableCodes[m.Code] && len(e.NextDigests) > 0 {
+			return fmt.Errorf("%w: non-transferable prefix %s with next keys", ErrNotTransferable, e.Prefix)
+		}
+	default:
+		return fmt.Errorf("%w: prefix code %s", ErrMalformedEvent, m.Code)
+	}
+	return nil
+}
+
+func decodeKey(key string) (ed25519.PublicKey, error) {
+	m, err := cesr.DecodeMatter(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %s: %s", ErrMalformedEvent, key, err)
+	}
+	if m.Code != cesr.Ed25519 && m.Code != cesr.Ed25519N {
+		return nil, fmt.Errorf("%w: key %s is not an Ed25519 key", ErrMalformedEvent, key)
+	}
+	return ed25519.PublicKey(m.Raw), nil
+}
+
+// Apply validates m against the prior state, nil for an inception, and
+// returns the new state. The SAID, the prefix and the controller signatures
+// are checked: the signatures must meet the key threshold of the state the
+// event establishes and, for a rotation, the next threshold of the prior state
+// with keys whose digests are the prior next digests at their ondexes.
+func Apply(prior *State, m *Message) (*State, error) {
+	e := m.Event
+	if err := e.VerifySAID(); err != nil {
+		return nil, err
+	}
+	if e.Type == Icp {
+		if err := checkPrefix(e); err != nil {
+			return nil, err
+		}
+	}
+	state, err := establish(prior, e)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[int]bool{}
+	exposed := map[int]bool{}
+	for _, siger := range m.Sigers {
+		if siger.Index >= len(state.Keys) {
+			return nil, fmt.Errorf("%w: no key at index %d", ErrInvalidSignature, siger.Index)
+		}
+		key := state.Keys[siger.Index]
+		publicKey, _ := decodeKey(key)
+		if !ed25519.Verify(publicKey, e.Raw, siger.Raw) {
+			return nil, fmt.Errorf("%w: signature does not match key %s", ErrInvalidSignature, key)
+		}
+		current[siger.Index] = true
+		if e.Type == Rot && !cesr.CurrentOnlyCodes[siger.Code] && siger.Ondex < len(prior.NextDigests) {
+			d, _ := cesr.DecodeDiger(prior.NextDigests[siger.Ondex])
+			if d.Verify([]byte(key)) {
+				exposed[siger.Ondex] = true
+			}
+		}
+	}
+	if len(current) < state.KeyThreshold {
+		return nil, fmt.Errorf("%w: %d of %d signatures", ErrThreshold, len(current), state.KeyThreshold)
+	}
+	if e.Type == Rot && len(exposed) < prior.NextThreshold {
+		return nil, fmt.Errorf("%w: %d of %d prior next keys", ErrThreshold, len(exposed), prior.NextThreshold)
+	}
+	return state, nil
+}
+
+// KEL is a validated key event log along with the witness receipts of its
+// events.
+type KEL struct {
+	messages []*Message
+	states   []*State
+	// receipts holds the witnesses with a valid receipt of each event.
+	receipts []map[string]bool
+}
+
+// NewKEL validates msgs, starting with the inception, into a KEL. Receipt
+// messages add the receipts of the events before them.
+func NewKEL(msgs ...*Message) (*KEL, error) {
+	k := &KEL{}
+	for _, m := range msgs {
+		var err error
+		if m.Event.Type == Rct {
+			_, err = k.AddReceipt(m)
+		} else {
+			err = k.Append(m)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// ParseKEL parses and validates a KEL stream as written by Bytes.
+func ParseKEL(stream []byte) (*KEL, error) {
+	msgs, err := ParseMessages(stream)
+	if err != nil {
+		return nil, err
+	}
+	return NewKEL(msgs...)
+}
+
+// Append validates m against the current state and adds it to the log. The
+// witness signatures attached to m are checked and recorded as receipts.
+func (k *KEL) Append(m *Message) error {
+	state, err := Apply(k.State(), m)
+	if err != nil {
+		return fmt.Errorf("event %d of the KEL: %w", len(k.messages), err)
+	}
+	stored := &Message{Event: m.Event, Sigers: m.Sigers, Attachments: m.Attachments}
+	receipts := map[string]bool{}
+	if _, err := addWitnessSignatures(stored, state, receipts, m); err != nil {
+		return fmt.Errorf("event %d of the KEL: %w", len(k.messages), err)
+	}
+	k.messages = append(k.messages, stored)
+	k.states = append(k.states, state)
+	k.receipts = append(k.receipts, receipts)
+	return nil
+}
+
+// State returns the current key state, nil for an empty log.
+func (k *KEL) State() *State {
+	if len(k.states) == 0 {
+		return nil
+	}
+	return k.states[len(k.states)-1]
+}
+
+// Clone returns a copy of k that appending events and receipts to k does not
+// change.
+func (k *KEL) Clone() *KEL {
+	c := &KEL{
+		messages: make([]*Message, len(k.messages)),
+		states:   append([]*State(nil), k.states...),
+		receipts: make([]map[string]bool, len(k.receipts)),
+	}
+	for i, m := range k.messages {
+		copied := *m
+		copied.WitnessSigers = append([]*cesr.Indexer(nil), m.WitnessSigers...)
+		copied.Couples = append([][2]*cesr.Matter(nil), m.Couples...)
+		c.messages[i] = &copied
+	}
+	for i, receipts := range k.receipts {
+		c.receipts[i] = make(map[string]bool, len(receipts))
+		for w := range receipts {
+			c.receipts[i][w] = true
+		}
+	}
+	return c
+}
+
+// Messages returns the events of the log in order.
+func (k *KEL) Messages() []*Message {
+	return k.messages
+}
+
+// Bytes returns the log as a CESR stream.
+func (k *KEL) Bytes() ([]byte, error) {
+	var out []byte
+	for _, m := range k.messages {
+		b, err := m.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
@@ -0,0 +1,270 @@
+package keri
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKeys(t *testing.T, n int) ([]signer.Signer, []string) {
+	var signers []signer.Signer
+	var keys []string
+	for i := 0; i < n; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		s := signer.NewInMemorySigner(priv, cesr.Ed25519)
+		signers = append(signers, s)
+		keys = append(keys, signer.AID(s))
+	}
+	return signers, keys
+}
+
+func digests(t *testing.T, keys []string) []string {
+	d, err := NextDigests(keys...)
+	require.NoError(t, err)
+	return d
+}
+
+func sign(t *testing.T, e *Event, signers ...signer.Signer) *Message {
+	m, err := Sign(context.Background(), e, signers...)
+	require.NoError(t, err)
+	return m
+}
+
+func TestKELRotation(t *testing.T) {
+	signers0, keys0 := newKeys(t, 1)
+	signers1, keys1 := newKeys(t, 1)
+	signers2, keys2 := newKeys(t, 1)
+
+	icp, err := Incept(keys0, digests(t, keys1))
+	require.NoError(t, err)
+	kel, err := NewKEL(sign(t, icp, signers0...))
+	require.NoError(t, err)
+	assert.Equal(t, icp.Prefix, kel.State().Prefix)
+
+	rot, err := Rotate(kel.State(), keys1, digests(t, keys2))
+	require.NoError(t, err)
+	require.NoError(t, kel.Append(sign(t, rot, signers1...)))
+
+	state := kel.State()
+	assert.Equal(t, uint64(1), state.Sequence)
+	assert.Equal(t, rot.SAID, state.SAID)
+	assert.Equal(t, keys1, state.Keys)
+
+	ks := state.KeyState()
+	assert.Equal(t, icp.Prefix, ks.AID)
+	assert.Equal(t, keys1, ks.Keys)
+	assert.False(t, ks.Revoked)
+
+	stream, err := kel.Bytes()
+	require.NoError(t, err)
+	parsed, err := ParseKEL(stream)
+	require.NoError(t, err)
+	assert.Equal(t, state, parsed.State())
+	assert.Len(t, parsed.Messages(), 2)
+
+	// the old key cannot rotate again
+	rot2, err := Rotate(state, keys2, nil)
+	require.NoError(t, err)
+	err = kel.Append(sign(t, rot2, signers2...))
+	require.NoError(t, err)
+	assert.True(t, kel.State().KeyState().Revoked, "rotated to no next keys")
+
+	_, err = Rotate(kel.State(), keys0, nil)
+	assert.ErrorIs(t, err, ErrNotTransferable)
+}
+
+func TestKELRejectsInvalidEvents(t *testing.T) {
+	signers0, keys0 := newKeys(t, 1)
+	signers1, keys1 := newKeys(t, 1)
+	others, otherKeys := newKeys(t, 1)
+
+	icp, err := Incept(keys0, digests(t, keys1))
+	require.NoError(t, err)
+	state, err := Apply(nil, sign(t, icp, signers0...))
+	require.NoError(t, err)
+
+	_, err = Apply(nil, &Message{Event: icp})
+	assert.ErrorIs(t, err, ErrThreshold, "unsigned inception")
+	_, err = Apply(state, sign(t, icp, signers0...))
+	assert.ErrorIs(t, err, ErrOutOfOrder, "second inception")
+
+	_, err = Rotate(state, otherKeys, nil)
+	assert.ErrorIs(t, err, ErrThreshold, "keys that were not pre-rotated")
+
+	// a rotation signed by a key that does not match the next digest
+	rot := &Event{Type: Rot, Prefix: state.Prefix, Sequence: 1, Prior: state.SAID, KeyThreshold: 1, Keys: otherKeys}
+	require.NoError(t, rot.saidify())
+	_, err = Apply(state, sign(t, rot, others...))
+	assert.ErrorIs(t, err, ErrThreshold)
+
+	rot, err = Rotate(state, keys1, nil)
+	require.NoError(t, err)
+	_, err = Sign(context.Background(), rot, signers0[0])
+	assert.ErrorContains(t, err, "not a signing key")
+
+	m := sign(t, rot, signers1...)
+	m.Sigers[0].Raw = append([]byte{}, m.Sigers[0].Raw...)
+	m.Sigers[0].Raw[0] ^= 1
+	_, err = Apply(state, m)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	skipped := &Event{Type: Rot, Prefix: state.Prefix, Sequence: 2, Prior: state.SAID, KeyThreshold: 1, Keys: keys1}
+	require.NoError(t, skipped.saidify())
+	_, err = Apply(state, sign(t, skipped, signers1...))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+
+	forked := &Event{Type: Rot, Prefix: state.Prefix, Sequence: 1, Prior: rot.SAID, KeyThreshold: 1, Keys: keys1}
+	require.NoError(t, forked.saidify())
+	_, err = Apply(state, sign(t, forked, signers1...))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+
+	tampered := *rot
+	tampered.SAID = icp.SAID
+	_, err = Apply(state, &Message{Event: &tampered})
+	assert.ErrorIs(t, err, ErrInvalidSAID)
+}
+
+func TestKELThresholds(t *testing.T) {
+	signers0, keys0 := newKeys(t, 3)
+	signers1, keys1 := newKeys(t, 3)
+
+	icp, err := Incept(keys0, digests(t, keys1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, icp.KeyThreshold)
+	assert.Equal(t, 2, icp.NextThreshold)
+
+	_, err = Apply(nil, sign(t, icp, signers0[0]))
+	assert.ErrorIs(t, err, ErrThreshold)
+	state, err := Apply(nil, sign(t, icp, signers0[0], signers0[2]))
+	require.NoError(t, err)
+
+	// two of the pre-rotated keys and a new one, signed by a single key
+	_, fresh := newKeys(t, 1)
+	keys := []string{keys1[0], keys1[1], fresh[0]}
+	rot, err := Rotate(state, keys, nil, WithKeyThreshold(1))
+	require.NoError(t, err)
+	_, err = Apply(state, sign(t, rot, signers1[0]))
+	assert.ErrorIs(t, err, ErrThreshold, "1 of 2 prior next keys")
+	next, err := Apply(state, sign(t, rot, signers1[0], signers1[1]))
+	require.NoError(t, err)
+	assert.Equal(t, keys, next.Keys)
+
+	_, err = Incept(keys0, nil, WithKeyThreshold(4))
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+	_, err = Incept(keys0[:1], digests(t, keys1), WithBasicPrefix())
+	require.NoError(t, err)
+	_, err = Incept(keys0, nil, WithBasicPrefix())
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+}
+
+func TestKELNonTransferable(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	s := signer.NewInMemorySigner(priv, cesr.Ed25519N)
+	_, next := newKeys(t, 1)
+
+	_, err = Incept([]string{signer.AID(s)}, digests(t, next), WithBasicPrefix())
+	assert.ErrorIs(t, err, ErrNotTransferable)
+
+	icp, err := Incept([]string{signer.AID(s)}, nil, WithBasicPrefix())
+	require.NoError(t, err)
+	state, err := Apply(nil, sign(t, icp, s))
+	require.NoError(t, err)
+	assert.Equal(t, signer.AID(s), state.Prefix)
+	assert.False(t, state.Transferable())
+	assert.False(t, state.KeyState().Revoked)
+
+	// a non-transferable AID cannot anchor interactions, which would leave it
+	// reported as revoked
+	_, err = Interact(state)
+	assert.ErrorIs(t, err, ErrNotTransferable)
+	ixn := &Event{Type: Ixn, Prefix: state.Prefix, Sequence: 1, Prior: state.SAID}
+	require.NoError(t, ixn.saidify())
+	m, err := SignWithKeys(context.Background(), ixn, state.Keys, s)
+	require.NoError(t, err)
+	_, err = Apply(state, m)
+	assert.ErrorIs(t, err, ErrNotTransferable)
+	assert.False(t, state.KeyState().Revoked)
+}
+
+func TestKELWitnesses(t *testing.T) {
+	signers0, keys0 := newKeys(t, 1)
+	signers1, keys1 := newKeys(t, 1)
+	_, wits := newKeys(t, 3)
+
+	_, err := Incept(keys0, digests(t, keys1), WithWitnesses(3, wits[:2]...))
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+	_, err = Incept(keys0, digests(t, keys1), WithWitnesses(1, wits[0], wits[0]))
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+
+	icp, err := Incept(keys0, digests(t, keys1), WithWitnesses(2, wits[:2]...))
+	require.NoError(t, err)
+	state, err := Apply(nil, sign(t, icp, signers0...))
+	require.NoError(t, err)
+	assert.Equal(t, wits[:2], state.Witnesses)
+
+	_, err = Rotate(state, keys1, nil, WithWitnessChanges(1, []string{wits[2]}, nil))
+	assert.ErrorIs(t, err, ErrMalformedEvent, "cut of an unknown witness")
+	_, err = Rotate(state, keys1, nil, WithWitnessChanges(1, nil, []string{wits[1]}))
+	assert.ErrorIs(t, err, ErrMalformedEvent, "add of an existing witness")
+
+	rot, err := Rotate(state, keys1, nil, WithWitnessChanges(2, []string{wits[0]}, []string{wits[2]}))
+	require.NoError(t, err)
+	state, err = Apply(state, sign(t, rot, signers1...))
+	require.NoError(t, err)
+	assert.Equal(t, []string{wits[1], wits[2]}, state.Witnesses)
+	assert.Equal(t, 2, state.WitnessThreshold)
+}
+
+func TestKELInteraction(t *testing.T) {
+	signers0, keys0 := newKeys(t, 2)
+	signers1, keys1 := newKeys(t, 2)
+	icp, err := Incept(keys0, digests(t, keys1), WithKeyThreshold(2))
+	require.NoError(t, err)
+	kel, err := NewKEL(sign(t, icp, signers0...))
+	require.NoError(t, err)
+
+	seal := json.RawMessage(`{"i":"EBfdlu8R27Fbx-ehrqwImnK-8Cm79sqbAQ4MmvEAYqao","s":"0","d":"EBfdlu8R27Fbx-ehrqwImnK-8Cm79sqbAQ4MmvEAYqao"}`)
+	ixn, err := Interact(kel.State(), WithAnchors(seal))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`{"v":"KERI10JSON%06x_","t":"ixn","d":"%s","i":"%s","s":"1","p":"%s","a":[%s]}`,
+		len(ixn.Raw), ixn.SAID, icp.Prefix, icp.SAID, seal), string(ixn.Raw))
+
+	_, err = Sign(context.Background(), ixn, signers0...)
+	assert.Error(t, err, "an interaction event has no keys")
+	m, err := SignWithKeys(context.Background(), ixn, kel.State().Keys, signers0[1])
+	require.NoError(t, err)
+	assert.ErrorIs(t, kel.Append(m), ErrThreshold, "1 of 2 current keys")
+	m, err = SignWithKeys(context.Background(), ixn, kel.State().Keys, signers0...)
+	require.NoError(t, err)
+	require.NoError(t, kel.Append(m))
+	assert.Equal(t, uint64(1), kel.State().Sequence)
+	assert.Equal(t, keys0, kel.State().Keys)
+
+	// the keys exposed by a rotation after an interaction
+	rot, err := Rotate(kel.State(), keys1, nil)
+	require.NoError(t, err)
+	require.NoError(t, kel.Append(sign(t, rot, signers1...)))
+
+	stream, err := kel.Bytes()
+	require.NoError(t, err)
+	parsed, err := ParseKEL(stream)
+	require.NoError(t, err)
+	assert.Equal(t, kel.State(), parsed.State())
+
+	eo, err := Incept(keys0, digests(t, keys1), WithConfig(EstablishmentOnly))
+	require.NoError(t, err)
+	state, err := Apply(nil, sign(t, eo, signers0...))
+	require.NoError(t, err)
+	_, err = Interact(state)
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+}
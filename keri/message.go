@@ -0,0 +1,146 @@
+package keri
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+)
+
+// Message is an event with its attachments, as found in a KEL stream.
+type Message struct {
+	Event *Event
+	// Sigers are the indexed controller signatures of the -A group.
+	Sigers []*cesr.Indexer
+	// WitnessSigers are the indexed witness signatures of the -B group,
+	// indexed into the witness list of the event.
+	WitnessSigers []*cesr.Indexer
+	// Couples are the non-transferable receipt couples of the -C group: the
+	// key of a witness and its signature.
+	Couples [][2]*cesr.Matter
+	// Attachments holds any other attachment groups.
+	Attachments []cesr.Group
+}
+
+// Sign signs e with each of signers, whose AIDs must be among the keys of e,
+// and returns the signed message. The signatures are dual indexed with the
+// same index and ondex, so a rotation must list its keys in the order of the
+// prior next digests.
+func Sign(ctx context.Context, e *Event, signers ...signer.Signer) (*Message, error) {
+	return SignWithKeys(ctx, e, e.Keys, signers...)
+}
+
+// SignWithKeys is Sign for events that carry no keys, such as interaction
+// events, which are signed with the current keys of the state they follow.
+func SignWithKeys(ctx context.Context, e *Event, keys []string, signers ...signer.Signer) (*Message, error) {
+	m := &Message{Event: e}
+	for _, s := range signers {
+		aid := signer.AID(s)
+		index := -1
+		for i, key := range keys {
+			if key == aid {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("signer %s is not a signing key", aid)
+		}
+		sig, err := s.Sign(ctx, e.Raw)
+		if err != nil {
+			return nil, err
+		}
+		siger, err := cesr.NewIndexer(cesr.Ed25519IdxSig, index, index, sig)
+		if err != nil {
+			return nil, err
+		}
+		m.Sigers = append(m.Sigers, siger)
+	}
+	return m, nil
+}
+
+// Bytes returns the serialized event followed by its attachments.
+func (m *Message) Bytes() ([]byte, error) {
+	out := append([]byte{}, m.Event.Raw...)
+	var groups []cesr.Group
+	if len(m.Sigers) > 0 {
+		groups = append(groups, cesr.Group{Counter: &cesr.Counter{Code: cesr.ControllerIdxSigs}, Sigers: m.Sigers})
+	}
+	if len(m.WitnessSigers) > 0 {
+		groups = append(groups, cesr.Group{Counter: &cesr.Counter{Code: cesr.WitnessIdxSigs}, Sigers: m.WitnessSigers})
+	}
+	if len(m.Couples) > 0 {
+		groups = append(groups, cesr.Group{Counter: &cesr.Counter{Code: cesr.NonTransReceiptCouples}, Couples: m.Couples})
+	}
+	groups = append(groups, m.Attachments...)
+	for i := range groups {
+		s, err := groups[i].Qb64()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s...)
+	}
+	return out, nil
+}
+
+// ParseMessages splits a CESR stream of JSON events and their qb64
+// attachments, such as a KEL, into messages. Attachments wrapped in -V
+// attachment groups are flattened. Messages of types that ParseEvent does not
+// support, such as the rpy replies in an OOBI response, are returned with
+// only Type, SAID, Prefix, Sequence and Raw set.
+func ParseMessages(stream []byte) ([]*Message, error) {
+	var msgs []*Message
+	for len(stream) > 0 {
+		if stream[0] != '{' {
+			return nil, fmt.Errorf("%w: expected an event at %.8q", ErrMalformedEvent, stream)
+		}
+		size, err := eventSize(stream)
+		if err != nil {
+			return nil, err
+		}
+		if size > len(stream) {
+			return nil, fmt.Errorf("%w: event of %d bytes exceeds stream", ErrMalformedEvent, size)
+		}
+		e, err := parseHeader(stream[:size])
+		if err != nil {
+			return nil, err
+		}
+		if supported(e.Type) {
+			if e, err = ParseEvent(stream[:size]); err != nil {
+				return nil, err
+			}
+		}
+		stream = stream[size:]
+
+		m := &Message{Event: e}
+		for len(stream) > 0 && stream[0] != '{' {
+			g, n, err := cesr.ParseGroup(string(stream))
+			if err != nil {
+				return nil, fmt.Errorf("attachments of %s: %w", e.SAID, err)
+			}
+			m.addGroup(*g)
+			stream = stream[n:]
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func (m *Message) addGroup(g cesr.Group) {
+	switch g.Counter.Code {
+	case cesr.ControllerIdxSigs:
+		m.Sigers = append(m.Sigers, g.Sigers...)
+	case cesr.WitnessIdxSigs:
+		m.WitnessSigers = append(m.WitnessSigers, g.Sigers...)
+	case cesr.NonTransReceiptCouples:
+		m.Couples = append(m.Couples, g.Couples...)
+	case cesr.AttachedMaterialQuadlets, cesr.BigAttachedMaterialQuadlets:
+		for _, nested := range g.Groups {
+			m.addGroup(nested)
+		}
+	case cesr.KERIProtocolStack, cesr.KERIACDCGenusVersion:
+	default:
+		m.Attachments = append(m.Attachments, g)
+	}
+}
@@ -0,0 +1,58 @@
+package keri
+
+import (
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageStream(t *testing.T) {
+	signers, keys := newKeys(t, 2)
+	icp, err := Incept(keys, nil)
+	require.NoError(t, err)
+	m := sign(t, icp, signers...)
+
+	b, err := m.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, string(icp.Raw)+"-AAC", string(b[:len(icp.Raw)+4]))
+
+	msgs, err := ParseMessages(append(b, b...))
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, icp, msgs[1].Event)
+	assert.Equal(t, m.Sigers, msgs[1].Sigers)
+
+	// keripy wraps the attachments of a message in a -V group
+	sigs := cesr.Group{Counter: &cesr.Counter{Code: cesr.ControllerIdxSigs}, Sigers: m.Sigers}
+	seq, err := cesr.NewMatter(cesr.Big, make([]byte, 8))
+	require.NoError(t, err)
+	receipt := cesr.Group{Counter: &cesr.Counter{Code: cesr.FirstSeenReplayCouples}, Couples: [][2]*cesr.Matter{{seq, seq}}}
+	wrapped := cesr.Group{Counter: &cesr.Counter{Code: cesr.AttachedMaterialQuadlets}, Groups: []cesr.Group{sigs, receipt}}
+	attachments, err := wrapped.Qb64()
+	require.NoError(t, err)
+
+	msgs, err = ParseMessages(append(append([]byte{}, icp.Raw...), attachments...))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, m.Sigers, msgs[0].Sigers)
+	require.Len(t, msgs[0].Attachments, 1)
+	assert.Equal(t, cesr.FirstSeenReplayCouples, msgs[0].Attachments[0].Counter.Code)
+
+	// messages of other types are framed but not decoded
+	rpy := `{"v":"KERI10JSON00006a_","t":"rpy","d":"EBfdlu8R27Fbx-ehrqwImnK-8Cm79sqbAQ4MmvEAYqao","r":"/end/role/add"}`
+	msgs, err = ParseMessages(append([]byte(rpy), b...))
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "rpy", msgs[0].Event.Type)
+	assert.Equal(t, rpy, string(msgs[0].Event.Raw))
+	assert.Equal(t, icp, msgs[1].Event)
+
+	_, err = ParseMessages([]byte("-AAB"))
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+	_, err = ParseMessages(b[:len(icp.Raw)-1])
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+	_, err = ParseMessages(append(append([]byte{}, icp.Raw...), "-AAB"...))
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package keri
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+)
+
+var ErrNotWitnessed = errors.New("key event not witnessed")
+
+// Receipt builds the rct message body that receipts e. The witness
+// signatures attached to it are signatures of e itself.
+func Receipt(e *Event) (*Event, error) {
+	r := &Event{Type: Rct, SAID: e.SAID, Prefix: e.Prefix, Sequence: e.Sequence}
+	if _, err := r.serialize(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SignReceipt returns the receipt of e by the witness s, whose AID must be in
+// witnesses, the witness list of the state e establishes. The signature is
+// indexed into witnesses and attached as a -B group.
+func SignReceipt(ctx context.Context, e *Event, witnesses []string, s signer.Signer) (*Message, error) {
+	aid := signer.AID(s)
+	index := -1
+	for i, w := range witnesses {
+		if w == aid {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("signer %s is not a witness", aid)
+	}
+	r, err := Receipt(e)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.Sign(ctx, e.Raw)
+	if err != nil {
+		return nil, err
+	}
+	wiger, err := cesr.NewIndexer(cesr.Ed25519CrtSig, index, 0, sig)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Event: r, WitnessSigers: []*cesr.Indexer{wiger}}, nil
+}
+
+// addWitnessSignatures verifies the witness signatures of m, indexed -B
+// signatures and -C couples, against the witnesses of state and adds those of
+// witnesses not yet in receipts to stored. It returns how many were added.
+func addWitnessSignatures(stored *Message, state *State, receipts map[string]bool, m *Message) (int, error) {
+	raw := stored.Event.Raw
+	added := 0
+	for _, wiger := range m.WitnessSigers {
+		if wiger.Index >= len(state.Witnesses) {
+			return added, fmt.Errorf("%w: no witness at index %d", ErrInvalidSignature, wiger.Index)
+		}
+		witness := state.Witnesses[wiger.Index]
+		if err := verifyWitness(witness, raw, wiger.Raw); err != nil {
+			return added, err
+		}
+		if !receipts[witness] {
+			receipts[witness] = true
+			stored.WitnessSigers = append(stored.WitnessSigers, wiger)
+			added++
+		}
+	}
+	for _, couple := range m.Couples {
+		verfer, cigar := couple[0], couple[1]
+		witness := verfer.Qb64()
+		if !contains(state.Witnesses, witness) {
+			return added, fmt.Errorf("%w: receipt by %s, which is not a witness", ErrInvalidSignature, witness)
+		}
+		if cigar.Code != cesr.Ed25519Sig {
+			return added, fmt.Errorf("%w: receipt signature code %s", ErrInvalidSignature, cigar.Code)
+		}
+		if err := verifyWitness(witness, raw, cigar.Raw); err != nil {
+			return added, err
+		}
+		if !receipts[witness] {
+			receipts[witness] = true
+			stored.Couples = append(stored.Couples, couple)
+			added++
+		}
+	}
+	return added, nil
+}
+
+func verifyWitness(witness string, raw, sig []byte) error {
+	publicKey, err := decodeKey(witness)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, raw, sig) {
+		return fmt.Errorf("%w: receipt does not match witness %s", ErrInvalidSignature, witness)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AddReceipt verifies the witness signatures of m, an rct message or a copy
+// of an event in the log, against the witness list of the receipted event
+// and records them. It returns how many new witnesses receipted the event.
+func (k *KEL) AddReceipt(m *Message) (int, error) {
+	e := m.Event
+	if e.Sequence >= uint64(len(k.messages)) {
+		return 0, fmt.Errorf("%w: receipt of unknown event %d of %s", ErrOutOfOrder, e.Sequence, e.Prefix)
+	}
+	stored := k.messages[e.Sequence]
+	if e.Prefix != stored.Event.Prefix || e.SAID != stored.Event.SAID {
+		return 0, fmt.Errorf("%w: receipt of %s is not of event %d %s", ErrOutOfOrder, e.SAID, e.Sequence, stored.Event.SAID)
+	}
+	n, err := addWitnessSignatures(stored, k.states[e.Sequence], k.receipts[e.Sequence], m)
+	if err != nil {
+		return n, fmt.Errorf("receipt of event %d: %w", e.Sequence, err)
+	}
+	return n, nil
+}
+
+// Receipts returns the witnesses with a valid receipt of event sn, in the
+// order of the witness list.
+func (k *KEL) Receipts(sn uint64) []string {
+	if sn >= uint64(len(k.messages)) {
+		return nil
+	}
+	var witnesses []string
+	for _, w := range k.states[sn].Witnesses {
+		if k.receipts[sn][w] {
+			witnesses = append(witnesses, w)
+		}
+	}
+	return witnesses
+}
+
+// WitnessedState returns the key state after the last event that, like every
+// event before it, has receipts from at least the witness threshold of its
+// witnesses. Later events are not trusted yet, so a rotation that is not
+// fully witnessed leaves the prior keys in place. An AID without witnesses is
+// fully witnessed.
+func (k *KEL) WitnessedState() (*State, error) {
+	n := 0
+	for n < len(k.states) && len(k.receipts[n]) >= k.states[n].WitnessThreshold {
+		n++
+	}
+	if n == 0 {
+		if len(k.states) == 0 {
+			return nil, fmt.Errorf("%w: empty KEL", ErrNotWitnessed)
+		}
+		return nil, fmt.Errorf("%w: inception of %s has %d of %d receipts", ErrNotWitnessed, k.states[0].Prefix, len(k.receipts[0]), k.states[0].WitnessThreshold)
+	}
+	return k.states[n-1], nil
+}
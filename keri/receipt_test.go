@@ -0,0 +1,166 @@
+package keri
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWitnesses(t *testing.T, n int) ([]signer.Signer, []string) {
+	var signers []signer.Signer
+	var aids []string
+	for i := 0; i < n; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		s := signer.NewInMemorySigner(priv, cesr.Ed25519N)
+		signers = append(signers, s)
+		aids = append(aids, signer.AID(s))
+	}
+	return signers, aids
+}
+
+func receipt(t *testing.T, e *Event, witnesses []string, s signer.Signer) *Message {
+	m, err := SignReceipt(context.Background(), e, witnesses, s)
+	require.NoError(t, err)
+	return m
+}
+
+func TestReceipts(t *testing.T) {
+	signers0, keys0 := newKeys(t, 1)
+	signers1, keys1 := newKeys(t, 1)
+	_, keys2 := newKeys(t, 1)
+	wits, aids := newWitnesses(t, 3)
+
+	icp, err := Incept(keys0, digests(t, keys1), WithWitnesses(2, aids...))
+	require.NoError(t, err)
+	kel, err := NewKEL(sign(t, icp, signers0...))
+	require.NoError(t, err)
+	_, err = kel.WitnessedState()
+	assert.ErrorIs(t, err, ErrNotWitnessed)
+
+	rct := receipt(t, icp, aids, wits[1])
+	assert.Equal(t, Rct, rct.Event.Type)
+	assert.Equal(t, icp.SAID, rct.Event.SAID)
+	b, err := rct.Bytes()
+	require.NoError(t, err)
+	msgs, err := ParseMessages(b)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, rct, msgs[0])
+
+	n, err := kel.AddReceipt(msgs[0])
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = kel.AddReceipt(rct)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "a receipt counts once")
+	_, err = kel.WitnessedState()
+	assert.ErrorIs(t, err, ErrNotWitnessed)
+
+	// a non-transferable receipt couple counts the same as an indexed signature
+	sig, err := wits[2].Sign(context.Background(), icp.Raw)
+	require.NoError(t, err)
+	verfer, err := cesr.DecodeMatter(aids[2])
+	require.NoError(t, err)
+	cigar, err := cesr.NewMatter(cesr.Ed25519Sig, sig)
+	require.NoError(t, err)
+	r, err := Receipt(icp)
+	require.NoError(t, err)
+	n, err = kel.AddReceipt(&Message{Event: r, Couples: [][2]*cesr.Matter{{verfer, cigar}}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, aids[1:], kel.Receipts(0))
+	state, err := kel.WitnessedState()
+	require.NoError(t, err)
+	assert.Equal(t, icp.SAID, state.SAID)
+
+	// a rotation is not trusted until its new witness list receipts it
+	_, added := newWitnesses(t, 1)
+	rot, err := Rotate(kel.State(), keys1, digests(t, keys2),
+		WithWitnessChanges(2, aids[:1], added))
+	require.NoError(t, err)
+	require.NoError(t, kel.Append(sign(t, rot, signers1...)))
+	state, err = kel.WitnessedState()
+	require.NoError(t, err)
+	assert.Equal(t, keys0, state.Keys)
+
+	_, err = kel.AddReceipt(receipt(t, rot, aids, wits[0]))
+	assert.ErrorIs(t, err, ErrInvalidSignature, "the cut witness is indexed into the wrong list")
+	_, err = SignReceipt(context.Background(), rot, kel.State().Witnesses, wits[0])
+	assert.Error(t, err)
+	_, err = kel.AddReceipt(receipt(t, rot, kel.State().Witnesses, wits[1]))
+	require.NoError(t, err)
+	clone := kel.Clone()
+	_, err = kel.AddReceipt(receipt(t, rot, kel.State().Witnesses, wits[2]))
+	require.NoError(t, err)
+	assert.Len(t, clone.Receipts(1), 1, "a clone does not see later receipts")
+	assert.Len(t, clone.Messages()[1].WitnessSigers, 1)
+	state, err = kel.WitnessedState()
+	require.NoError(t, err)
+	assert.Equal(t, keys1, state.Keys)
+
+	// the receipts are written with the events
+	b, err = kel.Bytes()
+	require.NoError(t, err)
+	parsed, err := ParseKEL(b)
+	require.NoError(t, err)
+	assert.Equal(t, kel.Receipts(0), parsed.Receipts(0))
+	assert.Equal(t, kel.Receipts(1), parsed.Receipts(1))
+	state, err = parsed.WitnessedState()
+	require.NoError(t, err)
+	assert.Equal(t, rot.SAID, state.SAID)
+}
+
+func TestReceiptErrors(t *testing.T) {
+	signers, keys := newKeys(t, 1)
+	wits, aids := newWitnesses(t, 2)
+	icp, err := Incept(keys, nil, WithWitnesses(1, aids...))
+	require.NoError(t, err)
+	kel, err := NewKEL(sign(t, icp, signers...))
+	require.NoError(t, err)
+
+	other, err := Incept(keys, nil, WithWitnesses(1, aids...), WithConfig(EstablishmentOnly))
+	require.NoError(t, err)
+	rct := receipt(t, other, aids, wits[0])
+	rct.Event.Prefix = icp.Prefix
+	_, err = kel.AddReceipt(rct)
+	assert.ErrorIs(t, err, ErrOutOfOrder, "receipt of another event")
+
+	rct = receipt(t, icp, aids, wits[0])
+	rct.Event.Sequence = 1
+	_, err = kel.AddReceipt(rct)
+	assert.ErrorIs(t, err, ErrOutOfOrder, "receipt of an unknown event")
+
+	// a signature of the receipt body instead of the event
+	rct = receipt(t, icp, aids, wits[0])
+	sig, err := wits[0].Sign(context.Background(), rct.Event.Raw)
+	require.NoError(t, err)
+	rct.WitnessSigers[0].Raw = sig
+	_, err = kel.AddReceipt(rct)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, outsiders := newWitnesses(t, 1)
+	verfer, err := cesr.DecodeMatter(outsiders[0])
+	require.NoError(t, err)
+	cigar, err := cesr.NewMatter(cesr.Ed25519Sig, sig)
+	require.NoError(t, err)
+	_, err = kel.AddReceipt(&Message{Event: rct.Event, Couples: [][2]*cesr.Matter{{verfer, cigar}}})
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	assert.Empty(t, kel.Receipts(0))
+
+	// receipts attached to the event itself are checked when it is appended
+	m := sign(t, icp, signers...)
+	m.WitnessSigers = receipt(t, icp, aids, wits[1]).WitnessSigers
+	kel, err = NewKEL(m)
+	require.NoError(t, err)
+	assert.Equal(t, aids[1:], kel.Receipts(0))
+	m.WitnessSigers = rct.WitnessSigers
+	_, err = NewKEL(m)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -0,0 +1,187 @@
+// Package keys generates Ed25519 signing keys and converts them between the
+// formats used to store and exchange them.
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+)
+
+// Format is an encoding of an Ed25519 private key.
+type Format string
+
+const (
+	// FormatPKCS8 is a PEM "PRIVATE KEY" block holding PKCS#8 DER.
+	FormatPKCS8 Format = "pkcs8"
+	// FormatSeed is the 32-byte seed, hex-encoded.
+	FormatSeed Format = "seed"
+	// FormatJWK is an OKP JSON Web Key as defined in RFC 8037.
+	FormatJWK Format = "jwk"
+	// FormatCESR is the seed encoded with the CESR Ed25519Seed code "A".
+	FormatCESR Format = "cesr"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{FormatPKCS8, FormatSeed, FormatJWK, FormatCESR}
+
+// legacyPEMType is the PEM type written by earlier versions of keygen, holding
+// the 64-byte private key.
+const legacyPEMType = "ED25519 PRIVATE KEY"
+
+var ErrUnsupportedFormat = errors.New("unsupported key format")
+
+// Generate returns a new Ed25519 private key.
+func Generate() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	return priv, nil
+}
+
+// AID returns the CESR-encoded public key of key. Transferable keys use the
+// "D" code and may be rotated, others use "B".
+func AID(key ed25519.PrivateKey, transferable bool) string {
+	code := cesr.Ed25519N
+	if transferable {
+		code = cesr.Ed25519
+	}
+	return cesr.Encode(key.Public().(ed25519.PublicKey), code)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	D   string `json:"d,omitempty"`
+	X   string `json:"x"`
+}
+
+// Marshal encodes key in format f.
+func Marshal(key ed25519.PrivateKey, f Format) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(key))
+	}
+	switch f {
+	case FormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case FormatSeed:
+		return []byte(hex.EncodeToString(key.Seed()) + "\n"), nil
+	case FormatJWK:
+		b, err := json.Marshal(jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			D:   base64.RawURLEncoding.EncodeToString(key.Seed()),
+			X:   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case FormatCESR:
+		return []byte(cesr.Encode(key.Seed(), cesr.Ed25519Seed) + "\n"), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, f)
+}
+
+// Parse decodes a private key in format f. FormatPKCS8 also accepts the
+// "ED25519 PRIVATE KEY" blocks written by earlier versions of keygen.
+func Parse(data []byte, f Format) (ed25519.PrivateKey, error) {
+	switch f {
+	case FormatPKCS8:
+		return parsePEM(data)
+	case FormatSeed:
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex seed: %w", err)
+		}
+		return fromSeed(seed)
+	case FormatJWK:
+		return parseJWK(data)
+	case FormatCESR:
+		m, err := cesr.DecodeMatter(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		if m.Code != cesr.Ed25519Seed {
+			return nil, fmt.Errorf("expected an Ed25519 seed, got code %s", m.Code)
+		}
+		return fromSeed(m.Raw)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, f)
+}
+
+func parsePEM(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("expected an Ed25519 key, got %T", key)
+		}
+		return priv, nil
+	case legacyPEMType:
+		if len(block.Bytes) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid private key length %d", len(block.Bytes))
+		}
+		priv := ed25519.NewKeyFromSeed(block.Bytes[:ed25519.SeedSize])
+		if !bytes.Equal(priv, block.Bytes) {
+			return nil, errors.New("private key does not match its public key")
+		}
+		return priv, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+}
+
+func parseJWK(data []byte) (ed25519.PrivateKey, error) {
+	var k jwk
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	if k.Kty != "OKP" || k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("expected an OKP Ed25519 JWK, got %s %s", k.Kty, k.Crv)
+	}
+	if k.D == "" {
+		return nil, errors.New("JWK holds no private key")
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(k.D)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK d: %w", err)
+	}
+	priv, err := fromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if k.X != "" && k.X != base64.RawURLEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)) {
+		return nil, errors.New("JWK x does not match d")
+	}
+	return priv, nil
+}
+
+func fromSeed(seed []byte) (ed25519.PrivateKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid seed length %d", len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
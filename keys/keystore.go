@@ -0,0 +1,245 @@
+package keys
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF derives the keystore encryption key from the passphrase.
+type KDF string
+
+const (
+	KDFArgon2id KDF = "argon2id"
+	KDFScrypt   KDF = "scrypt"
+)
+
+const (
+	keystoreVersion = 1
+	keystoreCipher  = "xchacha20-poly1305"
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// keystore is the JSON document written by Encrypt. The seed is sealed with
+// XChaCha20-Poly1305 under a key derived from the passphrase, with the AID as
+// additional data so it cannot be swapped for another key's AID.
+type keystore struct {
+	Version    int       `json:"version"`
+	AID        string    `json:"aid"`
+	KDF        kdfParams `json:"kdf"`
+	Cipher     string    `json:"cipher"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+}
+
+type kdfParams struct {
+	Name string `json:"name"`
+	// Salt is a CESR Salt128.
+	Salt string `json:"salt"`
+	// argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+	// scrypt
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+}
+
+// Limits on the KDF parameters, so a crafted keystore cannot make Decrypt
+// spend unbounded memory or time before the passphrase is even checked.
+const (
+	maxArgon2Time    = 10
+	maxArgon2Memory  = 1 << 20 // KiB, 1 GiB
+	maxArgon2Threads = 64
+	maxScryptN       = 1 << 20
+	maxScryptRP      = 1 << 30
+	maxScryptMemory  = 1 << 30 // bytes, 128·N·r
+)
+
+type keystoreConfig struct {
+	kdf          kdfParams
+	transferable bool
+}
+
+// KeystoreOption configures Encrypt and SaveKeystore.
+type KeystoreOption func(*keystoreConfig)
+
+// WithArgon2id derives the key with Argon2id, the default. memory is in KiB.
+func WithArgon2id(time, memory uint32, threads uint8) KeystoreOption {
+	return func(c *keystoreConfig) {
+		c.kdf = kdfParams{Name: string(KDFArgon2id), Time: time, Memory: memory, Threads: threads}
+	}
+}
+
+// WithScrypt derives the key with scrypt instead of Argon2id.
+func WithScrypt(n, r, p int) KeystoreOption {
+	return func(c *keystoreConfig) {
+		c.kdf = kdfParams{Name: string(KDFScrypt), N: n, R: r, P: p}
+	}
+}
+
+// WithTransferable records the transferable "D" AID instead of "B".
+func WithTransferable() KeystoreOption {
+	return func(c *keystoreConfig) {
+		c.transferable = true
+	}
+}
+
+// Encrypt seals key in a keystore protected by passphrase.
+func Encrypt(key ed25519.PrivateKey, passphrase []byte, opts ...KeystoreOption) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(key))
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	cfg := &keystoreConfig{}
+	WithArgon2id(3, 64*1024, 4)(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cfg.kdf.Salt = cesr.Encode(salt, cesr.Salt128)
+	aead, err := newAEAD(cfg.kdf, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ks := keystore{
+		Version: keystoreVersion,
+		AID:     AID(key, cfg.transferable),
+		KDF:     cfg.kdf,
+		Cipher:  keystoreCipher,
+		Nonce:   base64.RawURLEncoding.EncodeToString(nonce),
+	}
+	sealed := aead.Seal(nil, nonce, key.Seed(), []byte(ks.AID))
+	ks.Ciphertext = base64.RawURLEncoding.EncodeToString(sealed)
+	b, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Decrypt opens a keystore written by Encrypt and returns the key and the AID
+// recorded with it.
+func Decrypt(data []byte, passphrase []byte) (ed25519.PrivateKey, string, error) {
+	var ks keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, "", fmt.Errorf("invalid keystore: %w", err)
+	}
+	if ks.Version != keystoreVersion || ks.Cipher != keystoreCipher {
+		return nil, "", fmt.Errorf("unsupported keystore version %d with cipher %q", ks.Version, ks.Cipher)
+	}
+	aead, err := newAEAD(ks.KDF, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(ks.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, "", errors.New("invalid keystore nonce")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return nil, "", errors.New("invalid keystore ciphertext")
+	}
+	seed, err := aead.Open(nil, nonce, sealed, []byte(ks.AID))
+	if err != nil {
+		return nil, "", ErrWrongPassphrase
+	}
+	key, err := fromSeed(seed)
+	if err != nil {
+		return nil, "", err
+	}
+	if ks.AID != AID(key, false) && ks.AID != AID(key, true) {
+		return nil, "", errors.New("keystore AID does not match the key")
+	}
+	return key, ks.AID, nil
+}
+
+// SaveKeystore encrypts key and writes it to path, which must not exist.
+func SaveKeystore(path string, key ed25519.PrivateKey, passphrase []byte, opts ...KeystoreOption) error {
+	data, err := Encrypt(key, passphrase, opts...)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadKeystore reads and decrypts the keystore at path.
+func LoadKeystore(path string, passphrase []byte) (ed25519.PrivateKey, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return Decrypt(data, passphrase)
+}
+
+// LoadKeystoreSigner returns a Signer for the keystore at path, ready for
+// httpclient.NewClientWithSigner. The signer uses the code of the AID
+// recorded in the keystore.
+func LoadKeystoreSigner(path string, passphrase []byte) (*signer.InMemorySigner, error) {
+	key, aid, err := LoadKeystore(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return signer.NewInMemorySigner(key, aid[:1]), nil
+}
+
+func newAEAD(p kdfParams, passphrase []byte) (cipher.AEAD, error) {
+	m, err := cesr.DecodeMatter(p.Salt)
+	if err != nil || m.Code != cesr.Salt128 {
+		return nil, errors.New("invalid keystore salt")
+	}
+	var key []byte
+	switch KDF(p.Name) {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		if p.Time > maxArgon2Time || p.Memory > maxArgon2Memory || p.Threads > maxArgon2Threads {
+			return nil, fmt.Errorf("argon2id parameters time=%d memory=%d threads=%d exceed the limits", p.Time, p.Memory, p.Threads)
+		}
+		key = argon2.IDKey(passphrase, m.Raw, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+	case KDFScrypt:
+		if p.N > maxScryptN || p.R >= maxScryptRP || p.P >= maxScryptRP ||
+			int64(p.R)*int64(p.P) >= maxScryptRP || 128*int64(p.N)*int64(p.R) > maxScryptMemory {
+			return nil, fmt.Errorf("scrypt parameters n=%d r=%d p=%d exceed the limits", p.N, p.R, p.P)
+		}
+		key, err = scrypt.Key(passphrase, m.Raw, p.N, p.R, p.P, chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameters: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", p.Name)
+	}
+	return chacha20poly1305.NewX(key)
+}
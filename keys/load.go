@@ -0,0 +1,128 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+)
+
+var (
+	ErrKeyMismatch = errors.New("private key does not match the AID")
+	ErrEncrypted   = errors.New("key is an encrypted keystore, use LoadKeystore")
+)
+
+// ParsePrivateKey decodes a private key in any of the supported formats: a
+// PEM block (PKCS#8 or the legacy "ED25519 PRIVATE KEY"), PKCS#8 DER, a JWK, a
+// CESR seed, a hex seed or the raw 32-byte seed or 64-byte private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	// raw keys come first: a random seed may well look like the start of a
+	// text format, and no text format is 32 bytes or a valid 64-byte key
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		if priv := ed25519.NewKeyFromSeed(data[:ed25519.SeedSize]); bytes.Equal(priv, data) {
+			return priv, nil
+		}
+	}
+	text := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(text, "-----BEGIN"):
+		return parsePEM(data)
+	case strings.HasPrefix(text, "{"):
+		if strings.Contains(text, `"ciphertext"`) {
+			return nil, ErrEncrypted
+		}
+		return parseJWK(data)
+	case len(text) == 44 && strings.HasPrefix(text, cesr.Ed25519Seed):
+		return Parse(data, FormatCESR)
+	case len(text) == 2*ed25519.SeedSize && isHex(text):
+		return Parse(data, FormatSeed)
+	}
+	if len(data) == ed25519.PrivateKeySize {
+		return nil, errors.New("private key does not match its public key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, errors.New("unrecognized private key format")
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an Ed25519 key, got %T", key)
+	}
+	return priv, nil
+}
+
+// LoadPrivateKey reads a private key in any format ParsePrivateKey accepts.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// CheckAID returns ErrKeyMismatch unless aid is the "B" or "D" encoded public
+// key of key.
+func CheckAID(key ed25519.PrivateKey, aid string) error {
+	m, err := cesr.DecodeMatter(strings.TrimSpace(aid))
+	if err != nil {
+		return fmt.Errorf("invalid AID: %w", err)
+	}
+	if m.Code != cesr.Ed25519N && m.Code != cesr.Ed25519 {
+		return fmt.Errorf("AID %s is not an Ed25519 public key", aid)
+	}
+	if !bytes.Equal(m.Raw, key.Public().(ed25519.PublicKey)) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// NewSigner returns a Signer for key after checking that aid is its public
+// key. The signer uses the code of aid, so transferable AIDs stay "D".
+func NewSigner(key ed25519.PrivateKey, aid string) (*signer.InMemorySigner, error) {
+	aid = strings.TrimSpace(aid)
+	if err := CheckAID(key, aid); err != nil {
+		return nil, err
+	}
+	return signer.NewInMemorySigner(key, aid[:1]), nil
+}
+
+// LoadSigner loads the private key at keyPath and the AID at aidPath, such as
+// privkey.pem and pubkey.txt written by the httpsigcesr CLI, and returns a
+// signer once the two are known to match. Without aidPath the "B" AID is used.
+func LoadSigner(keyPath, aidPath string) (*signer.InMemorySigner, error) {
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if aidPath == "" {
+		return signer.NewInMemorySigner(key, cesr.Ed25519N), nil
+	}
+	aid, err := os.ReadFile(aidPath)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewSigner(key, string(aid))
+	if err != nil {
+		return nil, fmt.Errorf("%s and %s: %w", keyPath, aidPath, err)
+	}
+	return s, nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,111 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	key := testKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	inputs := map[string][]byte{
+		"legacy pem": pem.EncodeToMemory(&pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: key}),
+		"pkcs8 der":  der,
+		"raw seed":   key.Seed(),
+		"raw key":    key,
+	}
+	for _, f := range Formats {
+		encoded, err := Marshal(key, f)
+		require.NoError(t, err)
+		inputs[string(f)] = encoded
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := ParsePrivateKey(data)
+			require.NoError(t, err)
+			assert.Equal(t, key, parsed)
+		})
+	}
+
+	ks, err := Encrypt(key, []byte("secret"), fastArgon2id)
+	require.NoError(t, err)
+	_, err = ParsePrivateKey(ks)
+	assert.ErrorIs(t, err, ErrEncrypted)
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ec)
+	require.NoError(t, err)
+	_, err = ParsePrivateKey(ecDER)
+	assert.ErrorContains(t, err, "Ed25519")
+
+	_, err = ParsePrivateKey([]byte("hello"))
+	assert.Error(t, err)
+
+	// a raw seed that looks like a JWK or ends in whitespace
+	seed := make([]byte, ed25519.SeedSize)
+	_, err = rand.Read(seed)
+	require.NoError(t, err)
+	seed[0], seed[len(seed)-1] = '{', '\n'
+	jwkLike := ed25519.NewKeyFromSeed(seed)
+	for _, data := range [][]byte{seed, jwkLike} {
+		parsed, err := ParsePrivateKey(data)
+		require.NoError(t, err)
+		assert.Equal(t, jwkLike, parsed)
+	}
+
+	mismatched := append(key.Seed(), jwkLike.Public().(ed25519.PublicKey)...)
+	_, err = ParsePrivateKey(mismatched)
+	assert.ErrorContains(t, err, "does not match its public key")
+}
+
+func TestLoadSigner(t *testing.T) {
+	dir := t.TempDir()
+	key := testKey(t)
+	pkcs8, err := Marshal(key, FormatPKCS8)
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "privkey.pem")
+	require.NoError(t, os.WriteFile(keyPath, pkcs8, 0600))
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	s, err := LoadSigner(keyPath, write("pubkey.txt", AID(key, false)+"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "B", s.Code())
+	assert.Equal(t, key.Public(), s.PublicKey())
+
+	s, err = LoadSigner(keyPath, write("transferable.txt", AID(key, true)))
+	require.NoError(t, err)
+	assert.Equal(t, "D", s.Code())
+
+	s, err = LoadSigner(keyPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, "B", s.Code())
+
+	other, err := Generate()
+	require.NoError(t, err)
+	_, err = LoadSigner(keyPath, write("other.txt", AID(other, false)))
+	assert.ErrorIs(t, err, ErrKeyMismatch)
+
+	_, err = LoadSigner(keyPath, write("digest.txt", "EK8TSbn1-aGmoEBN6jbcyUmbyyXJrcESt8yak8rkHzJi"))
+	assert.ErrorContains(t, err, "not an Ed25519 public key")
+
+	_, err = LoadSigner(filepath.Join(dir, "missing.pem"), "")
+	assert.Error(t, err)
+}
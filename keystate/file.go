@@ -0,0 +1,88 @@
+package keystate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+// File resolves AIDs from a JSON file holding a list of key states:
+//
+//	[{"aid": "E...", "keys": ["D..."], "threshold": 1, "sequence": 2}]
+//
+// The file is read again when its modification time changes, so key states
+// can be updated without a restart.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	states  Static
+}
+
+// NewFile reads the key states at path.
+func NewFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Resolve(ctx context.Context, aid string) (*signature.KeyState, error) {
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	states := f.states
+	f.mu.Unlock()
+	return states.Resolve(ctx, aid)
+}
+
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.states != nil && info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	states, err := parseStates(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.states, f.modTime = states, info.ModTime()
+	return nil
+}
+
+func parseStates(data []byte) (Static, error) {
+	var list []*signature.KeyState
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	states := Static{}
+	for i, state := range list {
+		if state == nil || state.AID == "" {
+			return nil, fmt.Errorf("key state %d has no aid", i)
+		}
+		if len(state.Keys) == 0 {
+			return nil, fmt.Errorf("key state of %s has no keys", state.AID)
+		}
+		if _, ok := states[state.AID]; ok {
+			return nil, fmt.Errorf("duplicate key state for %s", state.AID)
+		}
+		states[state.AID] = state
+	}
+	return states, nil
+}
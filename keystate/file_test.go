@@ -0,0 +1,67 @@
+package keystate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatic(t *testing.T) {
+	states := Static{"Eaid": {AID: "Eaid", Keys: []string{"Dkey"}, Threshold: 1}}
+	state, err := states.Resolve(context.Background(), "Eaid")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dkey"}, state.Keys)
+
+	_, err = states.Resolve(context.Background(), "Eother")
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keystate.json")
+	write := func(content string, mtime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	now := time.Now()
+	write(`[{"aid": "Eaid", "keys": ["Dkey0"], "threshold": 1}]`, now)
+
+	f, err := NewFile(path)
+	require.NoError(t, err)
+	state, err := f.Resolve(ctx, "Eaid")
+	require.NoError(t, err)
+	assert.Equal(t, &signature.KeyState{AID: "Eaid", Keys: []string{"Dkey0"}, Threshold: 1}, state)
+
+	write(`[{"aid": "Eaid", "keys": ["Dkey1"], "threshold": 1, "sequence": 1}, {"aid": "Egone", "keys": ["Dkey"], "revoked": true}]`, now.Add(time.Second))
+	state, err = f.Resolve(ctx, "Eaid")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dkey1"}, state.Keys, "reloaded after a change")
+	state, err = f.Resolve(ctx, "Egone")
+	require.NoError(t, err)
+	assert.True(t, state.Revoked)
+
+	_, err = f.Resolve(ctx, "Eother")
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+
+	for name, content := range map[string]string{
+		"not a list": `{"aid": "Eaid"}`,
+		"no aid":     `[{"keys": ["Dkey"]}]`,
+		"no keys":    `[{"aid": "Eaid"}]`,
+		"duplicate":  `[{"aid": "Eaid", "keys": ["D1"]}, {"aid": "Eaid", "keys": ["D2"]}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			write(content, now)
+			_, err := NewFile(path)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err = NewFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package keystate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Wavecrest/httpsigcesr/keri"
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+// kelExt is the extension of the KEL files in a KELStore directory.
+const kelExt = ".cesr"
+
+// KELStore keeps validated KELs and resolves AIDs to the key state of their
+// latest event. A store backed by a directory saves each KEL as a CESR stream
+// named after its AID, e.g. "EBfdlu8R....cesr".
+type KELStore struct {
+	dir       string
+	witnessed bool
+
+	mu   sync.RWMutex
+	kels map[string]*keri.KEL
+}
+
+// StoreOption configures a KELStore.
+type StoreOption func(*KELStore)
+
+// RequireWitnessReceipts makes Resolve return the key state of the last
+// event receipted by the witness threshold of its witnesses, rather than of
+// the latest event. An AID whose inception is not witnessed yet does not
+// resolve.
+func RequireWitnessReceipts() StoreOption {
+	return func(s *KELStore) {
+		s.witnessed = true
+	}
+}
+
+// NewKELStore returns a store that saves KELs in dir and loads the ones
+// already there. An empty dir keeps the KELs in memory only.
+func NewKELStore(dir string, opts ...StoreOption) (*KELStore, error) {
+	s := &KELStore{dir: dir, kels: map[string]*keri.KEL{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if dir == "" {
+		return s, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+kelExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kel, err := keri.ParseKEL(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		aid := kel.State().Prefix
+		if filepath.Base(path) != aid+kelExt {
+			return nil, fmt.Errorf("%s holds the KEL of %s", path, aid)
+		}
+		s.kels[aid] = kel
+	}
+	return s, nil
+}
+
+// Add validates msgs and appends them to the KELs of their AIDs. Events that
+// are already in a KEL are skipped, so a whole KEL can be added again after
+// it grew, but their witness receipts are added, as are those of rct
+// messages.
+func (s *KELStore) Add(msgs ...*keri.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := map[string]bool{}
+	var err error
+	for _, m := range msgs {
+		var added bool
+		if added, err = s.add(m); err != nil {
+			break
+		}
+		if added {
+			changed[m.Event.Prefix] = true
+		}
+	}
+	for aid := range changed {
+		if saveErr := s.save(aid); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// AddStream parses a KEL stream, such as the response to an OOBI, and adds
+// its events.
+func (s *KELStore) AddStream(stream []byte) error {
+	msgs, err := keri.ParseMessages(stream)
+	if err != nil {
+		return err
+	}
+	return s.Add(msgs...)
+}
+
+func (s *KELStore) add(m *keri.Message) (bool, error) {
+	e := m.Event
+	kel, ok := s.kels[e.Prefix]
+	if !ok {
+		if e.Type == keri.Rct {
+			return false, fmt.Errorf("%w: receipt of %s, which has no KEL", keri.ErrOutOfOrder, e.Prefix)
+		}
+		kel, err := keri.NewKEL(m)
+		if err != nil {
+			return false, fmt.Errorf("KEL of %s: %w", e.Prefix, err)
+		}
+		s.kels[e.Prefix] = kel
+		return true, nil
+	}
+	if msgs := kel.Messages(); e.Sequence < uint64(len(msgs)) || e.Type == keri.Rct {
+		if e.Type != keri.Rct && msgs[e.Sequence].Event.SAID != e.SAID {
+			return false, fmt.Errorf("%w: %s conflicts with event %d of %s", keri.ErrOutOfOrder, e.SAID, e.Sequence, e.Prefix)
+		}
+		n, err := kel.AddReceipt(m)
+		return n > 0, err
+	}
+	return true, kel.Append(m)
+}
+
+func (s *KELStore) save(aid string) error {
+	if s.dir == "" {
+		return nil
+	}
+	stream, err := s.kels[aid].Bytes()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+aid+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(stream); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, aid+kelExt))
+}
+
+// KEL returns a copy of the KEL of aid, which later additions to the store do
+// not change.
+func (s *KELStore) KEL(aid string) (*keri.KEL, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kel, ok := s.kels[aid]
+	if !ok {
+		return nil, false
+	}
+	return kel.Clone(), true
+}
+
+// AIDs returns the AIDs with a KEL in the store, sorted.
+func (s *KELStore) AIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	aids := make([]string, 0, len(s.kels))
+	for aid := range s.kels {
+		aids = append(aids, aid)
+	}
+	sort.Strings(aids)
+	return aids
+}
+
+func (s *KELStore) Resolve(_ context.Context, aid string) (*signature.KeyState, error) {
+	return s.resolve(aid, s.witnessed)
+}
+
+// resolve returns the key state of aid, the witnessed state when witnessed is
+// set.
+func (s *KELStore) resolve(aid string, witnessed bool) (*signature.KeyState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kel, ok := s.kels[aid]
+	if !ok {
+		return nil, unknown(aid)
+	}
+	if !witnessed {
+		return kel.State().KeyState(), nil
+	}
+	state, err := kel.WitnessedState()
+	if err != nil {
+		return nil, err
+	}
+	return state.KeyState(), nil
+}
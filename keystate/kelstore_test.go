@@ -0,0 +1,232 @@
+package keystate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/keri"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// controller holds a single-key KEL along with the signers of its current
+// and next keys.
+type controller struct {
+	t       *testing.T
+	kel     *keri.KEL
+	current signer.Signer
+	next    signer.Signer
+}
+
+func newSigner(t *testing.T) signer.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return signer.NewInMemorySigner(priv, cesr.Ed25519)
+}
+
+func newController(t *testing.T, opts ...keri.Option) *controller {
+	c := &controller{t: t, current: newSigner(t), next: newSigner(t)}
+	next, err := keri.NextDigests(signer.AID(c.next))
+	require.NoError(t, err)
+	icp, err := keri.Incept([]string{signer.AID(c.current)}, next, opts...)
+	require.NoError(t, err)
+	m, err := keri.Sign(context.Background(), icp, c.current)
+	require.NoError(t, err)
+	c.kel, err = keri.NewKEL(m)
+	require.NoError(t, err)
+	return c
+}
+
+func (c *controller) aid() string {
+	return c.kel.State().Prefix
+}
+
+func (c *controller) rotate() *keri.Message {
+	following := newSigner(c.t)
+	next, err := keri.NextDigests(signer.AID(following))
+	require.NoError(c.t, err)
+	rot, err := keri.Rotate(c.kel.State(), []string{signer.AID(c.next)}, next)
+	require.NoError(c.t, err)
+	m, err := keri.Sign(context.Background(), rot, c.next)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.kel.Append(m))
+	c.current, c.next = c.next, following
+	return m
+}
+
+func (c *controller) interact() {
+	ixn, err := keri.Interact(c.kel.State())
+	require.NoError(c.t, err)
+	m, err := keri.SignWithKeys(context.Background(), ixn, c.kel.State().Keys, c.current)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.kel.Append(m))
+}
+
+func (c *controller) stream() []byte {
+	b, err := c.kel.Bytes()
+	require.NoError(c.t, err)
+	return b
+}
+
+func TestKELStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewKELStore(dir)
+	require.NoError(t, err)
+
+	c := newController(t)
+	_, err = store.Resolve(ctx, c.aid())
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+
+	require.NoError(t, store.AddStream(c.stream()))
+	state, err := store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	icpState := c.kel.State()
+	nextSigner := c.next
+	c.rotate()
+	require.NoError(t, store.AddStream(c.stream()), "known events are skipped")
+	state, err = store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), state.Sequence)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+	assert.Equal(t, []string{c.aid()}, store.AIDs())
+
+	// a different rotation at a known sequence number is a fork
+	rot, err := keri.Rotate(icpState, []string{signer.AID(nextSigner)}, nil)
+	require.NoError(t, err)
+	fork, err := keri.Sign(ctx, rot, nextSigner)
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.Add(fork), keri.ErrOutOfOrder)
+
+	reloaded, err := NewKELStore(dir)
+	require.NoError(t, err)
+	state, err = reloaded.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), state.Sequence)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Ewrong.cesr"), c.stream(), 0600))
+	_, err = NewKELStore(dir)
+	assert.ErrorContains(t, err, "holds the KEL of")
+}
+
+// serveWitness stands in for a KERI witness: it validates the KEL posted to
+// it and returns its receipt of the latest event.
+func serveWitness(t *testing.T) (*httptest.Server, string) {
+	w := newSigner(t)
+	store, err := NewKELStore("")
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = store.AddStream(body)
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		msgs, _ := keri.ParseMessages(body)
+		kel, _ := store.KEL(msgs[0].Event.Prefix)
+		latest := kel.Messages()[len(kel.Messages())-1].Event
+		rct, err := keri.SignReceipt(r.Context(), latest, kel.State().Witnesses, w)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+		b, _ := rct.Bytes()
+		rw.Header().Set("Content-Type", "application/json+cesr")
+		rw.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, signer.AID(w)
+}
+
+// witness sends the KEL of c to the witness at url and returns its receipt.
+func (c *controller) witness(url string) []byte {
+	resp, err := http.Post(url, "application/json+cesr", bytes.NewReader(c.stream()))
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	require.Equal(c.t, http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(c.t, err)
+	msgs, err := keri.ParseMessages(b)
+	require.NoError(c.t, err)
+	_, err = c.kel.AddReceipt(msgs[0])
+	require.NoError(c.t, err)
+	return b
+}
+
+func TestKELStoreWitnessReceipts(t *testing.T) {
+	ctx := context.Background()
+	var urls, aids []string
+	for i := 0; i < 3; i++ {
+		srv, aid := serveWitness(t)
+		urls, aids = append(urls, srv.URL), append(aids, aid)
+	}
+	c := newController(t, keri.WithWitnesses(2, aids...))
+	dir := t.TempDir()
+	store, err := NewKELStore(dir, RequireWitnessReceipts())
+	require.NoError(t, err)
+	unwitnessed, err := NewKELStore("")
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddStream(c.stream()))
+	_, err = store.Resolve(ctx, c.aid())
+	assert.ErrorIs(t, err, keri.ErrNotWitnessed)
+
+	// receipts can come attached to the events or as rct messages
+	rct := c.witness(urls[0])
+	require.NoError(t, store.AddStream(rct))
+	_, err = store.Resolve(ctx, c.aid())
+	assert.ErrorIs(t, err, keri.ErrNotWitnessed)
+	c.witness(urls[1])
+	require.NoError(t, store.AddStream(c.stream()))
+	state, err := store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	// a rotation with one receipt is not trusted yet
+	icpKey := signer.AID(c.current)
+	c.rotate()
+	c.witness(urls[2])
+	require.NoError(t, store.AddStream(c.stream()))
+	require.NoError(t, unwitnessed.AddStream(c.stream()))
+	state, err = store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{icpKey}, state.Keys)
+	state, err = unwitnessed.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	c.witness(urls[0])
+	require.NoError(t, store.AddStream(c.stream()))
+	state, err = store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), state.Sequence)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	reloaded, err := NewKELStore(dir, RequireWitnessReceipts())
+	require.NoError(t, err)
+	state, err = reloaded.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), state.Sequence)
+
+	// a witness refuses an AID it does not witness
+	other := newController(t)
+	resp, err := http.Post(urls[0], "application/json+cesr", bytes.NewReader(other.stream()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,343 @@
+package keystate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/keri"
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+// maxKELSize bounds the KEL stream read from an OOBI endpoint.
+const maxKELSize = 4 << 20
+
+// OOBI endpoint roles.
+const (
+	RoleController = "controller"
+	RoleWitness    = "witness"
+	RoleAgent      = "agent"
+)
+
+// OOBIURL is a parsed OOBI URL: {base}/oobi/{aid}[/{role}[/{eid}]].
+type OOBIURL struct {
+	URL string
+	AID string
+	// Role is the role of the endpoint, such as RoleWitness, empty when the
+	// URL does not name one.
+	Role string
+	// EID is the AID of the endpoint, such as the witness, when given.
+	EID string
+}
+
+// ParseOOBIURL parses an OOBI URL such as
+// "http://witness.example.com:5642/oobi/E.../witness/B...". The AID and EID
+// must be qb64 identifier prefixes, so a malformed OOBI is rejected before
+// it is fetched.
+func ParseOOBIURL(u string) (*OOBIURL, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("OOBI %s is not an http URL", u)
+	}
+	i := strings.Index(parsed.Path, "/oobi/")
+	if i < 0 {
+		return nil, fmt.Errorf("OOBI %s has no /oobi/ path", u)
+	}
+	parts := strings.Split(strings.Trim(parsed.Path[i+len("/oobi/"):], "/"), "/")
+	if parts[0] == "" || len(parts) > 3 {
+		return nil, fmt.Errorf("OOBI %s does not have the form /oobi/{aid}/{role}/{eid}", u)
+	}
+	o := &OOBIURL{URL: u, AID: parts[0]}
+	if len(parts) > 1 {
+		o.Role = parts[1]
+	}
+	if len(parts) > 2 {
+		o.EID = parts[2]
+	}
+	if err := checkPrefix(o.AID); err != nil {
+		return nil, fmt.Errorf("OOBI %s: AID %w", u, err)
+	}
+	if o.EID != "" {
+		if err := checkPrefix(o.EID); err != nil {
+			return nil, fmt.Errorf("OOBI %s: EID %w", u, err)
+		}
+	}
+	return o, nil
+}
+
+// checkPrefix checks that aid is a qb64 verification key or self-addressing
+// digest, the prefixes an AID can have.
+func checkPrefix(aid string) error {
+	m, err := cesr.DecodeMatter(aid)
+	if err != nil {
+		return fmt.Errorf("%s is not a CESR primitive: %w", aid, err)
+	}
+	if !cesr.VerKeyCodes[m.Code] && !cesr.DigestCodes[m.Code] {
+		return fmt.Errorf("%s has code %s, which is not an identifier prefix", aid, m.Code)
+	}
+	return nil
+}
+
+// OOBI resolves AIDs by fetching their KEL from the controller OOBI endpoint
+// of a KERI agent or witness and validating it:
+//
+//	GET {BaseURL}/oobi/{aid}/controller -> KEL as a CESR stream
+//
+// The key state is that of the last event receipted by the witness threshold
+// of its witnesses, as with a KELStore that requires witness receipts. Every
+// Resolve fetches the KEL again; OOBICache keeps the result.
+type OOBI struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (o *OOBI) Resolve(ctx context.Context, aid string) (*signature.KeyState, error) {
+	oobi := &OOBIURL{URL: o.BaseURL + "/oobi/" + url.PathEscape(aid) + "/" + RoleController, AID: aid, Role: RoleController}
+	kel, _, err := fetchKEL(ctx, o.Client, oobi)
+	if err != nil {
+		return nil, err
+	}
+	state, err := kel.WitnessedState()
+	if err != nil {
+		return nil, err
+	}
+	return state.KeyState(), nil
+}
+
+// fetchKEL reads the stream at the OOBI URL, validates the KEL of its AID and
+// returns it along with the events and their receipts. Other messages in the
+// stream, such as endpoint replies and the KELs of witnesses, are ignored. A
+// witness OOBI must name a witness of the AID.
+func fetchKEL(ctx context.Context, client *http.Client, oobi *OOBIURL) (*keri.KEL, []*keri.Message, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", oobi.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json+cesr")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, unknown(oobi.AID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OOBI %s returned %s", oobi.URL, resp.Status)
+	}
+	stream, err := io.ReadAll(io.LimitReader(resp.Body, maxKELSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stream) > maxKELSize {
+		return nil, nil, fmt.Errorf("OOBI %s: KEL exceeds %d bytes", oobi.URL, maxKELSize)
+	}
+	msgs, err := keri.ParseMessages(stream)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OOBI %s: %w", oobi.URL, err)
+	}
+	var events []*keri.Message
+	for _, m := range msgs {
+		switch m.Event.Type {
+		case keri.Icp, keri.Rot, keri.Ixn, keri.Rct:
+			if m.Event.Prefix == oobi.AID {
+				events = append(events, m)
+			}
+		}
+	}
+	if len(events) == 0 {
+		return nil, nil, fmt.Errorf("OOBI %s did not return the KEL of %s", oobi.URL, oobi.AID)
+	}
+	kel, err := keri.NewKEL(events...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OOBI %s: %w", oobi.URL, err)
+	}
+	if oobi.Role == RoleWitness && oobi.EID != "" && !contains(kel.State().Witnesses, oobi.EID) {
+		return nil, nil, fmt.Errorf("OOBI %s: %s is not a witness of %s", oobi.URL, oobi.EID, oobi.AID)
+	}
+	return kel, events, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheOption configures an OOBICache.
+type CacheOption func(*OOBICache)
+
+// WithTTL sets how long a resolved key state is used before its OOBI is
+// fetched again, and so how late a rotation can be noticed. The default is
+// five minutes.
+func WithTTL(ttl time.Duration) CacheOption {
+	return func(c *OOBICache) {
+		c.ttl = ttl
+	}
+}
+
+// WithHTTPClient sets the client that fetches OOBIs.
+func WithHTTPClient(client *http.Client) CacheOption {
+	return func(c *OOBICache) {
+		c.client = client
+	}
+}
+
+// WithAgents lets the cache resolve AIDs it was not introduced to from the
+// controller OOBIs of the agents at baseURLs, tried in order.
+func WithAgents(baseURLs ...string) CacheOption {
+	return func(c *OOBICache) {
+		c.agents = baseURLs
+	}
+}
+
+// OOBICache resolves OOBIs and keeps the validated KELs in a KELStore, so a
+// verifier can look up the key state of an AID without a request to its
+// agent or witnesses each time:
+//
+//	cache := keystate.NewOOBICache(store)
+//	_, err := cache.ResolveOOBI(ctx, "http://witness:5642/oobi/E.../witness/B...")
+//	signature.VerifyRequest(r, signature.WithKeyStateResolver(cache))
+//
+// Like OOBI, the cache resolves the key state of the last event receipted by
+// the witness threshold of its witnesses, whether or not store was built with
+// RequireWitnessReceipts. A key state older than the TTL is refreshed from the
+// OOBIs of its AID; when none of them can be fetched the error is returned
+// rather than the stale key state.
+type OOBICache struct {
+	store  *KELStore
+	client *http.Client
+	ttl    time.Duration
+	agents []string
+	now    func() time.Time
+
+	mu      sync.Mutex
+	oobis   map[string][]*OOBIURL
+	fetched map[string]time.Time
+	misses  map[string]time.Time
+}
+
+// NewOOBICache returns a cache that keeps KELs in store.
+func NewOOBICache(store *KELStore, opts ...CacheOption) *OOBICache {
+	c := &OOBICache{
+		store:   store,
+		ttl:     5 * time.Minute,
+		now:     time.Now,
+		oobis:   map[string][]*OOBIURL{},
+		fetched: map[string]time.Time{},
+		misses:  map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ResolveOOBI fetches the OOBI at u, adds the KEL to the store and remembers
+// u for refreshing the key state of its AID.
+func (c *OOBICache) ResolveOOBI(ctx context.Context, u string) (*signature.KeyState, error) {
+	oobi, err := ParseOOBIURL(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.fetch(ctx, oobi); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if !c.introduced(oobi) {
+		c.oobis[oobi.AID] = append(c.oobis[oobi.AID], oobi)
+	}
+	c.mu.Unlock()
+	return c.store.resolve(oobi.AID, true)
+}
+
+func (c *OOBICache) introduced(oobi *OOBIURL) bool {
+	for _, known := range c.oobis[oobi.AID] {
+		if known.URL == oobi.URL {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *OOBICache) fetch(ctx context.Context, oobi *OOBIURL) error {
+	_, events, err := fetchKEL(ctx, c.client, oobi)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Add(events...); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.fetched[oobi.AID] = c.now()
+	delete(c.misses, oobi.AID)
+	c.mu.Unlock()
+	return nil
+}
+
+// Resolve returns the key state of aid, fetching its OOBIs when the cached
+// state is older than the TTL. AIDs without an OOBI are looked up at the
+// agents given with WithAgents, at most once per TTL; KELs added to the store
+// directly are used when no agent knows them.
+func (c *OOBICache) Resolve(ctx context.Context, aid string) (*signature.KeyState, error) {
+	now := c.now()
+	c.mu.Lock()
+	fetched, ok := c.fetched[aid]
+	fresh := ok && now.Sub(fetched) < c.ttl
+	missed, ok := c.misses[aid]
+	recentMiss := ok && now.Sub(missed) < c.ttl
+	oobis := append([]*OOBIURL(nil), c.oobis[aid]...)
+	c.mu.Unlock()
+
+	introduced := len(oobis) > 0
+	if fresh || !introduced && (recentMiss || len(c.agents) == 0) {
+		return c.store.resolve(aid, true)
+	}
+	if !introduced {
+		for _, base := range c.agents {
+			oobis = append(oobis, &OOBIURL{URL: base + "/oobi/" + url.PathEscape(aid) + "/" + RoleController, AID: aid, Role: RoleController})
+		}
+	}
+
+	var errs []error
+	for _, oobi := range oobis {
+		err := c.fetch(ctx, oobi)
+		if err == nil {
+			return c.store.resolve(aid, true)
+		}
+		errs = append(errs, err)
+	}
+	if introduced || !isUnknown(errs) {
+		return nil, errors.Join(errs...)
+	}
+	c.mu.Lock()
+	c.misses[aid] = now
+	c.mu.Unlock()
+	return c.store.resolve(aid, true)
+}
+
+// isUnknown reports whether every OOBI reported an unknown AID.
+func isUnknown(errs []error) bool {
+	for _, err := range errs {
+		if !errors.Is(err, signature.ErrUnknownAID) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,372 @@
+package keystate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/keri"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveKELs serves the controller OOBIs of cs the way a KERI agent does.
+func serveKELs(t *testing.T, cs ...*controller) *httptest.Server {
+	mux := http.NewServeMux()
+	for _, c := range cs {
+		c := c
+		mux.HandleFunc("/oobi/"+c.aid()+"/controller", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json+cesr")
+			w.Write(c.stream())
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOOBI(t *testing.T) {
+	ctx := context.Background()
+	c := newController(t)
+	srv := serveKELs(t, c)
+	resolver := &OOBI{BaseURL: srv.URL, Client: srv.Client()}
+
+	state, err := resolver.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	c.rotate()
+	state, err = resolver.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), state.Sequence)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	_, err = resolver.Resolve(ctx, "Eunknown")
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+}
+
+func TestOOBIWitnessedState(t *testing.T) {
+	ctx := context.Background()
+	var urls, aids []string
+	for i := 0; i < 2; i++ {
+		srv, aid := serveWitness(t)
+		urls, aids = append(urls, srv.URL), append(aids, aid)
+	}
+	c := newController(t, keri.WithWitnesses(2, aids...))
+	srv := serveKELs(t, c)
+	resolver := &OOBI{BaseURL: srv.URL, Client: srv.Client()}
+
+	_, err := resolver.Resolve(ctx, c.aid())
+	assert.ErrorIs(t, err, keri.ErrNotWitnessed)
+
+	c.witness(urls[0])
+	c.witness(urls[1])
+	state, err := resolver.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	icpKeys := []string{signer.AID(c.current)}
+	assert.Equal(t, icpKeys, state.Keys)
+
+	// a rotation below the witness threshold does not replace the keys
+	c.rotate()
+	c.witness(urls[0])
+	state, err = resolver.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), state.Sequence)
+	assert.Equal(t, icpKeys, state.Keys)
+
+	c.witness(urls[1])
+	state, err = resolver.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+}
+
+func TestOOBIRejectsInvalidKEL(t *testing.T) {
+	ctx := context.Background()
+	c := newController(t)
+	other := newController(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oobi/"+c.aid()+"/controller", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(other.stream())
+	})
+	mux.HandleFunc("/oobi/Etampered/controller", func(w http.ResponseWriter, r *http.Request) {
+		stream := c.stream()
+		stream[len(stream)-1] ^= 1
+		w.Write(stream)
+	})
+	mux.HandleFunc("/oobi/Efailing/controller", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	resolver := &OOBI{BaseURL: srv.URL}
+
+	_, err := resolver.Resolve(ctx, c.aid())
+	assert.ErrorContains(t, err, "did not return the KEL of")
+	_, err = resolver.Resolve(ctx, "Etampered")
+	assert.Error(t, err)
+	_, err = resolver.Resolve(ctx, "Efailing")
+	assert.ErrorContains(t, err, "502")
+}
+
+// TestVerifyWithOOBI verifies requests signed before and after a rotation
+// against the key state served by an OOBI endpoint.
+func TestVerifyWithOOBI(t *testing.T) {
+	c := newController(t)
+	srv := serveKELs(t, c)
+	opt := signature.WithKeyStateResolver(&OOBI{BaseURL: srv.URL})
+
+	signed := func() *http.Request {
+		r, err := http.NewRequest("GET", "https://example.com/resource", nil)
+		require.NoError(t, err)
+		sd := signature.NewSignatureDataWithSigner([]string{"@method", "@path"}, c.current, signature.WithAID(c.aid()))
+		require.NoError(t, sd.SignRequest(r))
+		return r
+	}
+
+	before := signed()
+	result, err := signature.VerifyRequest(before, opt)
+	require.NoError(t, err)
+	assert.Equal(t, c.aid(), result.KeyID)
+
+	c.rotate()
+	_, err = signature.VerifyRequest(before, opt)
+	assert.ErrorIs(t, err, signature.ErrInvalidSignature, "the rotated key is no longer authoritative")
+
+	result, err = signature.VerifyRequest(signed(), opt)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), result.KeyState.Sequence)
+}
+
+func TestParseOOBIURL(t *testing.T) {
+	aid := newController(t).aid()
+	agent := newController(t).aid()
+	witness := signer.AID(newSigner(t))
+	nonTransferable := "B" + witness[1:]
+	tests := map[string]OOBIURL{
+		"http://127.0.0.1:3902/oobi/" + aid + "/controller":                           {AID: aid, Role: RoleController},
+		"http://witness.example.com:5642/oobi/" + aid + "/witness/" + nonTransferable: {AID: aid, Role: RoleWitness, EID: nonTransferable},
+		"https://keria.example.com/api/oobi/" + aid + "/agent/" + agent:               {AID: aid, Role: RoleAgent, EID: agent},
+		"https://example.com/oobi/" + witness + "/":                                   {AID: witness},
+	}
+	for u, expected := range tests {
+		oobi, err := ParseOOBIURL(u)
+		require.NoError(t, err, u)
+		expected.URL = u
+		assert.Equal(t, &expected, oobi)
+	}
+	for _, u := range []string{
+		"ftp://example.com/oobi/Eaid",
+		"https://example.com/Eaid/controller",
+		"https://example.com/oobi/",
+		"https://example.com/oobi/" + aid + "/witness/" + nonTransferable + "/extra",
+	} {
+		_, err := ParseOOBIURL(u)
+		assert.Error(t, err, u)
+	}
+
+	// the AID and EID must be identifier prefixes
+	sig, err := cesr.NewMatter(cesr.Ed25519Sig, make([]byte, 64))
+	require.NoError(t, err)
+	for _, u := range []string{
+		"https://example.com/oobi/Eaid/controller",
+		"https://example.com/oobi/" + aid[:43] + "/controller",
+		"https://example.com/oobi/" + aid + "AAAA/controller",
+		"https://example.com/oobi/" + sig.Qb64() + "/controller",
+		"https://example.com/oobi/" + aid + "/witness/Bw",
+		"https://example.com/oobi/" + aid + "/agent/" + agent[:40],
+	} {
+		_, err := ParseOOBIURL(u)
+		assert.ErrorContains(t, err, "OOBI", u)
+	}
+}
+
+// countingServer serves handler and counts the requests it gets.
+func countingServer(t *testing.T, handler http.Handler) (*httptest.Server, *int) {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func TestOOBICacheWitness(t *testing.T) {
+	ctx := context.Background()
+	s1 := newSigner(t)
+	w1, w2, w3 := signer.AID(s1), signer.AID(newSigner(t)), signer.AID(newSigner(t))
+	c := newController(t, keri.WithWitnesses(1, w1, w2))
+	icp := c.kel.Messages()[0].Event
+	rct, err := keri.SignReceipt(ctx, icp, c.kel.State().Witnesses, s1)
+	require.NoError(t, err)
+	_, err = c.kel.AddReceipt(rct)
+	require.NoError(t, err)
+
+	// a witness serves the KEL after its own endpoint reply
+	rpy := `{"v":"KERI10JSON00004b_","t":"rpy","d":"","i":"","s":"0","r":"/loc/scheme"}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oobi/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(append([]byte(rpy), c.stream()...))
+	})
+	srv, _ := countingServer(t, mux)
+
+	store, err := NewKELStore("")
+	require.NoError(t, err)
+	cache := NewOOBICache(store)
+
+	state, err := cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/witness/"+w1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+
+	_, err = cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/witness/"+w3)
+	assert.ErrorContains(t, err, "is not a witness of")
+	_, err = cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/witness/"+w2)
+	require.NoError(t, err)
+
+	other := newController(t).aid()
+	_, err = cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+other+"/controller")
+	assert.ErrorContains(t, err, "did not return the KEL of "+other)
+
+	// an unwitnessed rotation leaves the prior keys in place
+	prior := c.current
+	c.rotate()
+	store, err = NewKELStore("")
+	require.NoError(t, err)
+	state, err = NewOOBICache(store).ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/witness/"+w1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{signer.AID(prior)}, state.Keys)
+}
+
+func TestOOBICacheRefresh(t *testing.T) {
+	ctx := context.Background()
+	c := newController(t)
+	down := false
+	srv, requests := countingServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(c.stream())
+	}))
+
+	store, err := NewKELStore("")
+	require.NoError(t, err)
+	now := time.Now()
+	cache := NewOOBICache(store, WithTTL(time.Minute), WithHTTPClient(srv.Client()))
+	cache.now = func() time.Time { return now }
+
+	_, err = cache.Resolve(ctx, c.aid())
+	assert.ErrorIs(t, err, signature.ErrUnknownAID, "not introduced")
+
+	_, err = cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/controller")
+	require.NoError(t, err)
+	c.rotate()
+	c.interact()
+
+	state, err := cache.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), state.Sequence, "cached until the TTL")
+	assert.Equal(t, 1, *requests)
+
+	now = now.Add(2 * time.Minute)
+	state, err = cache.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), state.Sequence)
+	assert.Equal(t, []string{signer.AID(c.current)}, state.Keys)
+	assert.Equal(t, 2, *requests)
+
+	now = now.Add(2 * time.Minute)
+	down = true
+	_, err = cache.Resolve(ctx, c.aid())
+	assert.ErrorContains(t, err, "503", "a stale key state is not used")
+}
+
+func TestOOBICacheAgents(t *testing.T) {
+	ctx := context.Background()
+	c := newController(t)
+	srv, requests := countingServer(t, serveKELs(t, c).Config.Handler)
+
+	store, err := NewKELStore("")
+	require.NoError(t, err)
+	local := newController(t)
+	require.NoError(t, store.AddStream(local.stream()))
+	cache := NewOOBICache(store, WithAgents(srv.URL))
+
+	state, err := cache.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, c.aid(), state.AID)
+	assert.Equal(t, 1, *requests)
+
+	_, err = cache.Resolve(ctx, "Eunknown")
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+	_, err = cache.Resolve(ctx, "Eunknown")
+	assert.ErrorIs(t, err, signature.ErrUnknownAID)
+	assert.Equal(t, 2, *requests, "unknown AIDs are not looked up again within the TTL")
+
+	state, err = cache.Resolve(ctx, local.aid())
+	require.NoError(t, err, "a KEL added to the store is used when the agents do not know it")
+	assert.Equal(t, local.aid(), state.AID)
+
+	// the cache is a resolver for the verifier
+	r, err := http.NewRequest("GET", "https://example.com/resource", nil)
+	require.NoError(t, err)
+	sd := signature.NewSignatureDataWithSigner([]string{"@method", "@path"}, c.current, signature.WithAID(c.aid()))
+	require.NoError(t, sd.SignRequest(r))
+	result, err := signature.VerifyRequest(r, signature.WithKeyStateResolver(cache))
+	require.NoError(t, err)
+	assert.Equal(t, c.aid(), result.KeyID)
+}
+
+// TestOOBICacheConcurrentRefresh resolves and reads KELs from the store while
+// the cache refreshes them; run with -race.
+func TestOOBICacheConcurrentRefresh(t *testing.T) {
+	ctx := context.Background()
+	c := newController(t)
+	streams := [][]byte{c.stream()}
+	for i := 0; i < 5; i++ {
+		c.rotate()
+		streams = append(streams, c.stream())
+	}
+	var served int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&served, 1))
+		if n >= len(streams) {
+			n = len(streams) - 1
+		}
+		w.Write(streams[n])
+	}))
+	defer srv.Close()
+
+	store, err := NewKELStore("")
+	require.NoError(t, err)
+	cache := NewOOBICache(store, WithTTL(0), WithHTTPClient(srv.Client()))
+	_, err = cache.ResolveOOBI(ctx, srv.URL+"/oobi/"+c.aid()+"/controller")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := cache.Resolve(ctx, c.aid())
+				assert.NoError(t, err)
+				kel, ok := store.KEL(c.aid())
+				if assert.True(t, ok) {
+					assert.Equal(t, kel.State().Sequence, uint64(len(kel.Messages())-1))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	state, err := store.Resolve(ctx, c.aid())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), state.Sequence)
+}
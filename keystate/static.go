@@ -0,0 +1,26 @@
+// Package keystate provides signature.KeyStateResolver implementations that
+// look up the current keys of an AID in a static map, a JSON file, a local
+// store of validated KELs or an OOBI endpoint.
+package keystate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+// Static resolves AIDs from a fixed map of key states.
+type Static map[string]*signature.KeyState
+
+func (s Static) Resolve(_ context.Context, aid string) (*signature.KeyState, error) {
+	state, ok := s[aid]
+	if !ok {
+		return nil, unknown(aid)
+	}
+	return state, nil
+}
+
+func unknown(aid string) error {
+	return fmt.Errorf("%w: %s", signature.ErrUnknownAID, aid)
+}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/signature"
+)
+
+const resourceHeader = "signify-resource"
+
+var (
+	// DefaultRequiredFields are the fields covered by httpclient.CserSignedClient.
+	DefaultRequiredFields = []string{"@method", "@path", "origin-date", resourceHeader, "content-digest"}
+
+	ErrResourceMismatch = errors.New("signify-resource does not match the signing key")
+)
+
+type contextKey struct{}
+
+// ResourceFromContext returns the authenticated signify-resource AID stored by
+// Authenticate.
+func ResourceFromContext(ctx context.Context) (string, bool) {
+	aid, ok := ctx.Value(contextKey{}).(string)
+	return aid, ok
+}
+
+type config struct {
+	requiredFields []string
+	maxBodySize    int64
+	onError        func(w http.ResponseWriter, r *http.Request, status int, err error)
+	verifyOptions  []signature.VerifyOption
+	streaming      bool
+}
+
+// Option configures Authenticate.
+type Option func(*config)
+
+// WithRequiredFields overrides the fields every signature must cover.
+func WithRequiredFields(fields ...string) Option {
+	return func(c *config) {
+		c.requiredFields = fields
+	}
+}
+
+// WithMaxBodySize limits how much of the body is read to check its digest.
+// Larger bodies are rejected with 413.
+func WithMaxBodySize(n int64) Option {
+	return func(c *config) {
+		c.maxBodySize = n
+	}
+}
+
+// WithStreamingBody checks the content-digest while the handler reads the
+// body instead of buffering it first, so bodies of any size are accepted. The
+// body returns an error instead of io.EOF if it does not match the digest, so
+// handlers must not act on a body before reading it to the end. The signature
+// is only added to the replay cache once the body has matched its digest, so
+// the end of a replayed body returns signature.ErrReplayed.
+//
+// The digest may then also be sent as a trailer, as digest.AddDigestTrailer
+// does. A trailer arrives after the signature was made and is never covered by
+// it, so a trailer digest only checks the body's integrity, not its
+// authenticity: anyone who can replace the body can replace the trailer too.
+// The default required fields include content-digest and reject such
+// requests; only drop it from WithRequiredFields when that is acceptable.
+func WithStreamingBody() Option {
+	return func(c *config) {
+		c.streaming = true
+	}
+}
+
+// WithErrorHandler replaces the default handler, which writes the status text.
+func WithErrorHandler(h func(w http.ResponseWriter, r *http.Request, status int, err error)) Option {
+	return func(c *config) {
+		c.onError = h
+	}
+}
+
+// WithVerifyOptions passes additional options to signature.VerifyRequest, for
+// example a replay cache:
+//
+//	middleware.WithVerifyOptions(signature.WithReplayCache(signature.NewMemoryReplayCache()))
+func WithVerifyOptions(opts ...signature.VerifyOption) Option {
+	return func(c *config) {
+		c.verifyOptions = append(c.verifyOptions, opts...)
+	}
+}
+
+func defaultErrorHandler(w http.ResponseWriter, _ *http.Request, status int, _ error) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+// Authenticate wraps next with a handler that only lets through requests
+// signed the way httpclient.CserSignedClient signs them. The signature and the
+// content-digest are checked, and the signify-resource AID is added to the
+// request context. A replay cache given with WithVerifyOptions only records
+// requests that pass every check.
+func Authenticate(next http.Handler, opts ...Option) http.Handler {
+	cfg := &config{
+		requiredFields: DefaultRequiredFields,
+		maxBodySize:    10 << 20,
+		onError:        defaultErrorHandler,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifyOptions := append([]signature.VerifyOption{signature.WithRequiredFields(cfg.requiredFields...)}, cfg.verifyOptions...)
+		verifyOptions = append(verifyOptions, signature.WithDeferredReplay())
+		result, err := signature.VerifyRequest(r, verifyOptions...)
+		if err != nil {
+			cfg.onError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		aid := r.Header.Get(resourceHeader)
+		if aid != result.KeyID {
+			cfg.onError(w, r, http.StatusUnauthorized, ErrResourceMismatch)
+			return
+		}
+
+		// the signature is only added to the replay cache once the request is
+		// accepted, so a rejected copy does not use up the original's nonce. A
+		// streamed body is checked while the handler reads it, so it records
+		// the signature itself.
+		var streamed *recordingBody
+		_, digestTrailer := r.Trailer[http.CanonicalHeaderKey("content-digest")]
+		if cfg.streaming && (r.Header.Get("content-digest") != "" || digestTrailer) {
+			body, err := digest.NewVerifyingReader(r)
+			if err != nil {
+				cfg.onError(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			streamed = &recordingBody{VerifyingReader: body, ctx: r.Context(), result: result}
+			r.Body = streamed
+		} else if r.Header.Get("content-digest") != "" {
+			body, status, err := readBody(r, cfg.maxBodySize)
+			if err != nil {
+				cfg.onError(w, r, status, err)
+				return
+			}
+			if err := digest.VerifyDigest(r, body); err != nil {
+				cfg.onError(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if streamed == nil {
+			if err := result.Record(r.Context()); err != nil {
+				cfg.onError(w, r, http.StatusUnauthorized, err)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, aid)
+		next.ServeHTTP(w, r.WithContext(ctx))
+		if streamed != nil {
+			// a handler that returned without reading the body to the end
+			// still uses up the nonce, though it is too late to reject it
+			_ = streamed.finish()
+		}
+	})
+}
+
+// recordingBody adds the signature to the replay cache once the streamed body
+// has been read to the end and matched its digest. A body that does not match
+// leaves the nonce unused, and a replayed one returns ErrReplayed instead of
+// io.EOF.
+type recordingBody struct {
+	*digest.VerifyingReader
+	ctx    context.Context
+	result *signature.VerifiedSignature
+	done   bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.VerifyingReader.Read(p)
+	if err == io.EOF {
+		if rerr := b.finish(); rerr != nil {
+			return n, rerr
+		}
+	} else if err != nil {
+		b.done = true
+	}
+	return n, err
+}
+
+// finish records the signature unless it was already recorded or the body
+// failed to verify.
+func (b *recordingBody) finish() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	return b.result.Record(b.ctx)
+}
+
+func readBody(r *http.Request, limit int64) ([]byte, int, error) {
+	if r.Body == nil {
+		return nil, 0, nil
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if int64(len(body)) > limit {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("request body too large")
+	}
+	return body, 0, nil
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/httpclient"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aid, ok := ResourceFromContext(r.Context())
+		assert.True(t, ok)
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(aid + " " + string(body)))
+	})
+	server := httptest.NewServer(Authenticate(handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthenticateSignedClient(t *testing.T) {
+	server := newServer(t)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+
+	client := httpclient.NewCserSignedClient(aid, priv)
+	resp, err := client.SendSignedRequest(context.Background(), "POST", server.URL+"/things", map[string]int{"id": 1})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, aid+` {"id":1}`, string(body))
+}
+
+func signedRequest(t *testing.T, url string, body []byte) *http.Request {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+
+	r, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, digest.AddDigest(r, digest.DigestSha256, body, false))
+	r.Header.Set(resourceHeader, aid)
+	sd := signature.NewSignatureData(DefaultRequiredFields, aid, priv)
+	require.NoError(t, sd.SignRequest(r))
+	return r
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	server := newServer(t)
+	testCases := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{
+			name: "unsigned",
+			req: func() *http.Request {
+				r, _ := http.NewRequest("POST", server.URL, strings.NewReader("{}"))
+				return r
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered body",
+			req: func() *http.Request {
+				r := signedRequest(t, server.URL, []byte(`{"amount":1}`))
+				r.Body = io.NopCloser(strings.NewReader(`{"amount":1000}`))
+				r.ContentLength = -1
+				return r
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "resource mismatch",
+			req: func() *http.Request {
+				r := signedRequest(t, server.URL, []byte(`{}`))
+				r.Header.Set(resourceHeader, cesr.Encode(make([]byte, 32), "B"))
+				return r
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.DefaultClient.Do(tc.req())
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestAuthenticateBodyTooLarge(t *testing.T) {
+	var called bool
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}), WithMaxBodySize(4))
+
+	r := signedRequest(t, "http://example.com/upload", []byte("0123456789"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.False(t, called)
+}
+
+func TestAuthenticateReplay(t *testing.T) {
+	var lastErr error
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithVerifyOptions(signature.WithReplayCache(signature.NewMemoryReplayCache())),
+		WithErrorHandler(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			lastErr = err
+			w.WriteHeader(status)
+		}))
+
+	body := []byte(`{"amount":1}`)
+	r := signedRequest(t, "http://example.com/payments", body)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	replay := r.Clone(context.Background())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, replay)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.ErrorIs(t, lastErr, signature.ErrReplayed)
+}
+
+func TestAuthenticateStreamingBody(t *testing.T) {
+	server := httptest.NewServer(Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		fmt.Fprint(w, n)
+	}), WithStreamingBody(), WithMaxBodySize(16), WithRequiredFields("@method", "@path")))
+	defer server.Close()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+	client := httpclient.NewClient(aid, priv, httpclient.WithStreamingBody())
+
+	large := strings.Repeat("x", 1<<20)
+	resp, err := client.Post(server.URL+"/upload", "text/plain", strings.NewReader(large))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fmt.Sprint(1<<20), string(body))
+
+	// a body that can only be read once, with its digest in a trailer
+	r, err := http.NewRequest("PUT", server.URL+"/upload", io.NopCloser(io.MultiReader(strings.NewReader(large))))
+	require.NoError(t, err)
+	require.NoError(t, digest.AddDigestTrailer(r, digest.DigestSha256))
+	trailerClient := httpclient.NewClient(aid, priv, httpclient.WithStreamingBody(),
+		httpclient.WithComponents("@method", "@path", "signify-resource"))
+	resp, err = trailerClient.Do(r)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tampered := signedRequest(t, server.URL, []byte(`{"amount":1}`))
+	tampered.Body = io.NopCloser(strings.NewReader(`{"amount":1000}`))
+	tampered.ContentLength = -1
+	resp, err = http.DefaultClient.Do(tampered)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func TestAuthenticateRejectedRequestIsNotRecorded(t *testing.T) {
+	var lastErr error
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithMaxBodySize(64),
+		WithVerifyOptions(signature.WithReplayCache(signature.NewMemoryReplayCache())),
+		WithErrorHandler(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			lastErr = err
+			w.WriteHeader(status)
+		}))
+
+	body := []byte(`{"amount":1}`)
+	r := signedRequest(t, "http://example.com/payments", body)
+
+	tampered := r.Clone(context.Background())
+	tampered.Body = io.NopCloser(strings.NewReader(`{"amount":1000}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, tampered)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.ErrorIs(t, lastErr, digest.ErrDigestMismatch)
+
+	oversized := r.Clone(context.Background())
+	oversized.Body = io.NopCloser(bytes.NewReader(make([]byte, 100)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, oversized)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// the original request is still accepted, once
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	replay := r.Clone(context.Background())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, replay)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.ErrorIs(t, lastErr, signature.ErrReplayed)
+}
+
+func TestAuthenticateStreamingRecordsVerifiedBody(t *testing.T) {
+	var readErr error
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}), WithStreamingBody(),
+		WithVerifyOptions(signature.WithReplayCache(signature.NewMemoryReplayCache())))
+
+	body := []byte(`{"amount":1}`)
+	r := signedRequest(t, "http://example.com/payments", body)
+
+	tampered := r.Clone(context.Background())
+	tampered.Body = io.NopCloser(strings.NewReader(`{"amount":1000}`))
+	handler.ServeHTTP(httptest.NewRecorder(), tampered)
+	assert.ErrorIs(t, readErr, digest.ErrDigestMismatch)
+
+	// the original body is still accepted, once
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.NoError(t, readErr)
+
+	replay := r.Clone(context.Background())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	handler.ServeHTTP(httptest.NewRecorder(), replay)
+	assert.ErrorIs(t, readErr, signature.ErrReplayed)
+}
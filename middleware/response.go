@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"net/http"
+
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/Wavecrest/httpsigcesr/signer"
+)
+
+// DefaultResponseFields bind the response to the signed request it answers.
+var DefaultResponseFields = []string{"@status", "content-digest", "@method;req", "@path;req", "signature;req"}
+
+// bufferedResponse holds the status and body written by a handler until the
+// response can be signed.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// SignResponses wraps next with a handler that adds a content-digest and a
+// signature to every response. The response is buffered so the digest can be
+// computed before the headers are sent. fields default to
+// DefaultResponseFields. A request that sends Want-Content-Digest gets an
+// RFC 9530 digest with the algorithms it asks for, others get the signify
+// encoding.
+func SignResponses(next http.Handler, publicKey string, privateKey ed25519.PrivateKey, fields ...string) http.Handler {
+	return signResponses(next, signer.NewInMemorySigner(privateKey, ""), publicKey, fields)
+}
+
+// SignResponsesWithSigner is SignResponses for a key held by a Signer.
+func SignResponsesWithSigner(next http.Handler, s signer.Signer, fields ...string) http.Handler {
+	return signResponses(next, s, signer.AID(s), fields)
+}
+
+func signResponses(next http.Handler, s signer.Signer, aid string, fields []string) http.Handler {
+	if len(fields) == 0 {
+		fields = DefaultResponseFields
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bufferedResponse{header: w.Header()}
+		next.ServeHTTP(buf, r)
+		if buf.status == 0 {
+			buf.status = http.StatusOK
+		}
+
+		if w.Header().Get("content-digest") == "" {
+			if err := addResponseDigest(w, r, buf.body.Bytes()); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		sd := signature.NewSignatureDataWithSigner(fields, s, signature.WithAID(aid))
+		if err := sd.SignResponse(w.Header(), buf.status, r); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+func addResponseDigest(w http.ResponseWriter, r *http.Request, body []byte) error {
+	if r.Header.Get(digest.WantContentDigest) == "" {
+		return digest.AddDigestResponse(w, digest.DigestSha256, body, false)
+	}
+	algos := digest.Negotiate(r.Header, digest.WantContentDigest, digest.DigestSha256)
+	return digest.SetContentDigest(w.Header(), body, digest.WithAlgorithms(algos...))
+}
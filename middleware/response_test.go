@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/digest"
+	"github.com/Wavecrest/httpsigcesr/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignResponses(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	serverAID := cesr.Encode(pub, "B")
+
+	handler := SignResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}), serverAID, priv)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.DefaultClient.Do(signedRequest(t, server.URL+"/things", []byte(`{}`)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "created", string(body))
+
+	result, err := signature.VerifyResponse(resp, signature.WithKeyID(serverAID))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultResponseFields, result.Fields)
+	require.NoError(t, digest.VerifyHeaderDigest(resp.Header, body))
+
+	resp.StatusCode = http.StatusOK
+	_, err = signature.VerifyResponse(resp, signature.WithKeyID(serverAID))
+	assert.ErrorIs(t, err, signature.ErrInvalidSignature)
+}
+
+func TestSignResponsesUnsignedRequest(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	handler := SignResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), "server", priv)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestSignResponsesWantContentDigest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	serverAID := cesr.Encode(pub, "B")
+
+	handler := SignResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("negotiated"))
+	}), serverAID, priv)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	r := signedRequest(t, server.URL+"/things", nil)
+	r.Header.Set(digest.WantContentDigest, "sha-256=1, sha-512=5")
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Regexp(t, `^sha-512=:[A-Za-z0-9+/]+=*:, sha-256=:[A-Za-z0-9+/]+=*:$`, resp.Header.Get(digest.ContentDigest))
+	require.NoError(t, digest.VerifyHeaderDigest(resp.Header, body))
+	_, err = signature.VerifyResponse(resp, signature.WithKeyID(serverAID))
+	require.NoError(t, err)
+}
@@ -0,0 +1,302 @@
+package signature
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	sf "github.com/Wavecrest/httpsigcesr/structuredfields"
+)
+
+// message is the HTTP message that covered components are evaluated against.
+// For a response, request is the request it answers and is used for the
+// derived components that describe the target and for the req parameter.
+type message struct {
+	request *http.Request
+	header  http.Header
+	trailer http.Header
+	status  int
+}
+
+func requestMessage(r *http.Request) *message {
+	return &message{request: r, header: r.Header, trailer: r.Trailer}
+}
+
+func (m *message) isResponse() bool {
+	return m.status != 0
+}
+
+// context returns the context of the request, or of the request a response
+// answers.
+func (m *message) context() context.Context {
+	if m.request != nil {
+		return m.request.Context()
+	}
+	return context.Background()
+}
+
+// dictionaryFields are the structured fields known to be Dictionaries, used
+// when re-serializing with the sf parameter. Anything else is tried as a
+// Dictionary first and then as a List.
+var dictionaryFields = map[string]bool{
+	"signature":           true,
+	"signature-input":     true,
+	"accept-signature":    true,
+	"content-digest":      true,
+	"repr-digest":         true,
+	"want-content-digest": true,
+	"want-repr-digest":    true,
+	"priority":            true,
+}
+
+// parseComponent parses a component identifier. Fields may be written either
+// as a serialized Item ("@query-param";name="id") or with the name unquoted
+// (@query-param;name="id").
+func parseComponent(field string) (sf.Item, error) {
+	if !strings.HasPrefix(field, "\"") {
+		name, params, _ := strings.Cut(field, ";")
+		field = strconv.Quote(name)
+		if params != "" {
+			field += ";" + params
+		}
+	}
+	item, err := sf.ParseItem(field)
+	if err != nil {
+		return sf.Item{}, fmt.Errorf("invalid component identifier %s: %w", field, err)
+	}
+	name, ok := item.Value.(string)
+	if !ok || name == "" {
+		return sf.Item{}, fmt.Errorf("invalid component identifier %s", field)
+	}
+	if name != strings.ToLower(name) {
+		return sf.Item{}, fmt.Errorf("component name %s must be lowercase", name)
+	}
+	if name == "@signature-params" {
+		return sf.Item{}, fmt.Errorf("@signature-params cannot be a covered component")
+	}
+	return item, nil
+}
+
+func parseComponents(fields []string) ([]sf.Item, error) {
+	items := make([]sf.Item, len(fields))
+	for i, field := range fields {
+		item, err := parseComponent(field)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// componentString is the unquoted form of a component identifier, matching
+// the strings accepted by NewSignatureData.
+func componentString(c sf.Item) string {
+	params, _ := sf.SerializeParams(c.Params)
+	return c.Value.(string) + params
+}
+
+// evaluateComponent returns the value of a covered component as it appears in
+// the signature base.
+func evaluateComponent(c sf.Item, m *message) (string, error) {
+	name := c.Value.(string)
+	if _, ok := c.Params.Get("req"); ok {
+		if !m.isResponse() {
+			return "", fmt.Errorf("%s: req parameter is only valid on responses", name)
+		}
+		if m.request == nil {
+			return "", fmt.Errorf("%s: no request to evaluate the req parameter against", name)
+		}
+		m = requestMessage(m.request)
+	}
+	if strings.HasPrefix(name, "@") {
+		return evaluateDerived(name, c.Params, m)
+	}
+	return evaluateHeader(name, c.Params, m)
+}
+
+func evaluateDerived(name string, params sf.Params, m *message) (string, error) {
+	for _, p := range params {
+		if p.Key == "req" || (p.Key == "name" && name == "@query-param") {
+			continue
+		}
+		return "", fmt.Errorf("%s: unsupported parameter %s", name, p.Key)
+	}
+	if name == "@status" {
+		if !m.isResponse() {
+			return "", fmt.Errorf("@status is only valid on responses")
+		}
+		return fmt.Sprintf("%03d", m.status), nil
+	}
+	if m.isResponse() {
+		return "", fmt.Errorf("%s is not valid on responses without the req parameter", name)
+	}
+
+	r := m.request
+	switch name {
+	case "@method":
+		if r.Method == "" {
+			return http.MethodGet, nil
+		}
+		return r.Method, nil
+	case "@target-uri":
+		return fmt.Sprintf("%s://%s%s", scheme(r), authority(r), r.URL.RequestURI()), nil
+	case "@authority":
+		return authority(r), nil
+	case "@scheme":
+		return scheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		path := r.URL.EscapedPath()
+		if path == "" {
+			return "/", nil
+		}
+		return path, nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "@query-param":
+		return queryParam(params, r)
+	default:
+		return "", fmt.Errorf("unknown field %s", name)
+	}
+}
+
+func scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// authority returns the lowercased host with the default port for the scheme
+// removed.
+func authority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	host = strings.ToLower(host)
+	switch scheme(r) {
+	case "https":
+		host = strings.TrimSuffix(host, ":443")
+	case "http":
+		host = strings.TrimSuffix(host, ":80")
+	}
+	return host
+}
+
+func queryParam(params sf.Params, r *http.Request) (string, error) {
+	v, _ := params.Get("name")
+	encodedName, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("@query-param requires a name parameter")
+	}
+	name, err := url.QueryUnescape(encodedName)
+	if err != nil {
+		return "", fmt.Errorf("@query-param: invalid name %s", encodedName)
+	}
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return "", fmt.Errorf("@query-param: %w", err)
+	}
+	values, ok := query[name]
+	if !ok {
+		return "", fmt.Errorf("@query-param: no parameter named %s", encodedName)
+	}
+	if len(values) > 1 {
+		return "", fmt.Errorf("@query-param: parameter %s occurs more than once", encodedName)
+	}
+	return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+}
+
+func evaluateHeader(name string, params sf.Params, m *message) (string, error) {
+	header := m.header
+	if _, ok := params.Get("tr"); ok {
+		header = m.trailer
+	}
+	_, structured := params.Get("sf")
+	key, hasKey := params.Get("key")
+	_, byteSequence := params.Get("bs")
+	for _, p := range params {
+		switch p.Key {
+		case "sf", "key", "bs", "req", "tr":
+		default:
+			return "", fmt.Errorf("%s: unsupported parameter %s", name, p.Key)
+		}
+	}
+	if byteSequence && (structured || hasKey) {
+		return "", fmt.Errorf("%s: bs cannot be combined with sf or key", name)
+	}
+
+	values, ok := header[http.CanonicalHeaderKey(name)]
+	if !ok {
+		return "", fmt.Errorf("missing header %s", name)
+	}
+
+	if byteSequence {
+		encoded := make([]string, len(values))
+		for i, v := range values {
+			encoded[i] = ":" + base64.StdEncoding.EncodeToString([]byte(strings.TrimSpace(v))) + ":"
+		}
+		return strings.Join(encoded, ", "), nil
+	}
+
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	value := strings.Join(trimmed, ", ")
+
+	if hasKey {
+		k, ok := key.(string)
+		if !ok {
+			return "", fmt.Errorf("%s: key parameter must be a string", name)
+		}
+		dict, err := sf.ParseDictionary(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		member, ok := dict.Get(k)
+		if !ok {
+			return "", fmt.Errorf("%s: no dictionary member %s", name, k)
+		}
+		return serializeMember(member)
+	}
+	if structured {
+		return reserialize(name, value)
+	}
+	return value, nil
+}
+
+func serializeMember(m sf.Member) (string, error) {
+	switch v := m.(type) {
+	case sf.Item:
+		return sf.SerializeItem(v)
+	case sf.InnerList:
+		return sf.SerializeInnerList(v)
+	default:
+		return "", fmt.Errorf("unsupported member %T", m)
+	}
+}
+
+// reserialize returns the strict serialization of a structured field.
+func reserialize(name string, value string) (string, error) {
+	if dict, err := sf.ParseDictionary(value); err == nil {
+		return sf.SerializeDictionary(dict)
+	} else if dictionaryFields[name] {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	list, err := sf.ParseList(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return sf.SerializeList(list)
+}
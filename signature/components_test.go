@@ -0,0 +1,175 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 9421 Appendix B.2 test request.
+func testRequest(t *testing.T) *http.Request {
+	r, err := http.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	require.NoError(t, err)
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	r.Header.Set("Content-Length", "18")
+	return r
+}
+
+func TestDerivedComponents(t *testing.T) {
+	testCases := []struct {
+		url      string
+		field    string
+		expected string
+	}{
+		{"https://www.example.com/path?param=value", "@method", "POST"},
+		{"https://www.example.com/path?param=value", "@target-uri", "https://www.example.com/path?param=value"},
+		{"https://www.example.com/path?param=value", "@authority", "www.example.com"},
+		{"https://WWW.Example.com:443/path", "@authority", "www.example.com"},
+		{"http://www.example.com:8080/path", "@authority", "www.example.com:8080"},
+		{"https://www.example.com/path?param=value", "@scheme", "https"},
+		{"http://www.example.com/path?param=value", "@scheme", "http"},
+		{"https://www.example.com/path?param=value", "@request-target", "/path?param=value"},
+		{"https://www.example.com/a%20b/c", "@request-target", "/a%20b/c"},
+		{"https://www.example.com/path?param=value", "@path", "/path"},
+		{"https://www.example.com", "@path", "/"},
+		{"https://www.example.com/path?param=value&foo=bar&baz=bat%2Dtommy", "@query", "?param=value&foo=bar&baz=bat%2Dtommy"},
+		{"https://www.example.com/path?queryString", "@query", "?queryString"},
+		{"https://www.example.com/path", "@query", "?"},
+		{"https://www.example.com/path?param=value&foo=bar&baz=batman&qux=", `@query-param;name="baz"`, "batman"},
+		{"https://www.example.com/path?param=value&foo=bar&baz=batman&qux=", `@query-param;name="qux"`, ""},
+		{"https://www.example.com/path?param=value&foo=bar&baz=batman&qux=", `"@query-param";name="param"`, "value"},
+		{"https://example.com/parameters?var=this%20is%20a%20big%0Amultiline%20value&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something", `@query-param;name="var"`, "this%20is%20a%20big%0Amultiline%20value"},
+		{"https://example.com/parameters?var=this%20is%20a%20big%0Amultiline%20value&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something", `@query-param;name="bar"`, "with%20plus%20whitespace"},
+		{"https://example.com/parameters?var=this%20is%20a%20big%0Amultiline%20value&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something", `@query-param;name="fa%C3%A7ade%22%3A%20"`, "something"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.field+" "+tc.url, func(t *testing.T) {
+			r, err := http.NewRequest("POST", tc.url, nil)
+			require.NoError(t, err)
+			result, err := evaluateField(tc.field, r)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestHeaderComponents(t *testing.T) {
+	r, err := http.NewRequest("GET", "https://www.example.com/", nil)
+	require.NoError(t, err)
+	r.Header.Add("X-OWS-Header", "   Leading and trailing whitespace.   ")
+	r.Header.Add("Cache-Control", "max-age=60")
+	r.Header.Add("Cache-Control", "   must-revalidate")
+	r.Header.Add("Example-Dict", " a=1,    b=2;x=1;y=2,   c=(a   b   c)")
+	r.Header.Add("Example-Header", "value, with, lots")
+	r.Header.Add("Example-Header", "of, commas")
+	r.Header.Add("Example-List", "a,  (b c)")
+	r.Trailer = http.Header{"Expires": {"Wed, 9 Nov 2022 07:28:00 GMT"}}
+
+	testCases := []struct {
+		field    string
+		expected string
+	}{
+		{"x-ows-header", "Leading and trailing whitespace."},
+		{"cache-control", "max-age=60, must-revalidate"},
+		{"example-dict", "a=1,    b=2;x=1;y=2,   c=(a   b   c)"},
+		{"example-dict;sf", "a=1, b=2;x=1;y=2, c=(a b c)"},
+		{"example-list;sf", "a, (b c)"},
+		{`example-dict;key="a"`, "1"},
+		{`example-dict;key="b"`, "2;x=1;y=2"},
+		{`example-dict;key="c"`, "(a b c)"},
+		{"example-header;bs", ":dmFsdWUsIHdpdGgsIGxvdHM=:, :b2YsIGNvbW1hcw==:"},
+		{"expires;tr", "Wed, 9 Nov 2022 07:28:00 GMT"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.field, func(t *testing.T) {
+			result, err := evaluateField(tc.field, r)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+
+	for _, field := range []string{
+		"missing-header",
+		`example-dict;key="d"`,
+		"example-header;bs;sf",
+		"x-ows-header;unknown",
+		"@status",
+		"@path;req",
+		"@unknown",
+		"@signature-params",
+		"Content-Type",
+		"@query-param",
+		`@query-param;name="missing"`,
+	} {
+		t.Run("error "+field, func(t *testing.T) {
+			_, err := evaluateField(field, r)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSignatureBaseSelectiveCoverage(t *testing.T) {
+	// RFC 9421 Appendix B.2.2
+	input, err := parseSignatureInput(`sig-b22=("@authority" "content-digest" "@query-param";name="Pet");created=1618884473;keyid="test-key-rsa-pss";tag="header-example"`, "sig-b22")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@authority", "content-digest", `@query-param;name="Pet"`}, input.fields)
+
+	base, err := signatureBase(input.components, input.raw, requestMessage(testRequest(t)))
+	require.NoError(t, err)
+	assert.Equal(t, `"@authority": example.com
+"content-digest": sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:
+"@query-param";name="Pet": dog
+"@signature-params": ("@authority" "content-digest" "@query-param";name="Pet");created=1618884473;keyid="test-key-rsa-pss";tag="header-example"`, base)
+}
+
+func TestSignatureBaseEd25519Vector(t *testing.T) {
+	// RFC 9421 Appendix B.2.6, using test-key-ed25519 from Appendix B.1.4.
+	publicKey, _ := base64.StdEncoding.DecodeString("JrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=")
+	sig, _, err := parseSignature(`sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:`, "sig-b26")
+	require.NoError(t, err)
+	input, err := parseSignatureInput(`sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`, "sig-b26")
+	require.NoError(t, err)
+
+	base, err := signatureBase(input.components, input.raw, requestMessage(testRequest(t)))
+	require.NoError(t, err)
+	assert.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@authority": example.com
+"content-type": application/json
+"content-length": 18
+"@signature-params": ("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`, base)
+	assert.True(t, ed25519.Verify(publicKey, []byte(base), sig))
+}
+
+func TestSignatureBaseResponse(t *testing.T) {
+	// RFC 9421 Appendix B.4, binding a response to its request.
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Digest", "sha-512=:0Y6iCBzGg5rZtoXS95Ijz03mslf6KAMCloESHObfwnHJDbkkWWQz6PhhU9kxsTbARtY2PTBOzq24uJFpHsMuAg==:")
+	m := &message{request: testRequest(t), header: header, status: 503}
+
+	input, err := parseSignatureInput(`reqres=("@status" "content-digest" "content-type" "@authority";req "@method";req "@path";req "content-digest";req);created=1618884479;keyid="test-key-ecc-p256"`, "reqres")
+	require.NoError(t, err)
+	base, err := signatureBase(input.components, input.raw, m)
+	require.NoError(t, err)
+	assert.Equal(t, `"@status": 503
+"content-digest": sha-512=:0Y6iCBzGg5rZtoXS95Ijz03mslf6KAMCloESHObfwnHJDbkkWWQz6PhhU9kxsTbARtY2PTBOzq24uJFpHsMuAg==:
+"content-type": application/json
+"@authority";req: example.com
+"@method";req: POST
+"@path";req: /foo
+"content-digest";req: sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:
+"@signature-params": ("@status" "content-digest" "content-type" "@authority";req "@method";req "@path";req "content-digest";req);created=1618884479;keyid="test-key-ecc-p256"`, base)
+
+	_, err = evaluateComponent(input.components[3], requestMessage(testRequest(t)))
+	assert.Error(t, err, "req is only valid on responses")
+}
@@ -0,0 +1,39 @@
+package signature
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnknownAID = errors.New("unknown identifier")
+	ErrRevoked    = errors.New("identifier revoked")
+)
+
+// KeyState is the current key state of a KERI identifier, as established by
+// its key event log.
+type KeyState struct {
+	AID string `json:"aid"`
+	// Keys are the current signing keys, qb64 Ed25519 ("D" or "B").
+	Keys []string `json:"keys"`
+	// Threshold is the number of Keys that must sign, at least 1.
+	Threshold int `json:"threshold"`
+	// Sequence is the sequence number of the latest event.
+	Sequence uint64 `json:"sequence"`
+	// Revoked marks an identifier whose keys must no longer be trusted, such
+	// as one that was abandoned by rotating to no next keys.
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// KeyStateResolver maps an AID to its current key state. Resolve returns an
+// error wrapping ErrUnknownAID for identifiers it does not know.
+type KeyStateResolver interface {
+	Resolve(ctx context.Context, aid string) (*KeyState, error)
+}
+
+// KeyStateResolverFunc adapts a function to a KeyStateResolver.
+type KeyStateResolverFunc func(ctx context.Context, aid string) (*KeyState, error)
+
+func (f KeyStateResolverFunc) Resolve(ctx context.Context, aid string) (*KeyState, error) {
+	return f(ctx, aid)
+}
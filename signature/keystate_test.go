@@ -0,0 +1,113 @@
+package signature
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticStates map[string]*KeyState
+
+func (s staticStates) Resolve(_ context.Context, aid string) (*KeyState, error) {
+	state, ok := s[aid]
+	if !ok {
+		return nil, ErrUnknownAID
+	}
+	return state, nil
+}
+
+func signAs(t *testing.T, keyID string, priv ed25519.PrivateKey) *http.Request {
+	r, err := http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewSignatureData([]string{"@method", "@path"}, keyID, priv).SignRequest(r))
+	return r
+}
+
+func TestVerifyTransferableKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, cesr.Ed25519)
+	r := signAs(t, aid, priv)
+
+	result, err := VerifyRequest(r)
+	require.NoError(t, err, "an unrotated D key verifies without a resolver")
+	assert.Equal(t, aid, result.KeyID)
+	assert.Nil(t, result.KeyState)
+
+	// after a rotation the prefix key is no longer a signing key
+	newPub, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rotated := staticStates{aid: {AID: aid, Keys: []string{cesr.Encode(newPub, cesr.Ed25519)}, Threshold: 1, Sequence: 1}}
+	_, err = VerifyRequest(r, WithKeyStateResolver(rotated))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	result, err = VerifyRequest(signAs(t, aid, newPriv), WithKeyStateResolver(rotated))
+	require.NoError(t, err)
+	assert.Equal(t, aid, result.KeyID)
+	assert.Equal(t, newPub, result.PublicKey)
+	assert.Equal(t, uint64(1), result.KeyState.Sequence)
+}
+
+func TestVerifySelfAddressingAID(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := cesr.Encode(pub, cesr.Ed25519)
+	aid := cesr.Encode(make([]byte, 32), cesr.Blake3_256)
+	states := staticStates{aid: {AID: aid, Keys: []string{key}, Threshold: 1}}
+	r := signAs(t, aid, priv)
+
+	_, err = VerifyRequest(r)
+	assert.ErrorIs(t, err, ErrInvalidKey, "an E AID needs a resolver")
+
+	result, err := VerifyRequest(r, WithKeyStateResolver(states))
+	require.NoError(t, err)
+	assert.Equal(t, aid, result.KeyID)
+	assert.Equal(t, pub, result.PublicKey)
+
+	_, err = VerifyRequest(r, WithKeyStateResolver(staticStates{}))
+	assert.ErrorIs(t, err, ErrUnknownAID)
+
+	states[aid].Revoked = true
+	_, err = VerifyRequest(r, WithKeyStateResolver(states))
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	failing := KeyStateResolverFunc(func(context.Context, string) (*KeyState, error) {
+		return nil, errors.New("connection refused")
+	})
+	_, err = VerifyRequest(r, WithKeyStateResolver(failing))
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestVerifyResolvedThreshold(t *testing.T) {
+	signers, keys := newGroup(t, 3)
+	aid := cesr.Encode(make([]byte, 32), cesr.Blake3_256)
+	states := staticStates{aid: {AID: aid, Keys: keys, Threshold: 2}}
+
+	r, err := http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewIndexedSignatureData([]string{"@method", "@path"}, aid, signers[1:]).SignRequest(r))
+	result, err := VerifyRequest(r, WithKeyStateResolver(states))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, result.Indices)
+
+	r, err = http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewIndexedSignatureData([]string{"@method", "@path"}, aid, signers[:1]).SignRequest(r))
+	_, err = VerifyRequest(r, WithKeyStateResolver(states))
+	assert.ErrorIs(t, err, ErrInvalidSignature, "1 of 2 signatures")
+
+	// a single non-indexed signature cannot meet the threshold
+	priv := signers[0].Signer
+	r, err = http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewSignatureDataWithSigner([]string{"@method", "@path"}, priv, WithAID(aid)).SignRequest(r))
+	_, err = VerifyRequest(r, WithKeyStateResolver(states))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -0,0 +1,74 @@
+package signature
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+)
+
+// DefaultMaxSkew is the clock-skew window used with a ReplayCache when no
+// window is set with WithMaxSkew.
+const DefaultMaxSkew = 5 * time.Minute
+
+// ReplayCache remembers the signatures a verifier has accepted. Implementations
+// backed by a shared store, such as Redis, let several servers reject each
+// other's replays.
+type ReplayCache interface {
+	// Seen records key until expiry and reports whether it was already
+	// recorded and has not expired.
+	Seen(ctx context.Context, key string, expiry time.Time) (bool, error)
+}
+
+// MemoryReplayCache is a ReplayCache for a single process. Entries are
+// dropped once they expire.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	now     func() time.Time
+	sweep   time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: map[string]time.Time{}, now: time.Now}
+}
+
+func (c *MemoryReplayCache) Seen(_ context.Context, key string, expiry time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.After(c.sweep) {
+		for k, e := range c.entries {
+			if now.After(e) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweep = now.Add(time.Minute)
+	}
+
+	if e, ok := c.entries[key]; ok && !now.After(e) {
+		return true, nil
+	}
+	c.entries[key] = expiry
+	return false, nil
+}
+
+// Len returns the number of remembered signatures, including expired ones
+// that have not been swept yet.
+func (c *MemoryReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// NewNonce returns a random 128 bit nonce encoded as a CESR salt.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return cesr.Encode(b, cesr.Salt128), nil
+}
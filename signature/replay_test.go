@@ -0,0 +1,121 @@
+package signature
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signWith(t *testing.T, opts ...Option) *http.Request {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+
+	r, err := http.NewRequest("POST", "https://example.com/payments", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewSignatureData([]string{"@method", "@path"}, aid, priv, opts...).SignRequest(r))
+	return r
+}
+
+func TestSignatureParameters(t *testing.T) {
+	nonce, err := NewNonce()
+	require.NoError(t, err)
+	assert.Len(t, nonce, 24)
+	assert.Equal(t, cesr.Salt128, nonce[:2])
+
+	r := signWith(t, WithExpiry(time.Minute), WithNonce(nonce), WithTag("payments"))
+	assert.Regexp(t, `^signify=\("@method" "@path"\);created=\d+;expires=\d+;nonce="`+nonce+`";keyid="B[^"]+";alg="ed25519";tag="payments"$`,
+		r.Header.Get("signature-input"))
+
+	result, err := VerifyRequest(r, WithExpectedTag("payments"))
+	require.NoError(t, err)
+	assert.Equal(t, result.Created+60, result.Expires)
+	assert.Equal(t, nonce, result.Nonce)
+	assert.Equal(t, "payments", result.Tag)
+
+	_, err = VerifyRequest(r, WithExpectedTag("refunds"))
+	assert.ErrorIs(t, err, ErrTagMismatch)
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	r := signWith(t, WithExpiry(time.Minute))
+	now := time.Now()
+	at := func(d time.Duration) VerifyOption {
+		return WithClock(func() time.Time { return now.Add(d) })
+	}
+
+	_, err := VerifyRequest(r, WithMaxSkew(time.Minute), at(30*time.Second))
+	assert.NoError(t, err)
+
+	_, err = VerifyRequest(r, WithMaxSkew(time.Minute), at(-2*time.Minute))
+	assert.ErrorIs(t, err, ErrExpired, "created in the future")
+
+	_, err = VerifyRequest(r, WithMaxSkew(10*time.Minute), at(5*time.Minute))
+	assert.NoError(t, err, "expired within the skew window")
+
+	_, err = VerifyRequest(r, at(2*time.Minute))
+	assert.ErrorIs(t, err, ErrExpired, "expires is checked without a window")
+
+	r = signWith(t)
+	_, err = VerifyRequest(r, at(time.Hour))
+	assert.NoError(t, err, "no window, no expires")
+	_, err = VerifyRequest(r, WithMaxSkew(time.Minute), at(time.Hour))
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestVerifyReplayCache(t *testing.T) {
+	cache := NewMemoryReplayCache()
+
+	nonce, _ := NewNonce()
+	r := signWith(t, WithNonce(nonce))
+	_, err := VerifyRequest(r, WithReplayCache(cache))
+	require.NoError(t, err)
+	_, err = VerifyRequest(r, WithReplayCache(cache))
+	assert.ErrorIs(t, err, ErrReplayed)
+
+	// without a nonce the signature identifies the request
+	r = signWith(t)
+	_, err = VerifyRequest(r, WithReplayCache(cache))
+	require.NoError(t, err)
+	_, err = VerifyRequest(r, WithReplayCache(cache))
+	assert.ErrorIs(t, err, ErrReplayed)
+
+	// the default window applies with a replay cache
+	_, err = VerifyRequest(signWith(t), WithReplayCache(cache),
+		WithClock(func() time.Time { return time.Now().Add(DefaultMaxSkew + time.Minute) }))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// invalid signatures do not burn nonces
+	r = signWith(t, WithNonce(nonce+"x"))
+	r.Method = "GET"
+	_, err = VerifyRequest(r, WithReplayCache(cache))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestMemoryReplayCacheExpiry(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	seen, err := cache.Seen(ctx, "a", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, seen)
+	seen, _ = cache.Seen(ctx, "a", now.Add(time.Minute))
+	assert.True(t, seen)
+
+	now = now.Add(2 * time.Minute)
+	seen, _ = cache.Seen(ctx, "b", now.Add(time.Minute))
+	assert.False(t, seen)
+	assert.Equal(t, 1, cache.Len(), "expired entries are swept")
+	seen, _ = cache.Seen(ctx, "a", now.Add(time.Minute))
+	assert.False(t, seen)
+}
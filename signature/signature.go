@@ -1,108 +1,367 @@
 package signature
 
 import (
+	"context"
 	"crypto/ed25519"
 	"fmt"
 	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	sf "github.com/Wavecrest/httpsigcesr/structuredfields"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// Format selects how the signature header is written.
+type Format int
+
+const (
+	// FormatSignify writes the signature the way signify-ts and KERIA expect,
+	// e.g. indexed="?0";signify="0B<CESR>".
+	FormatSignify Format = iota
+	// FormatRFC9421 writes the signature as an RFC 9421 byte sequence,
+	// e.g. signify=:<base64>:.
+	FormatRFC9421
+)
+
+// IndexedSigner is one signer of a multi-key identifier together with the
+// index of its key in the identifier's current key list.
+type IndexedSigner struct {
+	Signer signer.Signer
+	Index  int
+}
+
 type SignatureData struct {
 	created         int64
 	signatureFields []string
-	privateKey      ed25519.PrivateKey
+	signer          signer.Signer
 	publicKey       string
+	format          Format
+	indexed         []IndexedSigner
+	expires         time.Duration
+	nonce           string
+	tag             string
+	label           string
+}
+
+// Option configures a SignatureData.
+type Option func(*SignatureData)
+
+// WithFormat selects the signature header format. The default is FormatSignify,
+// which KERIA and signify-ts verify.
+func WithFormat(f Format) Option {
+	return func(sd *SignatureData) {
+		sd.format = f
+	}
+}
+
+// WithAID overrides the keyid parameter, which otherwise is the public key
+// given to NewSignatureData or the signer's CESR-encoded public key.
+func WithAID(aid string) Option {
+	return func(sd *SignatureData) {
+		sd.publicKey = aid
+	}
+}
+
+// WithExpiry adds an expires parameter d after the created time.
+func WithExpiry(d time.Duration) Option {
+	return func(sd *SignatureData) {
+		sd.expires = d
+	}
+}
+
+// WithNonce adds a nonce parameter, which a verifier with a ReplayCache only
+// accepts once. See NewNonce.
+func WithNonce(nonce string) Option {
+	return func(sd *SignatureData) {
+		sd.nonce = nonce
+	}
+}
+
+// WithLabel sets the label of the signature in the signature-input and
+// signature Dictionaries. The default is "signify". Signing a message that
+// already has signatures with other labels keeps them.
+func WithLabel(label string) Option {
+	return func(sd *SignatureData) {
+		sd.label = label
+	}
+}
+
+// WithTag adds a tag parameter naming the application or protocol the
+// signature is meant for.
+func WithTag(tag string) Option {
+	return func(sd *SignatureData) {
+		sd.tag = tag
+	}
+}
+
+func NewSignatureData(fields []string, publicKey string, privateKey ed25519.PrivateKey, opts ...Option) *SignatureData {
+	return newSignatureData(fields, publicKey, signer.NewInMemorySigner(privateKey, ""), opts)
+}
+
+// NewSignatureDataWithSigner is NewSignatureData for a key held by a Signer.
+// The keyid is the signer's CESR-encoded public key.
+func NewSignatureDataWithSigner(fields []string, s signer.Signer, opts ...Option) *SignatureData {
+	return newSignatureData(fields, signer.AID(s), s, opts)
 }
 
-func NewSignatureData(fields []string, publicKey string, privateKey ed25519.PrivateKey) *SignatureData {
-	return &SignatureData{
+// NewIndexedSignatureData signs on behalf of the multi-key identifier aid,
+// such as a group AID, with each of signers. The signature header carries one
+// indexed signature per signer, e.g. indexed="?1";signify="AA<CESR>AB<CESR>",
+// regardless of the format option.
+func NewIndexedSignatureData(fields []string, aid string, signers []IndexedSigner, opts ...Option) *SignatureData {
+	sd := newSignatureData(fields, aid, nil, opts)
+	sd.indexed = signers
+	return sd
+}
+
+func newSignatureData(fields []string, publicKey string, s signer.Signer, opts []Option) *SignatureData {
+	sd := &SignatureData{
 		created:         time.Now().UTC().Unix(),
 		signatureFields: fields,
 		publicKey:       publicKey,
-		privateKey:      privateKey,
+		signer:          s,
+		label:           signifyLabel,
 	}
+	for _, opt := range opts {
+		opt(sd)
+	}
+	return sd
 }
 
+// SignatureInput returns the serialized signature parameters, or an empty
+// string if a field or the key cannot be serialized.
 func (sd *SignatureData) SignatureInput() string {
-	fieldString := ""
-	for _, field := range sd.signatureFields {
-		if fieldString == "" {
-			fieldString = fmt.Sprintf("\"%s\"", field)
-		} else {
-			fieldString = fmt.Sprintf("%s \"%s\"", fieldString, field)
-		}
+	params, err := sd.signatureParams()
+	if err != nil {
+		return ""
+	}
+	s, _ := sf.SerializeInnerList(params)
+	return s
+}
+
+func (sd *SignatureData) signatureParams() (sf.InnerList, error) {
+	components, err := parseComponents(sd.signatureFields)
+	if err != nil {
+		return sf.InnerList{}, err
+	}
+	params := sf.Params{{Key: "created", Value: sd.created}}
+	if sd.expires != 0 {
+		params = append(params, sf.Param{Key: "expires", Value: sd.created + int64(sd.expires/time.Second)})
 	}
-	return fmt.Sprintf("(%s);created=%d;keyid=\"%s\";alg=\"%s\"", fieldString, sd.created, sd.publicKey, "ed25519")
+	if sd.nonce != "" {
+		params = append(params, sf.Param{Key: "nonce", Value: sd.nonce})
+	}
+	params = append(params,
+		sf.Param{Key: "keyid", Value: sd.publicKey},
+		sf.Param{Key: "alg", Value: "ed25519"})
+	if sd.tag != "" {
+		params = append(params, sf.Param{Key: "tag", Value: sd.tag})
+	}
+	return sf.InnerList{Items: components, Params: params}, nil
 }
 
 func (sd *SignatureData) SignatureBase(r *http.Request) (string, error) {
-	fieldString := ""
-	for _, field := range sd.signatureFields {
-		value, err := sd.evaluateField(field, r)
+	return sd.signatureBase(requestMessage(r))
+}
+
+func (sd *SignatureData) signatureBase(m *message) (string, error) {
+	params, err := sd.signatureParams()
+	if err != nil {
+		return "", err
+	}
+	serialized, err := sf.SerializeInnerList(params)
+	if err != nil {
+		return "", err
+	}
+	return signatureBase(params.Items, serialized, m)
+}
+
+// signatureBase builds the signature base for the covered components, ending
+// with the given serialized signature parameters.
+func signatureBase(components []sf.Item, params string, m *message) (string, error) {
+	var sb strings.Builder
+	for _, c := range components {
+		value, err := evaluateComponent(c, m)
 		if err != nil {
 			return "", err
 		}
-		if fieldString == "" {
-			fieldString = fmt.Sprintf("\"%s\": %s", field, value)
-		} else {
-			fieldString = fmt.Sprintf("%s\n\"%s\": %s", fieldString, field, value)
+		id, err := sf.SerializeItem(c)
+		if err != nil {
+			return "", err
 		}
+		fmt.Fprintf(&sb, "%s: %s\n", id, value)
 	}
-	if fieldString != "" {
-		fieldString += "\n"
-	}
-	fieldString += fmt.Sprintf("\"@signature-params\": %s", sd.SignatureInput())
-	return fieldString, nil
+	fmt.Fprintf(&sb, "\"@signature-params\": %s", params)
+	return sb.String(), nil
 }
 
 func (sd *SignatureData) SignRequest(r *http.Request) error {
-	originDate := time.Now().UTC().Format("2006-01-02T15:04:05.000000-07:00")
-	r.Header.Add("origin-date", originDate)
+	if r.Header.Get("origin-date") == "" {
+		originDate := time.Now().UTC().Format("2006-01-02T15:04:05.000000-07:00")
+		r.Header.Set("origin-date", originDate)
+	}
+	return sd.sign(r.Context(), requestMessage(r), r.Header)
+}
 
-	s, err := sd.SignatureBase(r)
+// SignResponse signs a response before it is written. header is the response
+// header, for example w.Header(), status is the status code about to be sent
+// and req is the request being answered, used by components with the req
+// parameter.
+func (sd *SignatureData) SignResponse(header http.Header, status int, req *http.Request) error {
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
+	return sd.sign(ctx, &message{request: req, header: header, status: status}, header)
+}
+
+// sign computes the signature over m and adds the signature headers to header.
+func (sd *SignatureData) sign(ctx context.Context, m *message, header http.Header) error {
+	if sd.label == "indexed" {
+		return fmt.Errorf("label %q is reserved for signify format signatures", sd.label)
+	}
+	s, err := sd.signatureBase(m)
+	if err != nil {
+		return err
+	}
+
+	params, err := sd.signatureParams()
 	if err != nil {
 		return err
 	}
 
-	signatureInput := sd.SignatureInput()
-	signature := ed25519.Sign(sd.privateKey, []byte(s))
-	signatureCESR := cesr.Encode(signature, "0B")
+	var sigMember sf.DictMember
+	if len(sd.indexed) > 0 {
+		sigers, err := sd.signIndexed(ctx, []byte(s))
+		if err != nil {
+			return err
+		}
+		sigMember = sf.DictMember{Key: "indexed", Value: sf.Item{
+			Value:  "?1",
+			Params: sf.Params{{Key: sd.label, Value: sigers}},
+		}}
+	} else {
+		signature, err := sd.signer.Sign(ctx, []byte(s))
+		if err != nil {
+			return err
+		}
+		if sd.format == FormatSignify {
+			sigMember = sf.DictMember{Key: "indexed", Value: sf.Item{
+				Value:  "?0",
+				Params: sf.Params{{Key: sd.label, Value: cesr.Encode(signature, "0B")}},
+			}}
+		} else {
+			sigMember = sf.DictMember{Key: sd.label, Value: sf.Item{Value: signature}}
+		}
+	}
 
-	r.Header.Add("signature-input", fmt.Sprintf("signify=%s", signatureInput))
-	r.Header.Add("signature", fmt.Sprintf("indexed=\"?0\";signify=\"%s\"", signatureCESR))
+	inputs, err := headerDictionary(header, "signature-input")
+	if err != nil {
+		return err
+	}
+	sigs, err := headerDictionary(header, "signature")
+	if err != nil {
+		return err
+	}
+	inputs.Set(sd.label, params)
+	if err := setSignature(&sigs, sd.label, sigMember); err != nil {
+		return err
+	}
+
+	input, err := sf.SerializeDictionary(inputs)
+	if err != nil {
+		return err
+	}
+	sig, err := sf.SerializeDictionary(sigs)
+	if err != nil {
+		return err
+	}
+	header.Set("signature-input", input)
+	header.Set("signature", sig)
 
 	return nil
 }
 
-func (sd *SignatureData) evaluateField(field string, r *http.Request) (string, error) {
-	switch field {
-	case "@method":
-		return r.Method, nil
-	case "@path":
-		return r.URL.Path, nil
-	case "@target-uri":
-		return r.URL.RequestURI(), nil
-	case "@authority":
-		return r.URL.Host, nil
-	case "@scheme":
-		return r.URL.Scheme, nil
-	case "@request-target":
-		if len(r.URL.RawQuery) == 0 {
-			return r.URL.RawPath, nil
-		} else {
-			return fmt.Sprintf("%s?%s", r.URL.RawPath, r.URL.RawQuery), nil
+// headerDictionary parses the Dictionary in all field lines of name, so that
+// signatures already present are kept when another label is added.
+func headerDictionary(header http.Header, name string) (sf.Dictionary, error) {
+	value := strings.Join(header.Values(name), ", ")
+	if value == "" {
+		return nil, nil
+	}
+	dict, err := sf.ParseDictionary(value)
+	if err != nil {
+		return nil, fmt.Errorf("existing %s header: %w", name, err)
+	}
+	return dict, nil
+}
+
+// setSignature stores the signature for label in sigs, replacing an earlier
+// signature with the same label. Signify format signatures of all labels share
+// the indexed member, one parameter per label.
+func setSignature(sigs *sf.Dictionary, label string, member sf.DictMember) error {
+	if existing, ok := sigs.Get("indexed"); ok {
+		if item, ok := existing.(sf.Item); ok {
+			params := sf.Params{}
+			for _, p := range item.Params {
+				if p.Key != label {
+					params = append(params, p)
+				}
+			}
+			item.Params = params
+			if len(params) == 0 {
+				sigs.Delete("indexed")
+			} else {
+				sigs.Set("indexed", item)
+			}
 		}
-	case "@query":
-		if len(r.URL.RawQuery) == 0 {
-			return "", nil
+	}
+	sigs.Delete(label)
+
+	if member.Key != "indexed" {
+		sigs.Set(member.Key, member.Value)
+		return nil
+	}
+	item := member.Value.(sf.Item)
+	if existing, ok := sigs.Get("indexed"); ok {
+		existingItem, ok := existing.(sf.Item)
+		if !ok || existingItem.Value != item.Value {
+			return fmt.Errorf("signature %s cannot share the indexed member with existing signatures", label)
 		}
-		return fmt.Sprintf("?%s", r.URL.RawQuery), nil
-	default:
-		if strings.HasPrefix(field, "@") {
-			return "", fmt.Errorf("unknown field %s", field)
+		existingItem.Params = append(existingItem.Params, item.Params...)
+		item = existingItem
+	}
+	sigs.Set("indexed", item)
+	return nil
+}
+
+// signIndexed signs base with every indexed signer and returns the
+// concatenated qb64 indexed signatures.
+func (sd *SignatureData) signIndexed(ctx context.Context, base []byte) (string, error) {
+	var sb strings.Builder
+	for _, is := range sd.indexed {
+		signature, err := is.Signer.Sign(ctx, base)
+		if err != nil {
+			return "", err
 		}
-		return r.Header.Get(field), nil
+		siger, err := cesr.NewIndexer(cesr.Ed25519IdxSig, is.Index, is.Index, signature)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(siger.Qb64())
+	}
+	return sb.String(), nil
+}
+
+func (sd *SignatureData) evaluateField(field string, r *http.Request) (string, error) {
+	return evaluateField(field, r)
+}
+
+func evaluateField(field string, r *http.Request) (string, error) {
+	c, err := parseComponent(field)
+	if err != nil {
+		return "", err
 	}
+	return evaluateComponent(c, requestMessage(r))
 }
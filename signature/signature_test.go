@@ -2,6 +2,7 @@ package signature
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -44,6 +45,7 @@ func Test_evaulateField(t *testing.T) {
 		sd := NewSignatureData([]string{"@method", "@path", "origin-date", "signify-resource"}, "public", nil)
 		r, _ := http.NewRequest("GET", "/", nil)
 		r.Header.Add("origin-date", "2021-04-20T20:21:15Z")
+		r.Header.Add("signify-resource", "public")
 		result, err := sd.evaluateField(tc.field, r)
 
 		if err != nil {
@@ -51,3 +53,29 @@ func Test_evaulateField(t *testing.T) {
 		}
 	}
 }
+
+func TestSignRequestHeaders(t *testing.T) {
+	sd := NewSignatureData([]string{"@method", "@path"}, "public", make([]byte, 64))
+	sd.created = 1618884475
+	r, _ := http.NewRequest("GET", "/", nil)
+	if err := sd.SignRequest(r); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedInput := "signify=(\"@method\" \"@path\");created=1618884475;keyid=\"public\";alg=\"ed25519\""
+	if input := r.Header.Get("signature-input"); input != expectedInput {
+		t.Errorf("Expected: %s, Got: %s", expectedInput, input)
+	}
+	if sig := r.Header.Get("signature"); !strings.HasPrefix(sig, `indexed="?0";signify="0B`) {
+		t.Errorf("Expected a signify format signature, Got: %s", sig)
+	}
+
+	sd = NewSignatureData([]string{"@method", "@path"}, "public", make([]byte, 64), WithFormat(FormatRFC9421))
+	r, _ = http.NewRequest("GET", "/", nil)
+	if err := sd.SignRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	if sig := r.Header.Get("signature"); !strings.HasPrefix(sig, "signify=:") || !strings.HasSuffix(sig, ":") {
+		t.Errorf("Expected an RFC 9421 byte sequence, Got: %s", sig)
+	}
+}
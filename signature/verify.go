@@ -0,0 +1,659 @@
+package signature
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	sf "github.com/Wavecrest/httpsigcesr/structuredfields"
+)
+
+const signifyLabel = "signify"
+
+var (
+	ErrMissingSignature     = errors.New("missing signature")
+	ErrMalformedSignature   = errors.New("malformed signature")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrMissingField         = errors.New("required field not covered by signature")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrExpired              = errors.New("signature expired or outside the clock-skew window")
+	ErrReplayed             = errors.New("signature replayed")
+	ErrTagMismatch          = errors.New("unexpected signature tag")
+)
+
+// VerificationError is returned by VerifyRequest. Kind is one of the Err*
+// values above, so callers can match it with errors.Is.
+type VerificationError struct {
+	Kind   error
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	if e.Reason == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Reason)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Kind
+}
+
+func verificationError(kind error, format string, args ...interface{}) error {
+	return &VerificationError{Kind: kind, Reason: fmt.Sprintf(format, args...)}
+}
+
+// VerifiedSignature describes a signature that was successfully verified.
+type VerifiedSignature struct {
+	Label     string
+	Fields    []string
+	Created   int64
+	KeyID     string
+	Alg       string
+	Expires   int64
+	Nonce     string
+	Tag       string
+	PublicKey ed25519.PublicKey
+	// Indices lists the key indexes with a valid indexed signature. It is
+	// only set for indexed signatures, in which case PublicKey is nil.
+	Indices []int
+	// KeyState is the resolved key state of a transferable keyid. It is nil
+	// for non-transferable keys and when no KeyStateResolver is configured.
+	KeyState *KeyState
+
+	// replay is the replay cache entry left to Record by WithDeferredReplay.
+	replay *replayEntry
+}
+
+type replayEntry struct {
+	cache  ReplayCache
+	key    string
+	expiry time.Time
+	keyID  string
+}
+
+// Record adds a signature verified with WithDeferredReplay to the replay
+// cache, and rejects it with ErrReplayed if it was already there. It does
+// nothing for other signatures.
+func (v *VerifiedSignature) Record(ctx context.Context) error {
+	if v.replay == nil {
+		return nil
+	}
+	return v.replay.record(ctx)
+}
+
+type verifyConfig struct {
+	requiredFields []string
+	keyID          string
+	signingKeys    []string
+	threshold      int
+	maxSkew        time.Duration
+	replayCache    ReplayCache
+	deferReplay    bool
+	tag            string
+	now            func() time.Time
+	label          string
+	resolver       KeyStateResolver
+}
+
+// VerifyOption configures VerifyRequest.
+type VerifyOption func(*verifyConfig)
+
+// WithRequiredFields rejects signatures that do not cover all of the given fields.
+func WithRequiredFields(fields ...string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.requiredFields = append(c.requiredFields, fields...)
+	}
+}
+
+// WithKeyID rejects signatures made with any key other than keyID, such as the
+// known AID of a server.
+func WithKeyID(keyID string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.keyID = keyID
+	}
+}
+
+// WithSigningKeys verifies indexed signatures against the current signing keys
+// of a multi-key identifier. At least threshold distinct keys must have signed.
+func WithSigningKeys(threshold int, keys ...string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.threshold = threshold
+		c.signingKeys = keys
+	}
+}
+
+// WithMaxSkew rejects signatures created more than d before or after the
+// current time, and signatures whose expires time is more than d in the past.
+func WithMaxSkew(d time.Duration) VerifyOption {
+	return func(c *verifyConfig) {
+		c.maxSkew = d
+	}
+}
+
+// WithReplayCache rejects signatures already recorded in cache. The nonce
+// identifies a signature if there is one, otherwise the signature itself does.
+// Signatures are remembered for as long as they pass the clock-skew check,
+// which defaults to DefaultMaxSkew when WithMaxSkew is not given.
+func WithReplayCache(cache ReplayCache) VerifyOption {
+	return func(c *verifyConfig) {
+		c.replayCache = cache
+	}
+}
+
+// WithDeferredReplay leaves adding the signature to the replay cache to
+// VerifiedSignature.Record, for callers that check more of the message after
+// the signature, such as its content-digest. A message rejected by those
+// checks then does not use up the nonce of the original.
+func WithDeferredReplay() VerifyOption {
+	return func(c *verifyConfig) {
+		c.deferReplay = true
+	}
+}
+
+// WithExpectedTag rejects signatures without the given tag parameter.
+func WithExpectedTag(tag string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.tag = tag
+	}
+}
+
+// WithExpectedLabel verifies the signature with the given label instead of
+// "signify".
+func WithExpectedLabel(label string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.label = label
+	}
+}
+
+// WithKeyStateResolver looks up the current signing keys of transferable
+// keyids: "D" keys, which may have been rotated, and self-addressing "E" AIDs,
+// which cannot be verified without it. Without a resolver a "D" keyid is
+// trusted as its own signing key. WithSigningKeys takes precedence for indexed
+// signatures.
+func WithKeyStateResolver(r KeyStateResolver) VerifyOption {
+	return func(c *verifyConfig) {
+		c.resolver = r
+	}
+}
+
+// WithClock replaces time.Now for the clock-skew and expiry checks.
+func WithClock(now func() time.Time) VerifyOption {
+	return func(c *verifyConfig) {
+		c.now = now
+	}
+}
+
+// VerifyRequest checks the signify signature produced by SignRequest. It
+// parses the signature-input and signature headers, rebuilds the signature
+// base from the covered fields and verifies it against the key in keyid.
+// Signatures in both FormatRFC9421 and FormatSignify are accepted.
+func VerifyRequest(r *http.Request, opts ...VerifyOption) (*VerifiedSignature, error) {
+	return verify(requestMessage(r), opts)
+}
+
+// VerifyResponse checks a signature made with SignResponse. resp.Request must
+// be set if the signature covers components with the req parameter.
+func VerifyResponse(resp *http.Response, opts ...VerifyOption) (*VerifiedSignature, error) {
+	return verify(&message{request: resp.Request, header: resp.Header, trailer: resp.Trailer, status: resp.StatusCode}, opts)
+}
+
+// VerifyRequestAll verifies every signature of r, for example the caller's
+// signature and that of a gateway which forwarded the request. All signatures
+// must pass the checks configured by opts; WithExpectedLabel is ignored.
+func VerifyRequestAll(r *http.Request, opts ...VerifyOption) ([]*VerifiedSignature, error) {
+	return verifyAll(requestMessage(r), opts)
+}
+
+// VerifyResponseAll verifies every signature of resp.
+func VerifyResponseAll(resp *http.Response, opts ...VerifyOption) ([]*VerifiedSignature, error) {
+	return verifyAll(&message{request: resp.Request, header: resp.Header, trailer: resp.Trailer, status: resp.StatusCode}, opts)
+}
+
+func newVerifyConfig(opts []VerifyOption) *verifyConfig {
+	cfg := &verifyConfig{now: time.Now, label: signifyLabel}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.replayCache != nil && cfg.maxSkew == 0 {
+		cfg.maxSkew = DefaultMaxSkew
+	}
+	return cfg
+}
+
+func verify(m *message, opts []VerifyOption) (*VerifiedSignature, error) {
+	cfg := newVerifyConfig(opts)
+	return verifyLabel(m, cfg, cfg.label)
+}
+
+func verifyAll(m *message, opts []VerifyOption) ([]*VerifiedSignature, error) {
+	cfg := newVerifyConfig(opts)
+	inputHeader := strings.Join(m.header.Values("signature-input"), ", ")
+	if inputHeader == "" {
+		return nil, verificationError(ErrMissingSignature, "signature-input and signature headers are required")
+	}
+	dict, err := sf.ParseDictionary(inputHeader)
+	if err != nil {
+		return nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+
+	var results []*VerifiedSignature
+	for _, label := range dict.Keys() {
+		result, err := verifyLabel(m, cfg, label)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", label, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func verifyLabel(m *message, cfg *verifyConfig, label string) (*VerifiedSignature, error) {
+	inputHeader := strings.Join(m.header.Values("signature-input"), ", ")
+	sigHeader := strings.Join(m.header.Values("signature"), ", ")
+	if inputHeader == "" || sigHeader == "" {
+		return nil, verificationError(ErrMissingSignature, "signature-input and signature headers are required")
+	}
+
+	input, err := parseSignatureInput(inputHeader, label)
+	if err != nil {
+		return nil, err
+	}
+	for _, required := range cfg.requiredFields {
+		if !containsField(input.fields, required) {
+			return nil, verificationError(ErrMissingField, "%s", required)
+		}
+	}
+	if input.alg != "" && input.alg != "ed25519" {
+		return nil, verificationError(ErrUnsupportedAlgorithm, "%s", input.alg)
+	}
+
+	if cfg.keyID != "" && input.keyID != cfg.keyID {
+		return nil, verificationError(ErrInvalidKey, "expected keyid %s, got %s", cfg.keyID, input.keyID)
+	}
+	if cfg.tag != "" && input.tag != cfg.tag {
+		return nil, verificationError(ErrTagMismatch, "expected tag %q, got %q", cfg.tag, input.tag)
+	}
+	if err := checkTimes(cfg, input); err != nil {
+		return nil, err
+	}
+
+	sig, sigers, err := parseSignature(sigHeader, label)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := signatureBase(input.components, input.raw, m)
+	if err != nil {
+		return nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+
+	result := &VerifiedSignature{
+		Label:   label,
+		Fields:  input.fields,
+		Created: input.created,
+		KeyID:   input.keyID,
+		Alg:     input.alg,
+		Expires: input.expires,
+		Nonce:   input.nonce,
+		Tag:     input.tag,
+	}
+	state, err := resolveKeyState(m, cfg, input.keyID)
+	if err != nil {
+		return nil, err
+	}
+	result.KeyState = state
+	if sigers != nil {
+		keys, threshold := cfg.signingKeys, cfg.threshold
+		if len(keys) == 0 && state != nil {
+			keys, threshold = state.Keys, state.Threshold
+		}
+		if result.Indices, err = verifyIndexed(keys, threshold, []byte(base), sigers); err != nil {
+			return nil, err
+		}
+	} else {
+		publicKey, err := verifySingle(input.keyID, state, []byte(base), sig)
+		if err != nil {
+			return nil, err
+		}
+		result.PublicKey = publicKey
+	}
+
+	if cfg.replayCache != nil {
+		for _, siger := range sigers {
+			sig = append(sig, siger.Raw...)
+		}
+		entry := newReplayEntry(cfg, input, sig)
+		if cfg.deferReplay {
+			result.replay = entry
+		} else if err := entry.record(m.context()); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// checkTimes applies the clock-skew window to created and expires.
+func checkTimes(cfg *verifyConfig, input *signatureInput) error {
+	now := cfg.now().Unix()
+	skew := int64(cfg.maxSkew / time.Second)
+	if input.expires != 0 && input.expires+skew < now {
+		return verificationError(ErrExpired, "expired at %d", input.expires)
+	}
+	if cfg.maxSkew == 0 {
+		return nil
+	}
+	if input.created < now-skew || input.created > now+skew {
+		return verificationError(ErrExpired, "created at %d, outside %s of %d", input.created, cfg.maxSkew, now)
+	}
+	return nil
+}
+
+// newReplayEntry identifies the signature in the replay cache until it would
+// fail checkTimes anyway.
+func newReplayEntry(cfg *verifyConfig, input *signatureInput, sig []byte) *replayEntry {
+	key := input.keyID + " nonce " + input.nonce
+	if input.nonce == "" {
+		key = input.keyID + " signature " + base64.StdEncoding.EncodeToString(sig)
+	}
+	expiry := input.created + int64(cfg.maxSkew/time.Second)
+	if input.expires != 0 && input.expires+int64(cfg.maxSkew/time.Second) < expiry {
+		expiry = input.expires + int64(cfg.maxSkew/time.Second)
+	}
+	return &replayEntry{cache: cfg.replayCache, key: key, expiry: time.Unix(expiry, 0), keyID: input.keyID}
+}
+
+// record adds the entry to the replay cache and rejects it if it was already
+// recorded.
+func (e *replayEntry) record(ctx context.Context) error {
+	seen, err := e.cache.Seen(ctx, e.key, e.expiry)
+	if err != nil {
+		return err
+	}
+	if seen {
+		return verificationError(ErrReplayed, "keyid %s", e.keyID)
+	}
+	return nil
+}
+
+// resolveKeyState returns the key state of a transferable keyid, or nil if
+// the keyid is its own signing key.
+func resolveKeyState(m *message, cfg *verifyConfig, keyID string) (*KeyState, error) {
+	transferable := strings.HasPrefix(keyID, cesr.Ed25519)
+	selfAddressing := strings.HasPrefix(keyID, cesr.Blake3_256)
+	if !transferable && !selfAddressing {
+		return nil, nil
+	}
+	if cfg.resolver == nil {
+		if selfAddressing && len(cfg.signingKeys) == 0 {
+			return nil, verificationError(ErrInvalidKey, "keyid %s needs a key state resolver", keyID)
+		}
+		return nil, nil
+	}
+	ctx := context.Background()
+	if m.request != nil {
+		ctx = m.request.Context()
+	}
+	state, err := cfg.resolver.Resolve(ctx, keyID)
+	if errors.Is(err, ErrUnknownAID) {
+		return nil, verificationError(ErrUnknownAID, "%s", keyID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolving key state of %s: %w", keyID, err)
+	}
+	if state.Revoked {
+		return nil, verificationError(ErrRevoked, "%s", keyID)
+	}
+	if len(state.Keys) == 0 {
+		return nil, verificationError(ErrInvalidKey, "%s has no current signing keys", keyID)
+	}
+	return state, nil
+}
+
+// verifySingle checks a non-indexed signature against the key in keyid or,
+// for a resolved keyid, against its current signing keys. A single signature
+// cannot meet a threshold above 1.
+func verifySingle(keyID string, state *KeyState, base []byte, sig []byte) (ed25519.PublicKey, error) {
+	if state == nil {
+		publicKey, err := decodePublicKey(keyID)
+		if err != nil {
+			return nil, err
+		}
+		if !ed25519.Verify(publicKey, base, sig) {
+			return nil, verificationError(ErrInvalidSignature, "signature does not match key %s", keyID)
+		}
+		return publicKey, nil
+	}
+	if state.Threshold > 1 {
+		return nil, verificationError(ErrInvalidSignature, "%s requires %d indexed signatures", keyID, state.Threshold)
+	}
+	for _, key := range state.Keys {
+		publicKey, err := decodeSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if ed25519.Verify(publicKey, base, sig) {
+			return publicKey, nil
+		}
+	}
+	return nil, verificationError(ErrInvalidSignature, "signature does not match the current keys of %s", keyID)
+}
+
+// verifyIndexed checks every indexed signature against the signing key at its
+// index and returns the indexes once the threshold is met.
+func verifyIndexed(keys []string, threshold int, base []byte, sigers []*cesr.Indexer) ([]int, error) {
+	if len(keys) == 0 {
+		return nil, verificationError(ErrInvalidKey, "indexed signatures require the signing keys")
+	}
+	seen := map[int]bool{}
+	var indices []int
+	for _, siger := range sigers {
+		if siger.Index >= len(keys) {
+			return nil, verificationError(ErrInvalidKey, "no signing key at index %d", siger.Index)
+		}
+		key := keys[siger.Index]
+		publicKey, err := decodeSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ed25519.Verify(publicKey, base, siger.Raw) {
+			return nil, verificationError(ErrInvalidSignature, "signature does not match key %s", key)
+		}
+		if !seen[siger.Index] {
+			seen[siger.Index] = true
+			indices = append(indices, siger.Index)
+		}
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	if len(indices) < threshold {
+		return nil, verificationError(ErrInvalidSignature, "%d of %d required signatures", len(indices), threshold)
+	}
+	return indices, nil
+}
+
+type signatureInput struct {
+	raw        string
+	components []sf.Item
+	fields     []string
+	created    int64
+	expires    int64
+	nonce      string
+	keyID      string
+	alg        string
+	tag        string
+}
+
+// parseSignatureInput extracts the member with the given label from a
+// signature-input Dictionary.
+func parseSignatureInput(header string, label string) (*signatureInput, error) {
+	dict, err := sf.ParseDictionary(header)
+	if err != nil {
+		return nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+	m, ok := dict.Get(label)
+	if !ok {
+		return nil, verificationError(ErrMissingSignature, "no %s signature input", label)
+	}
+	list, ok := m.(sf.InnerList)
+	if !ok {
+		return nil, verificationError(ErrMalformedSignature, "signature input must be an inner list")
+	}
+	raw, err := sf.SerializeInnerList(list)
+	if err != nil {
+		return nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+
+	input := &signatureInput{raw: raw}
+	for _, item := range list.Items {
+		if _, ok := item.Value.(string); !ok {
+			return nil, verificationError(ErrMalformedSignature, "covered fields must be strings")
+		}
+		input.components = append(input.components, item)
+		input.fields = append(input.fields, componentString(item))
+	}
+	created, _ := list.Params.Get("created")
+	if input.created, ok = created.(int64); !ok {
+		return nil, verificationError(ErrMalformedSignature, "bad created parameter")
+	}
+	keyID, _ := list.Params.Get("keyid")
+	if input.keyID, ok = keyID.(string); !ok {
+		return nil, verificationError(ErrMalformedSignature, "bad keyid parameter")
+	}
+	if alg, ok := list.Params.Get("alg"); ok {
+		if input.alg, ok = alg.(string); !ok {
+			return nil, verificationError(ErrMalformedSignature, "bad alg parameter")
+		}
+	}
+	if expires, ok := list.Params.Get("expires"); ok {
+		if input.expires, ok = expires.(int64); !ok {
+			return nil, verificationError(ErrMalformedSignature, "bad expires parameter")
+		}
+	}
+	if nonce, ok := list.Params.Get("nonce"); ok {
+		if input.nonce, ok = nonce.(string); !ok {
+			return nil, verificationError(ErrMalformedSignature, "bad nonce parameter")
+		}
+	}
+	if tag, ok := list.Params.Get("tag"); ok {
+		if input.tag, ok = tag.(string); !ok {
+			return nil, verificationError(ErrMalformedSignature, "bad tag parameter")
+		}
+	}
+	return input, nil
+}
+
+// parseSignature extracts the signature with the given label from a signature
+// Dictionary, written in either FormatRFC9421 or FormatSignify. Indexed
+// signatures (indexed="?1") are returned as sigers instead.
+func parseSignature(header string, label string) (sig []byte, sigers []*cesr.Indexer, err error) {
+	dict, err := sf.ParseDictionary(header)
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+	if m, ok := dict.Get(label); ok {
+		item, ok := m.(sf.Item)
+		if !ok {
+			return nil, nil, verificationError(ErrMalformedSignature, "signature must be an item")
+		}
+		sig, ok := item.Value.([]byte)
+		if !ok {
+			return nil, nil, verificationError(ErrMalformedSignature, "signature must be a byte sequence")
+		}
+		return sig, nil, nil
+	}
+	if m, ok := dict.Get("indexed"); ok {
+		if item, ok := m.(sf.Item); ok {
+			if v, ok := item.Params.Get(label); ok {
+				s, _ := v.(string)
+				if item.Value == "?1" {
+					sigers, err := decodeIndexedSignatures(s)
+					return nil, sigers, err
+				}
+				sig, err := decodeSignature(s)
+				return sig, nil, err
+			}
+		}
+	}
+	return nil, nil, verificationError(ErrMissingSignature, "no %s signature", label)
+}
+
+// decodePublicKey decodes a keyid that is its own signing key: a
+// non-transferable "B" key, or a transferable "D" key not yet rotated.
+func decodePublicKey(keyID string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(keyID, cesr.Ed25519N) && !strings.HasPrefix(keyID, cesr.Ed25519) {
+		return nil, verificationError(ErrInvalidKey, "unsupported keyid %q", keyID)
+	}
+	raw, err := cesr.Decode(keyID)
+	if err != nil {
+		return nil, verificationError(ErrInvalidKey, "%s", err)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// decodeSigningKey decodes a transferable or non-transferable Ed25519 key from
+// the key list of a multi-key identifier.
+func decodeSigningKey(key string) (ed25519.PublicKey, error) {
+	m, err := cesr.DecodeMatter(key)
+	if err != nil {
+		return nil, verificationError(ErrInvalidKey, "%s", err)
+	}
+	if m.Code != cesr.Ed25519 && m.Code != cesr.Ed25519N {
+		return nil, verificationError(ErrInvalidKey, "unsupported signing key %q", key)
+	}
+	return ed25519.PublicKey(m.Raw), nil
+}
+
+func decodeSignature(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0B") {
+		return nil, verificationError(ErrUnsupportedAlgorithm, "unsupported signature code %.2s", s)
+	}
+	raw, err := cesr.Decode(s)
+	if err != nil {
+		return nil, verificationError(ErrMalformedSignature, "%s", err)
+	}
+	return raw, nil
+}
+
+// decodeIndexedSignatures splits concatenated qb64 indexed signatures.
+func decodeIndexedSignatures(s string) ([]*cesr.Indexer, error) {
+	if s == "" {
+		return nil, verificationError(ErrMissingSignature, "no indexed signatures")
+	}
+	var sigers []*cesr.Indexer
+	for s != "" {
+		siger, n, err := cesr.ParseIndexer(s)
+		if err != nil {
+			return nil, verificationError(ErrMalformedSignature, "%s", err)
+		}
+		if siger.Code != cesr.Ed25519IdxSig && siger.Code != cesr.Ed25519CrtSig &&
+			siger.Code != cesr.Ed25519BigIdxSig && siger.Code != cesr.Ed25519BigCrtSig {
+			return nil, verificationError(ErrUnsupportedAlgorithm, "unsupported indexed signature code %s", siger.Code)
+		}
+		sigers = append(sigers, siger)
+		s = s[n:]
+	}
+	return sigers, nil
+}
+
+// containsField reports whether field is one of fields, comparing the
+// canonical form of the component identifiers.
+func containsField(fields []string, field string) bool {
+	if c, err := parseComponent(field); err == nil {
+		field = componentString(c)
+	}
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
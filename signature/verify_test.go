@@ -0,0 +1,281 @@
+package signature
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/Wavecrest/httpsigcesr/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSignedRequest(t *testing.T, opts ...Option) (*http.Request, string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+
+	r, err := http.NewRequest("POST", "https://example.com/api/things?x=1", nil)
+	require.NoError(t, err)
+	r.Header.Set("signify-resource", aid)
+
+	sd := NewSignatureData([]string{"@method", "@path", "origin-date", "signify-resource"}, aid, priv, opts...)
+	require.NoError(t, sd.SignRequest(r))
+	return r, aid
+}
+
+func TestVerifyRequest(t *testing.T) {
+	r, aid := newSignedRequest(t)
+
+	result, err := VerifyRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "signify", result.Label)
+	assert.Equal(t, aid, result.KeyID)
+	assert.Equal(t, "ed25519", result.Alg)
+	assert.Equal(t, []string{"@method", "@path", "origin-date", "signify-resource"}, result.Fields)
+	assert.NotZero(t, result.Created)
+}
+
+func TestVerifyRequestSignifyFormat(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+
+	r, err := http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	sd := NewSignatureData([]string{"@method", "@path"}, aid, priv, WithFormat(FormatSignify))
+	require.NoError(t, sd.SignRequest(r))
+	assert.Regexp(t, `^indexed="\?0";signify="0B[A-Za-z0-9_-]{86}"$`, r.Header.Get("signature"))
+
+	result, err := VerifyRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, aid, result.KeyID)
+}
+
+func TestVerifyRequestErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(r *http.Request)
+		opts   []VerifyOption
+		kind   error
+	}{
+		{
+			name:   "missing headers",
+			modify: func(r *http.Request) { r.Header.Del("signature") },
+			kind:   ErrMissingSignature,
+		},
+		{
+			name:   "tampered method",
+			modify: func(r *http.Request) { r.Method = "DELETE" },
+			kind:   ErrInvalidSignature,
+		},
+		{
+			name:   "tampered header",
+			modify: func(r *http.Request) { r.Header.Set("origin-date", "yesterday") },
+			kind:   ErrInvalidSignature,
+		},
+		{
+			name: "unsupported key prefix",
+			modify: func(r *http.Request) {
+				r.Header.Set("signature-input", `signify=("@method");created=1618884475;keyid="C`+TESTKey[1:]+`";alg="ed25519"`)
+			},
+			kind: ErrInvalidKey,
+		},
+		{
+			name: "unsupported algorithm",
+			modify: func(r *http.Request) {
+				r.Header.Set("signature-input", `signify=("@method");created=1618884475;keyid="`+TESTKey+`";alg="rsa-pss-sha512"`)
+			},
+			kind: ErrUnsupportedAlgorithm,
+		},
+		{
+			name: "malformed input",
+			modify: func(r *http.Request) {
+				r.Header.Set("signature-input", `signify="@method";created=1618884475`)
+			},
+			kind: ErrMalformedSignature,
+		},
+		{
+			name:   "required field not covered",
+			modify: func(r *http.Request) {},
+			opts:   []VerifyOption{WithRequiredFields("content-digest")},
+			kind:   ErrMissingField,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := newSignedRequest(t)
+			tc.modify(r)
+			_, err := VerifyRequest(r, tc.opts...)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tc.kind), "unexpected error: %s", err)
+			var verr *VerificationError
+			assert.True(t, errors.As(err, &verr))
+		})
+	}
+}
+
+var TESTKey = cesr.Encode(make([]byte, 32), "B")
+
+type failingSigner struct {
+	signer.Signer
+}
+
+func (failingSigner) Sign(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("hsm unavailable")
+}
+
+func TestSignRequestWithSigner(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	s := signer.NewInMemorySigner(priv, "")
+
+	r, err := http.NewRequest("GET", "https://example.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, NewSignatureDataWithSigner([]string{"@method"}, s).SignRequest(r))
+	result, err := VerifyRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, signer.AID(s), result.KeyID)
+
+	r, err = http.NewRequest("GET", "https://example.com/", nil)
+	require.NoError(t, err)
+	err = NewSignatureDataWithSigner([]string{"@method"}, failingSigner{s}).SignRequest(r)
+	assert.EqualError(t, err, "hsm unavailable")
+	assert.Empty(t, r.Header.Get("signature"))
+}
+
+func newGroup(t *testing.T, n int) ([]IndexedSigner, []string) {
+	var signers []IndexedSigner
+	var keys []string
+	for i := 0; i < n; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		signers = append(signers, IndexedSigner{Signer: signer.NewInMemorySigner(priv, "D"), Index: i})
+		keys = append(keys, cesr.Encode(pub, "D"))
+	}
+	return signers, keys
+}
+
+func TestVerifyRequestIndexed(t *testing.T) {
+	signers, keys := newGroup(t, 3)
+	group := cesr.Encode(make([]byte, 32), cesr.Blake3_256)
+
+	r, err := http.NewRequest("GET", "https://example.com/identifiers", nil)
+	require.NoError(t, err)
+	sd := NewIndexedSignatureData([]string{"@method", "@path"}, group, []IndexedSigner{signers[0], signers[2]})
+	require.NoError(t, sd.SignRequest(r))
+	assert.Regexp(t, `^indexed="\?1";signify="AA[A-Za-z0-9_-]{86}AC[A-Za-z0-9_-]{86}"$`, r.Header.Get("signature"))
+
+	result, err := VerifyRequest(r, WithSigningKeys(2, keys...))
+	require.NoError(t, err)
+	assert.Equal(t, group, result.KeyID)
+	assert.Equal(t, []int{0, 2}, result.Indices)
+	assert.Nil(t, result.PublicKey)
+
+	_, err = VerifyRequest(r, WithSigningKeys(3, keys...))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = VerifyRequest(r)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = VerifyRequest(r, WithSigningKeys(1, keys[:2]...))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	swapped := []string{keys[2], keys[1], keys[0]}
+	_, err = VerifyRequest(r, WithSigningKeys(1, swapped...))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestMultipleLabels(t *testing.T) {
+	r, aid := newSignedRequest(t, WithFormat(FormatRFC9421))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	gateway := cesr.Encode(pub, "B")
+	sd := NewSignatureData([]string{"@method", "@authority", "signature;key=\"signify\""}, gateway, priv,
+		WithLabel("proxy"), WithFormat(FormatSignify))
+	require.NoError(t, sd.SignRequest(r))
+
+	assert.Len(t, r.Header.Values("signature-input"), 1)
+	assert.Len(t, r.Header.Values("signature"), 1)
+	assert.Len(t, r.Header.Values("origin-date"), 1)
+	assert.Regexp(t, `^signify=\(.*;alg="ed25519", proxy=\(.*;alg="ed25519"$`, r.Header.Get("signature-input"))
+	assert.Regexp(t, `^signify=:.*:, indexed="\?0";proxy="0B.*"$`, r.Header.Get("signature"))
+
+	result, err := VerifyRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, aid, result.KeyID)
+
+	result, err = VerifyRequest(r, WithExpectedLabel("proxy"))
+	require.NoError(t, err)
+	assert.Equal(t, "proxy", result.Label)
+	assert.Equal(t, gateway, result.KeyID)
+
+	results, err := VerifyRequestAll(r)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "signify", results[0].Label)
+	assert.Equal(t, "proxy", results[1].Label)
+
+	_, err = VerifyRequest(r, WithExpectedLabel("other"))
+	assert.ErrorIs(t, err, ErrMissingSignature)
+
+	// re-signing a label replaces its signature
+	require.NoError(t, sd.SignRequest(r))
+	results, err = VerifyRequestAll(r)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	r.Header.Set("signify-resource", gateway)
+	_, err = VerifyRequestAll(r)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	assert.ErrorContains(t, err, "signature signify")
+}
+
+func TestMultipleLabelsSignifyFormat(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	aid := cesr.Encode(pub, "B")
+	r, err := http.NewRequest("GET", "https://example.com/", nil)
+	require.NoError(t, err)
+
+	for _, label := range []string{"signify", "proxy"} {
+		sd := NewSignatureData([]string{"@method"}, aid, priv, WithLabel(label), WithFormat(FormatSignify))
+		require.NoError(t, sd.SignRequest(r))
+	}
+	assert.Regexp(t, `^indexed="\?0";signify="0B[^"]+";proxy="0B[^"]+"$`, r.Header.Get("signature"))
+	results, err := VerifyRequestAll(r)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	signers, _ := newGroup(t, 1)
+	err = NewIndexedSignatureData([]string{"@method"}, aid, signers, WithLabel("group")).SignRequest(r)
+	assert.ErrorContains(t, err, "cannot share the indexed member")
+
+	err = NewSignatureData([]string{"@method"}, aid, priv, WithLabel("indexed")).SignRequest(r)
+	assert.ErrorContains(t, err, "reserved")
+}
+
+func TestVerifyDeferredReplay(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	r, _ := newSignedRequest(t)
+
+	result, err := VerifyRequest(r, WithReplayCache(cache), WithDeferredReplay())
+	require.NoError(t, err)
+	_, err = VerifyRequest(r, WithReplayCache(cache), WithDeferredReplay())
+	require.NoError(t, err, "nothing is recorded before Record")
+
+	require.NoError(t, result.Record(context.Background()))
+	assert.ErrorIs(t, result.Record(context.Background()), ErrReplayed)
+	_, err = VerifyRequest(r, WithReplayCache(cache))
+	assert.ErrorIs(t, err, ErrReplayed)
+
+	result, err = VerifyRequest(r)
+	require.NoError(t, err)
+	assert.NoError(t, result.Record(context.Background()), "no replay cache")
+}
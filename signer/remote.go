@@ -0,0 +1,129 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Backend is implemented by key stores that never release private keys, such
+// as a PKCS#11 session, a cloud KMS or a remote signing service. Keys are
+// referenced by an opaque handle.
+type Backend interface {
+	PublicKey(ctx context.Context, handle string) (ed25519.PublicKey, error)
+	Sign(ctx context.Context, handle string, msg []byte) ([]byte, error)
+}
+
+// BackendSigner is a Signer for a key held by a Backend.
+type BackendSigner struct {
+	backend   Backend
+	handle    string
+	code      string
+	publicKey ed25519.PublicKey
+}
+
+// NewBackendSigner looks up the public key for handle and returns a Signer
+// that forwards signing requests to backend. An empty code defaults to "B".
+func NewBackendSigner(ctx context.Context, backend Backend, handle string, code string) (*BackendSigner, error) {
+	publicKey, err := backend.PublicKey(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("backend returned a public key of length %d", len(publicKey))
+	}
+	if code == "" {
+		code = "B"
+	}
+	return &BackendSigner{backend: backend, handle: handle, code: code, publicKey: publicKey}, nil
+}
+
+func (s *BackendSigner) PublicKey() ed25519.PublicKey {
+	return s.publicKey
+}
+
+func (s *BackendSigner) Code() string {
+	return s.code
+}
+
+// Sign asks the backend for a signature and checks it against the public key,
+// so a misbehaving backend cannot produce requests that fail at the server.
+func (s *BackendSigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	sig, err := s.backend.Sign(ctx, s.handle, msg)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(s.publicKey, msg, sig) {
+		return nil, ErrInvalidSignature
+	}
+	return sig, nil
+}
+
+// HTTPBackend is a Backend for a remote signing service speaking JSON:
+//
+//	GET  {BaseURL}/keys/{handle}       -> {"publicKey": "<base64>"}
+//	POST {BaseURL}/keys/{handle}/sign  {"message": "<base64>"} -> {"signature": "<base64>"}
+type HTTPBackend struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type publicKeyResponse struct {
+	PublicKey []byte `json:"publicKey"`
+}
+
+type signRequest struct {
+	Message []byte `json:"message"`
+}
+
+type signResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+func (b *HTTPBackend) PublicKey(ctx context.Context, handle string) (ed25519.PublicKey, error) {
+	var resp publicKeyResponse
+	if err := b.do(ctx, "GET", "/keys/"+url.PathEscape(handle), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.PublicKey, nil
+}
+
+func (b *HTTPBackend) Sign(ctx context.Context, handle string, msg []byte) ([]byte, error) {
+	var resp signResponse
+	if err := b.do(ctx, "POST", "/keys/"+url.PathEscape(handle)+"/sign", signRequest{Message: msg}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+func (b *HTTPBackend) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.BaseURL+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signing service returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,77 @@
+package signer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSigningService is a local stand-in for a remote signing service or HSM.
+func fakeSigningService(t *testing.T, keys map[string]ed25519.PrivateKey, corrupt bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{handle}", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keys[r.PathValue("handle")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(publicKeyResponse{PublicKey: key.Public().(ed25519.PublicKey)})
+	})
+	mux.HandleFunc("POST /keys/{handle}/sign", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keys[r.PathValue("handle")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var req signRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig := ed25519.Sign(key, req.Message)
+		if corrupt {
+			sig[0] ^= 0xff
+		}
+		json.NewEncoder(w).Encode(signResponse{Signature: sig})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBackendSigner(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	server := fakeSigningService(t, map[string]ed25519.PrivateKey{"api-key/1": priv}, false)
+	ctx := context.Background()
+
+	s, err := NewBackendSigner(ctx, &HTTPBackend{BaseURL: server.URL}, "api-key/1", "")
+	require.NoError(t, err)
+	assert.Equal(t, pub, s.PublicKey())
+	assert.Equal(t, "B", s.Code())
+
+	sig, err := s.Sign(ctx, []byte("msg"))
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(pub, []byte("msg"), sig))
+
+	_, err = NewBackendSigner(ctx, &HTTPBackend{BaseURL: server.URL}, "unknown", "")
+	assert.Error(t, err)
+}
+
+func TestBackendSignerRejectsBadSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	server := fakeSigningService(t, map[string]ed25519.PrivateKey{"k": priv}, true)
+
+	s, err := NewBackendSigner(context.Background(), &HTTPBackend{BaseURL: server.URL}, "k", "")
+	require.NoError(t, err)
+	_, err = s.Sign(context.Background(), []byte("msg"))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
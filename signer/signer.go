@@ -0,0 +1,64 @@
+// Package signer abstracts the key that signs requests so that it can live in
+// memory, in an HSM or KMS, or behind a remote signing service.
+package signer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+)
+
+var ErrInvalidSignature = errors.New("signer returned an invalid signature")
+
+// Signer produces Ed25519 signatures for a public key without exposing the
+// private key.
+type Signer interface {
+	// PublicKey returns the raw Ed25519 verification key.
+	PublicKey() ed25519.PublicKey
+	// Code returns the CESR code of the public key, such as "B".
+	Code() string
+	// Sign signs msg with the private key.
+	Sign(ctx context.Context, msg []byte) ([]byte, error)
+}
+
+// AID returns the CESR-encoded public key of s, which identifies the signer in
+// keyid and signify-resource.
+func AID(s Signer) string {
+	return cesr.Encode(s.PublicKey(), s.Code())
+}
+
+// InMemorySigner signs with an ed25519.PrivateKey held in memory.
+type InMemorySigner struct {
+	privateKey ed25519.PrivateKey
+	code       string
+}
+
+// NewInMemorySigner returns a Signer for privateKey. An empty code defaults to
+// "B", the non-transferable Ed25519 prefix.
+func NewInMemorySigner(privateKey ed25519.PrivateKey, code string) *InMemorySigner {
+	if code == "" {
+		code = "B"
+	}
+	return &InMemorySigner{privateKey: privateKey, code: code}
+}
+
+func (s *InMemorySigner) PublicKey() ed25519.PublicKey {
+	if len(s.privateKey) != ed25519.PrivateKeySize {
+		return nil
+	}
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+func (s *InMemorySigner) Code() string {
+	return s.code
+}
+
+func (s *InMemorySigner) Sign(_ context.Context, msg []byte) ([]byte, error) {
+	if len(s.privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(s.privateKey))
+	}
+	return ed25519.Sign(s.privateKey, msg), nil
+}
@@ -0,0 +1,35 @@
+package signer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/Wavecrest/httpsigcesr/cesr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemorySigner(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s := NewInMemorySigner(priv, "")
+	assert.Equal(t, "B", s.Code())
+	assert.Equal(t, pub, s.PublicKey())
+	assert.Equal(t, cesr.Encode(pub, "B"), AID(s))
+
+	sig, err := s.Sign(context.Background(), []byte("msg"))
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(pub, []byte("msg"), sig))
+
+	assert.Equal(t, "D", NewInMemorySigner(priv, "D").Code())
+}
+
+func TestInMemorySignerInvalidKey(t *testing.T) {
+	s := NewInMemorySigner(nil, "")
+	assert.Nil(t, s.PublicKey())
+	_, err := s.Sign(context.Background(), []byte("msg"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,368 @@
+package structuredfields
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseList parses a List field value. Multiple field lines must be joined
+// with ", " before parsing.
+func ParseList(s string) (List, error) {
+	p := &parser{s: s}
+	p.discardSP()
+	l, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	return l, p.finish()
+}
+
+// ParseDictionary parses a Dictionary field value. Multiple field lines must be
+// joined with ", " before parsing.
+func ParseDictionary(s string) (Dictionary, error) {
+	p := &parser{s: s}
+	p.discardSP()
+	d, err := p.parseDictionary()
+	if err != nil {
+		return nil, err
+	}
+	return d, p.finish()
+}
+
+// ParseItem parses an Item field value.
+func ParseItem(s string) (Item, error) {
+	p := &parser{s: s}
+	p.discardSP()
+	i, err := p.parseItem()
+	if err != nil {
+		return Item{}, err
+	}
+	return i, p.finish()
+}
+
+// ParseInnerList parses a single InnerList, such as the value of a
+// signature-input member.
+func ParseInnerList(s string) (InnerList, error) {
+	p := &parser{s: s}
+	p.discardSP()
+	l, err := p.parseInnerList()
+	if err != nil {
+		return InnerList{}, err
+	}
+	return l, p.finish()
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("structuredfields: %s at offset %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) discardSP() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) discardOWS() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) finish() error {
+	p.discardSP()
+	if !p.eof() {
+		return p.errorf("unexpected trailing characters")
+	}
+	return nil
+}
+
+func (p *parser) parseList() (List, error) {
+	l := List{}
+	for !p.eof() {
+		m, err := p.parseItemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, m)
+		if err := p.parseSeparator(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) parseDictionary() (Dictionary, error) {
+	d := Dictionary{}
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var m Member
+		if p.peek() == '=' {
+			p.pos++
+			m, err = p.parseItemOrInnerList()
+		} else {
+			var params Params
+			params, err = p.parseParams()
+			m = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.Set(key, m)
+		if err := p.parseSeparator(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// parseSeparator consumes the ", " between list or dictionary members.
+func (p *parser) parseSeparator() error {
+	p.discardOWS()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.errorf("expected ','")
+	}
+	p.pos++
+	p.discardOWS()
+	if p.eof() {
+		return p.errorf("trailing ','")
+	}
+	return nil
+}
+
+func (p *parser) parseItemOrInnerList() (Member, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+	return p.parseItem()
+}
+
+func (p *parser) parseInnerList() (InnerList, error) {
+	if p.peek() != '(' {
+		return InnerList{}, p.errorf("expected '('")
+	}
+	p.pos++
+	l := InnerList{Items: []Item{}}
+	for !p.eof() {
+		p.discardSP()
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParams()
+			if err != nil {
+				return InnerList{}, err
+			}
+			l.Params = params
+			return l, nil
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return InnerList{}, err
+		}
+		l.Items = append(l.Items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, p.errorf("expected ' ' or ')'")
+		}
+	}
+	return InnerList{}, p.errorf("unterminated inner list")
+}
+
+func (p *parser) parseItem() (Item, error) {
+	v, err := p.parseBareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: v, Params: params}, nil
+}
+
+func (p *parser) parseParams() (Params, error) {
+	var params Params
+	for p.peek() == ';' {
+		p.pos++
+		p.discardSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var v interface{} = true
+		if p.peek() == '=' {
+			p.pos++
+			v, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params.Set(key, v)
+	}
+	return params, nil
+}
+
+func (p *parser) parseKey() (string, error) {
+	if c := p.peek(); !isLcAlpha(c) && c != '*' {
+		return "", p.errorf("invalid key")
+	}
+	start := p.pos
+	for !p.eof() && isKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *parser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case isAlpha(c) || c == '*':
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	default:
+		return nil, p.errorf("unexpected character %q", c)
+	}
+}
+
+func (p *parser) parseNumber() (interface{}, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("expected digit")
+	}
+	digitsStart := p.pos
+	decimal := false
+	for !p.eof() {
+		c := p.s[p.pos]
+		if isDigit(c) {
+			p.pos++
+		} else if c == '.' && !decimal {
+			if p.pos-digitsStart > 12 {
+				return nil, p.errorf("decimal integer part too long")
+			}
+			decimal = true
+			p.pos++
+		} else {
+			break
+		}
+		if !decimal && p.pos-digitsStart > 15 {
+			return nil, p.errorf("integer too long")
+		}
+		if decimal && p.pos-digitsStart > 16 {
+			return nil, p.errorf("decimal too long")
+		}
+	}
+	num := p.s[start:p.pos]
+	if !decimal {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	if strings.HasSuffix(num, ".") {
+		return nil, p.errorf("decimal must not end with '.'")
+	}
+	if len(num)-strings.IndexByte(num, '.')-1 > 3 {
+		return nil, p.errorf("decimal has too many fractional digits")
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *parser) parseString() (string, error) {
+	p.pos++
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated escape")
+			}
+			next := p.s[p.pos]
+			if next != '"' && next != '\\' {
+				return "", p.errorf("invalid escape")
+			}
+			sb.WriteByte(next)
+			p.pos++
+		case c == '"':
+			return sb.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in string")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) parseToken() Token {
+	start := p.pos
+	p.pos++
+	for !p.eof() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return Token(p.s[start:p.pos])
+}
+
+func (p *parser) parseByteSequence() ([]byte, error) {
+	p.pos++
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	content := p.s[p.pos : p.pos+end]
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			return nil, p.errorf("invalid character in byte sequence")
+		}
+	}
+	p.pos += end + 1
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(content, "="))
+	if err != nil {
+		return nil, p.errorf("invalid byte sequence: %s", err)
+	}
+	return b, nil
+}
+
+func (p *parser) parseBoolean() (bool, error) {
+	p.pos++
+	switch p.peek() {
+	case '1':
+		p.pos++
+		return true, nil
+	case '0':
+		p.pos++
+		return false, nil
+	default:
+		return false, p.errorf("invalid boolean")
+	}
+}
@@ -0,0 +1,127 @@
+package structuredfields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseItem(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Item
+	}{
+		{"42", Item{Value: int64(42)}},
+		{"-42", Item{Value: int64(-42)}},
+		{"4.5", Item{Value: 4.5}},
+		{"-0.125", Item{Value: -0.125}},
+		{`"hello \"world\""`, Item{Value: `hello "world"`}},
+		{"foo123/456", Item{Value: Token("foo123/456")}},
+		{"*foo", Item{Value: Token("*foo")}},
+		{":aGVsbG8=:", Item{Value: []byte("hello")}},
+		{":aGVsbG8:", Item{Value: []byte("hello")}},
+		{"::", Item{Value: []byte{}}},
+		{"?1", Item{Value: true}},
+		{"?0", Item{Value: false}},
+		{"  1;a;b=?0;c=\"x\"  ", Item{Value: int64(1), Params: Params{{"a", true}, {"b", false}, {"c", "x"}}}},
+		{"1;a=1;a=2", Item{Value: int64(1), Params: Params{{"a", int64(2)}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := ParseItem(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestParseItemErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"1 2",
+		"1234567890123456",
+		"1234567890123.0",
+		"1.1234",
+		"1.",
+		"-",
+		`"unterminated`,
+		`"bad \escape"`,
+		"\"tab\there\"",
+		":aGVsbG8",
+		":aGVs*G8=:",
+		"?2",
+		"1;A=1",
+		"é",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseItem(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	result, err := ParseList(`sugar, tea;x=1,	(rum "cola");alcohol, ()`)
+	require.NoError(t, err)
+	assert.Equal(t, List{
+		Item{Value: Token("sugar")},
+		Item{Value: Token("tea"), Params: Params{{"x", int64(1)}}},
+		InnerList{
+			Items:  []Item{{Value: Token("rum")}, {Value: "cola"}},
+			Params: Params{{"alcohol", true}},
+		},
+		InnerList{Items: []Item{}},
+	}, result)
+
+	empty, err := ParseList("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	for _, input := range []string{"a,", "a b", "(a b", "(a,b)", "a,,b"} {
+		_, err := ParseList(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseDictionary(t *testing.T) {
+	result, err := ParseDictionary(`a=1, b, c;x=?0, d=(1 2);y, a=3`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, result.Keys())
+
+	a, _ := result.Get("a")
+	assert.Equal(t, Item{Value: int64(3)}, a)
+	b, _ := result.Get("b")
+	assert.Equal(t, Item{Value: true}, b)
+	c, _ := result.Get("c")
+	assert.Equal(t, Item{Value: true, Params: Params{{"x", false}}}, c)
+	d, _ := result.Get("d")
+	assert.Equal(t, InnerList{
+		Items:  []Item{{Value: int64(1)}, {Value: int64(2)}},
+		Params: Params{{"y", true}},
+	}, d)
+
+	for _, input := range []string{"A=1", "a=1,", "a=1 b=2", "a=", "1=a"} {
+		_, err := ParseDictionary(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseSignatureHeaders(t *testing.T) {
+	input, err := ParseDictionary(`sig1=("@method" "@path" "content-digest");created=1618884475;keyid="test-key"`)
+	require.NoError(t, err)
+	m, ok := input.Get("sig1")
+	require.True(t, ok)
+	list := m.(InnerList)
+	assert.Len(t, list.Items, 3)
+	assert.Equal(t, "@path", list.Items[1].Value)
+	created, _ := list.Params.Get("created")
+	assert.Equal(t, int64(1618884475), created)
+
+	legacy, err := ParseDictionary(`indexed="?0";signify="0BAAAA"`)
+	require.NoError(t, err)
+	m, ok = legacy.Get("indexed")
+	require.True(t, ok)
+	sig, _ := m.(Item).Params.Get("signify")
+	assert.Equal(t, "0BAAAA", sig)
+}
@@ -0,0 +1,360 @@
+// Package structuredfields implements the Structured Field Values for HTTP
+// defined in RFC 8941.
+//
+// Bare items are represented with Go values: int64 (or int) for Integers,
+// float64 for Decimals, string for Strings, Token for Tokens, []byte for Byte
+// Sequences and bool for Booleans.
+package structuredfields
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Token is a short textual bare item, serialized without quotes.
+type Token string
+
+// Param is a single key/value parameter attached to an Item or InnerList.
+type Param struct {
+	Key   string
+	Value interface{}
+}
+
+// Params is an ordered set of parameters.
+type Params []Param
+
+// Get returns the value of the parameter with the given key.
+func (p Params) Get(key string) (interface{}, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of an existing parameter or appends a new one.
+func (p *Params) Set(key string, value interface{}) {
+	for i := range *p {
+		if (*p)[i].Key == key {
+			(*p)[i].Value = value
+			return
+		}
+	}
+	*p = append(*p, Param{Key: key, Value: value})
+}
+
+// Member is a List or Dictionary member: either an Item or an InnerList.
+type Member interface {
+	isMember()
+}
+
+// Item is a bare item with parameters.
+type Item struct {
+	Value  interface{}
+	Params Params
+}
+
+// InnerList is a parenthesized list of Items with parameters.
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) isMember()      {}
+func (InnerList) isMember() {}
+
+// List is an ordered sequence of members.
+type List []Member
+
+// DictMember is a single keyed entry of a Dictionary.
+type DictMember struct {
+	Key   string
+	Value Member
+}
+
+// Dictionary is an ordered map of keys to members.
+type Dictionary []DictMember
+
+// Get returns the member with the given key.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of an existing member, keeping its position, or
+// appends a new one.
+func (d *Dictionary) Set(key string, value Member) {
+	for i := range *d {
+		if (*d)[i].Key == key {
+			(*d)[i].Value = value
+			return
+		}
+	}
+	*d = append(*d, DictMember{Key: key, Value: value})
+}
+
+// Delete removes the member with the given key, if present.
+func (d *Dictionary) Delete(key string) {
+	for i := range *d {
+		if (*d)[i].Key == key {
+			*d = append((*d)[:i], (*d)[i+1:]...)
+			return
+		}
+	}
+}
+
+// Keys returns the member keys in order.
+func (d Dictionary) Keys() []string {
+	keys := make([]string, len(d))
+	for i, m := range d {
+		keys[i] = m.Key
+	}
+	return keys
+}
+
+// SerializeList serializes a List.
+func SerializeList(l List) (string, error) {
+	var sb strings.Builder
+	for i, m := range l {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if err := serializeMember(&sb, m); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// SerializeDictionary serializes a Dictionary.
+func SerializeDictionary(d Dictionary) (string, error) {
+	var sb strings.Builder
+	for i, m := range d {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if err := serializeKey(&sb, m.Key); err != nil {
+			return "", err
+		}
+		if item, ok := m.Value.(Item); ok && item.Value == true {
+			if err := serializeParams(&sb, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		sb.WriteByte('=')
+		if err := serializeMember(&sb, m.Value); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// SerializeItem serializes an Item.
+func SerializeItem(i Item) (string, error) {
+	var sb strings.Builder
+	if err := serializeItem(&sb, i); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// SerializeInnerList serializes an InnerList.
+func SerializeInnerList(l InnerList) (string, error) {
+	var sb strings.Builder
+	if err := serializeInnerList(&sb, l); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// SerializeParams serializes parameters on their own, including the leading ";".
+func SerializeParams(p Params) (string, error) {
+	var sb strings.Builder
+	if err := serializeParams(&sb, p); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func serializeMember(sb *strings.Builder, m Member) error {
+	switch v := m.(type) {
+	case Item:
+		return serializeItem(sb, v)
+	case InnerList:
+		return serializeInnerList(sb, v)
+	default:
+		return fmt.Errorf("structuredfields: unsupported member type %T", m)
+	}
+}
+
+func serializeInnerList(sb *strings.Builder, l InnerList) error {
+	sb.WriteByte('(')
+	for i, item := range l.Items {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if err := serializeItem(sb, item); err != nil {
+			return err
+		}
+	}
+	sb.WriteByte(')')
+	return serializeParams(sb, l.Params)
+}
+
+func serializeItem(sb *strings.Builder, i Item) error {
+	if err := serializeBareItem(sb, i.Value); err != nil {
+		return err
+	}
+	return serializeParams(sb, i.Params)
+}
+
+func serializeParams(sb *strings.Builder, p Params) error {
+	for _, param := range p {
+		sb.WriteByte(';')
+		if err := serializeKey(sb, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		sb.WriteByte('=')
+		if err := serializeBareItem(sb, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serializeKey(sb *strings.Builder, key string) error {
+	if !isValidKey(key) {
+		return fmt.Errorf("structuredfields: invalid key %q", key)
+	}
+	sb.WriteString(key)
+	return nil
+}
+
+func isValidKey(key string) bool {
+	if key == "" || !(isLcAlpha(key[0]) || key[0] == '*') {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func serializeBareItem(sb *strings.Builder, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		return serializeInteger(sb, int64(v))
+	case int64:
+		return serializeInteger(sb, v)
+	case float64:
+		return serializeDecimal(sb, v)
+	case string:
+		return serializeString(sb, v)
+	case Token:
+		return serializeToken(sb, v)
+	case []byte:
+		sb.WriteByte(':')
+		sb.WriteString(base64.StdEncoding.EncodeToString(v))
+		sb.WriteByte(':')
+		return nil
+	case bool:
+		if v {
+			sb.WriteString("?1")
+		} else {
+			sb.WriteString("?0")
+		}
+		return nil
+	default:
+		return fmt.Errorf("structuredfields: unsupported bare item type %T", v)
+	}
+}
+
+const maxInteger = 999_999_999_999_999
+
+func serializeInteger(sb *strings.Builder, v int64) error {
+	if v > maxInteger || v < -maxInteger {
+		return fmt.Errorf("structuredfields: integer %d out of range", v)
+	}
+	sb.WriteString(strconv.FormatInt(v, 10))
+	return nil
+}
+
+func serializeDecimal(sb *strings.Builder, v float64) error {
+	rounded := math.RoundToEven(v*1000) / 1000
+	if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(rounded) >= 1e12 {
+		return fmt.Errorf("structuredfields: decimal %v out of range", v)
+	}
+	s := strconv.FormatFloat(rounded, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	sb.WriteString(s)
+	return nil
+}
+
+func serializeString(sb *strings.Builder, s string) error {
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("structuredfields: invalid character %q in string", c)
+		}
+		if c == '"' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('"')
+	return nil
+}
+
+func serializeToken(sb *strings.Builder, t Token) error {
+	if t == "" || !(isAlpha(t[0]) || t[0] == '*') {
+		return fmt.Errorf("structuredfields: invalid token %q", t)
+	}
+	for i := 1; i < len(t); i++ {
+		if !isTokenChar(t[i]) {
+			return fmt.Errorf("structuredfields: invalid token %q", t)
+		}
+	}
+	sb.WriteString(string(t))
+	return nil
+}
+
+func isLcAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLcAlpha(c) || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isKeyChar(c byte) bool {
+	return isLcAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+// isTokenChar reports whether c is a tchar, ":" or "/".
+func isTokenChar(c byte) bool {
+	if isAlpha(c) || isDigit(c) {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
@@ -0,0 +1,87 @@
+package structuredfields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeItem(t *testing.T) {
+	testCases := []struct {
+		item     Item
+		expected string
+	}{
+		{Item{Value: 42}, "42"},
+		{Item{Value: int64(-7)}, "-7"},
+		{Item{Value: 1.5}, "1.5"},
+		{Item{Value: 2.0}, "2.0"},
+		{Item{Value: 0.0005}, "0.0"},
+		{Item{Value: 0.0015}, "0.002"},
+		{Item{Value: `say "hi" \o/`}, `"say \"hi\" \\o/"`},
+		{Item{Value: Token("text/html")}, "text/html"},
+		{Item{Value: []byte("hello")}, ":aGVsbG8=:"},
+		{Item{Value: true}, "?1"},
+		{Item{Value: false, Params: Params{{"a", true}, {"b", 1}}}, "?0;a;b=1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			result, err := SerializeItem(tc.item)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestSerializeErrors(t *testing.T) {
+	for _, item := range []Item{
+		{Value: int64(1_000_000_000_000_000)},
+		{Value: 1e12},
+		{Value: "tab\t"},
+		{Value: Token("1abc")},
+		{Value: Token("a b")},
+		{Value: struct{}{}},
+		{Value: 1, Params: Params{{"Bad", true}}},
+	} {
+		_, err := SerializeItem(item)
+		assert.Error(t, err, "%#v", item)
+	}
+}
+
+func TestSerializeDictionary(t *testing.T) {
+	d := Dictionary{}
+	d.Set("a", Item{Value: 1})
+	d.Set("b", Item{Value: true, Params: Params{{"x", Token("y")}}})
+	d.Set("c", InnerList{Items: []Item{{Value: "one"}, {Value: "two"}}, Params: Params{{"created", 1}}})
+	d.Set("a", Item{Value: 2})
+
+	result, err := SerializeDictionary(d)
+	require.NoError(t, err)
+	assert.Equal(t, `a=2, b;x=y, c=("one" "two");created=1`, result)
+
+	d.Delete("b")
+	result, err = SerializeDictionary(d)
+	require.NoError(t, err)
+	assert.Equal(t, `a=2, c=("one" "two");created=1`, result)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		`sig1=("@method" "@authority" "content-digest";req);created=1618884473;keyid="test-key-ed25519", sig2=()`,
+		`sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:, sha-512=:AAAA:`,
+		`indexed="?0";signify="0BAAAA"`,
+		`a, b=?0, c=-1.25;d`,
+	} {
+		d, err := ParseDictionary(input)
+		require.NoError(t, err)
+		result, err := SerializeDictionary(d)
+		require.NoError(t, err)
+		assert.Equal(t, input, result)
+	}
+
+	l, err := ParseList(`a;q=0.5, ("x" :AQID:), ?1`)
+	require.NoError(t, err)
+	result, err := SerializeList(l)
+	require.NoError(t, err)
+	assert.Equal(t, `a;q=0.5, ("x" :AQID:), ?1`, result)
+}